@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	fslock "github.com/ipfs/go-fs-lock"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	prometheuspush "github.com/prometheus/client_golang/prometheus/push"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
+)
+
+type daemonJob struct {
+	cmd         *ufcli.Command
+	interval    *int
+	followedBy  []*daemonJob // triggered right after every run of this job
+	trigger     chan struct{}
+	log         ufcli.Logger
+	promJobName string
+}
+
+var (
+	daemonPollInterval    int
+	daemonTrackInterval   int
+	daemonSignInterval    int
+	daemonProposeInterval int
+	daemonStatusInterval  int
+	daemonAllocInterval   int
+	daemonGracePeriod     int
+
+	// closed by stopDaemon(), no new job runs are started afterwards
+	daemonStopping     = make(chan struct{})
+	daemonStoppingOnce sync.Once
+	daemonRunning      sync.WaitGroup
+)
+
+var daemon = &ufcli.Command{
+	Usage: "Run all background processes on a schedule within a single long-lived process",
+	Name:  "daemon",
+	Flags: append(
+		[]ufcli.Flag{
+			&ufcli.IntFlag{
+				Name:        "poll-providers-interval",
+				Usage:       "Amount of seconds between poll-providers runs",
+				Value:       60,
+				Destination: &daemonPollInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "track-deals-interval",
+				Usage:       "Amount of seconds between track-deals runs, which only process the market state changes since the previous run",
+				Value:       30,
+				Destination: &daemonTrackInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "sign-pending-interval",
+				Usage:       "Amount of seconds between sign-pending runs",
+				Value:       60,
+				Destination: &daemonSignInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "propose-pending-interval",
+				Usage:       "Amount of seconds between propose-pending runs, in addition to the run following every sign-pending",
+				Value:       60,
+				Destination: &daemonProposeInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "poll-deal-status-interval",
+				Usage:       "Amount of seconds between poll-deal-status runs",
+				Value:       900,
+				Destination: &daemonStatusInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "track-allocations-interval",
+				Usage:       "Amount of seconds between track-allocations runs",
+				Value:       300,
+				Destination: &daemonAllocInterval,
+			},
+			&ufcli.IntFlag{
+				Name:        "shutdown-grace-period",
+				Usage:       "Amount of seconds to wait for in-progress runs to finish on shutdown, before aborting them",
+				Value:       300,
+				Destination: &daemonGracePeriod,
+			},
+		},
+		// the per-command settings apply to the daemon runs as well
+		flagsOf(pollProviders, proposePending, pollDealStatus)...,
+	),
+	Action: func(cctx *ufcli.Context) error {
+		ctx, log, _, _ := app.UnpackCtx(cctx.Context)
+
+		propose := newDaemonJob(cctx, proposePending, &daemonProposeInterval)
+		jobs := []*daemonJob{
+			newDaemonJob(cctx, pollProviders, &daemonPollInterval),
+			newDaemonJob(cctx, trackDeals, &daemonTrackInterval),
+			newDaemonJob(cctx, signPending, &daemonSignInterval, propose),
+			propose,
+			newDaemonJob(cctx, pollDealStatus, &daemonStatusInterval),
+			newDaemonJob(cctx, trackAllocations, &daemonAllocInterval),
+		}
+
+		for _, j := range jobs {
+			if *j.interval <= 0 {
+				return xerrors.Errorf("interval for %s must be a positive amount of seconds, not %d", j.cmd.Name, *j.interval)
+			}
+		}
+
+		for _, j := range jobs {
+			j := j
+			daemonRunning.Add(1)
+			go func() {
+				defer daemonRunning.Done()
+				j.loop(cctx)
+			}()
+		}
+
+		log.Infof("daemon started, scheduling %d jobs", len(jobs))
+		daemonRunning.Wait()
+
+		if ctx.Err() != nil {
+			log.Warn("daemon stopped with job runs aborted")
+		} else {
+			log.Info("daemon stopped")
+		}
+		return nil
+	},
+}
+
+// stopDaemon is executed before the top context is cancelled: it prevents
+// new job runs and waits a bit for the ones in progress to complete
+func stopDaemon() error {
+	daemonStoppingOnce.Do(func() { close(daemonStopping) })
+
+	done := make(chan struct{})
+	go func() {
+		daemonRunning.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(time.Duration(daemonGracePeriod) * time.Second):
+		return xerrors.Errorf("job runs still in progress after %d seconds, aborting", daemonGracePeriod)
+	}
+}
+
+func newDaemonJob(cctx *ufcli.Context, cmd *ufcli.Command, interval *int, followedBy ...*daemonJob) *daemonJob {
+	return &daemonJob{
+		cmd:         cmd,
+		interval:    interval,
+		followedBy:  followedBy,
+		trigger:     make(chan struct{}, 1),
+		log:         logging.Logger(fmt.Sprintf("%s(%d)/%s", cctx.App.Name, os.Getpid(), cmd.Name)),
+		promJobName: promStr(cctx.App.Name + "_" + cmd.Name),
+	}
+}
+
+func (j *daemonJob) loop(cctx *ufcli.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-daemonStopping:
+			return
+		case <-cctx.Context.Done():
+			return
+		case <-timer.C:
+		case <-j.trigger:
+			timer.Stop()
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		// a stop could have been requested while we were waiting
+		select {
+		case <-daemonStopping:
+			return
+		default:
+		}
+
+		started := time.Now()
+		j.run(cctx)
+
+		for _, f := range j.followedBy {
+			// at most one pending trigger: a job already scheduled to run does not need another
+			select {
+			case f.trigger <- struct{}{}:
+			default:
+			}
+		}
+
+		timer.Reset(time.Until(started.Add(time.Duration(*j.interval) * time.Second)))
+	}
+}
+
+// run executes a single instance of the job, holding the same lock a standalone
+// invocation of the command would, so that crontab leftovers do not overlap
+func (j *daemonJob) run(cctx *ufcli.Context) {
+	lock, err := fslock.Lock(os.TempDir(), promStr(cctx.App.Name)+"-"+promStr(j.cmd.Name))
+	if err != nil {
+		if errors.As(err, new(fslock.LockedError)) {
+			j.log.Warnf("skipping '%s' run: already in progress in a different process", j.cmd.Name)
+		} else {
+			j.log.Errorf("unable to obtain '%s' lock: %s", j.cmd.Name, err)
+		}
+		return
+	}
+	defer lock.Close() //nolint:errcheck
+
+	j.log.Infow(fmt.Sprintf("=== BEGIN '%s' run", j.cmd.Name))
+	t0 := time.Now()
+
+	// the job sees its own logger, otherwise the context is shared: same DB pool and lotus clients
+	gctx := app.GetGlobalCtx(cctx.Context)
+	gctx.Logger = j.log
+	jcctx := *cctx
+	jcctx.Context = app.WithGlobalCtx(cctx.Context, gctx)
+
+	err = func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = xerrors.Errorf("panic encountered: %s\n%s", r, debug.Stack())
+			}
+		}()
+		return j.cmd.Action(&jcctx)
+	}()
+
+	took := time.Since(t0).Truncate(time.Millisecond)
+	logHdr := fmt.Sprintf("=== FINISH '%s' run", j.cmd.Name)
+	if err != nil {
+		j.log.Errorf("%+v", err)
+		j.log.Warnw(logHdr, "success", false, "took", took.String())
+	} else {
+		j.log.Infow(logHdr, "success", true, "took", took.String())
+	}
+
+	j.pushMetrics(cctx, took, err == nil)
+}
+
+// pushMetrics emits the same gauges ufcli pushes at the end of a standalone command
+func (j *daemonJob) pushMetrics(cctx *ufcli.Context, took time.Duration, wasSuccess bool) {
+	pushURL := cctx.String("prometheus_push_url")
+	if pushURL == "" {
+		return
+	}
+
+	tookGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_run_time", j.promJobName),
+		Help: "How long did the job take (in milliseconds)",
+	})
+	tookGauge.Set(float64(took.Milliseconds()))
+	successGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_success", j.promJobName),
+		Help: "Whether the job completed with success(1) or failure(0)",
+	})
+	if wasSuccess {
+		successGauge.Set(1)
+	}
+
+	p := prometheuspush.New(pushURL, promStr(j.cmd.Name))
+	if inst := cctx.String("prometheus_instance"); inst != "" {
+		p = p.Grouping("instance", promStr(inst))
+	}
+	if user := cctx.String("prometheus_push_user"); user != "" {
+		p = p.BasicAuth(user, cctx.String("prometheus_push_pass"))
+	}
+	if err := p.Collector(tookGauge).Collector(successGauge).Push(); err != nil {
+		j.log.Warnf("push of prometheus metrics to '%s' failed: %s", pushURL, err)
+	}
+}
+
+func flagsOf(cmds ...*ufcli.Command) []ufcli.Flag {
+	var fl []ufcli.Flag
+	for _, c := range cmds {
+		fl = append(fl, c.Flags...)
+	}
+	return fl
+}
+
+// same as within ufcli: used for lock names and metric names
+var nonAlphanumericRun = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+func promStr(s string) string {
+	return nonAlphanumericRun.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/keystore"
+	"golang.org/x/xerrors"
+)
+
+var keystoreImport = &ufcli.Command{
+	Usage:     "Import a client key, as produced by `lotus wallet export`, into the signer keystore",
+	Name:      "keystore-import",
+	ArgsUsage: " < exported-key-file",
+	Flags:     []ufcli.Flag{},
+	Action: func(cctx *ufcli.Context) error {
+		_, log, _, _ := app.UnpackCtx(cctx.Context)
+
+		dir := cctx.String("signer-keystore-dir")
+		if dir == "" {
+			return xerrors.New("signer-keystore-dir must be set")
+		}
+		ks, err := keystore.Open(dir, cctx.String("signer-keystore-passphrase"))
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		exported, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && exported == "" {
+			return xerrors.Errorf("unable to read exported key from STDIN: %w", err)
+		}
+
+		addr, err := ks.ImportExported(exported)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		log.Infow("imported key", "address", addr.String())
+		return nil
+	},
+}
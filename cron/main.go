@@ -33,9 +33,17 @@ func main() {
 				trackDeals,
 				signPending,
 				proposePending,
+				pollDealStatus,
+				trackAllocations,
+				daemon,
+				keystoreImport,
 			},
-			Flags: app.CommonFlags,
+			Flags: append(
+				append(append(app.CommonFlags, app.HeavyFlags...), app.HeavyTokenFlags...),
+				app.SignerFlags...,
+			),
 		},
-		GlobalInit: app.GlobalInit,
+		GlobalInit:     app.GlobalInit,
+		BeforeShutdown: stopDaemon,
 	}).RunAndExit(context.Background())
 }
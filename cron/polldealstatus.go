@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/google/uuid"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/ribasushi/go-toolbox-interplanetary/lp2p"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/filtypes"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+type dealStatusPending struct {
+	ProposalUUID    uuid.UUID
+	ProposalPayload filmarket.DealProposal
+	ProposalCid     string
+	PeerID          *lp2p.PeerID
+	Multiaddrs      []string
+}
+
+// recordedDealStatus is what ends up in proposal_meta.deal_status
+type recordedDealStatus struct {
+	CheckedAt     time.Time `json:"checked_at"`
+	Checkpoint    string    `json:"checkpoint"`
+	SealingStatus string    `json:"sealing_status,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	AwaitingData  bool      `json:"awaiting_offline_data,omitempty"`
+	BytesReceived uint64    `json:"bytes_received,omitempty"`
+	PublishCid    *string   `json:"publish_cid,omitempty"`
+	ChainDealID   uint64    `json:"chain_deal_id,omitempty"`
+}
+
+type statusTotals struct {
+	queried *int32
+	failed  *int32
+}
+
+var (
+	statusQueryTimeout int
+	statusPerSpTimeout int
+)
+var pollDealStatus = &ufcli.Command{
+	Usage: "Query providers for the state of delivered deals not yet active on chain",
+	Name:  "poll-deal-status",
+	Flags: []ufcli.Flag{
+		&ufcli.IntFlag{
+			Name:        "status-query-timeout",
+			Usage:       "Amount of seconds before aborting a specific status query",
+			Value:       30,
+			Destination: &statusQueryTimeout,
+		},
+		&ufcli.IntFlag{
+			Name:        "status-per-sp-timeout",
+			Usage:       "Amount of seconds status queries for specific SP could take in total",
+			Value:       270, // 4.5 mins
+			Destination: &statusPerSpTimeout,
+		},
+	},
+	Action: func(cctx *ufcli.Context) error {
+		ctx, log, db, _ := app.UnpackCtx(cctx.Context)
+
+		signer, err := clientSigner(cctx)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		tot := statusTotals{
+			queried: new(int32),
+			failed:  new(int32),
+		}
+		var countProviders, countProposals int
+		defer func() {
+			log.Infow("summary",
+				"uniqueProviders", countProviders,
+				"proposals", countProposals,
+				"successful", atomic.LoadInt32(tot.queried),
+				"failed", atomic.LoadInt32(tot.failed),
+			)
+		}()
+
+		pending := make([]dealStatusPending, 0, 2048)
+		if err := pgxscan.Select(
+			ctx,
+			db,
+			&pending,
+			`
+			SELECT
+					pr.proposal_uuid,
+					pr.proposal_meta->'filmarket_proposal' AS proposal_payload,
+					pr.proposal_meta->>'signed_proposal_cid' AS proposal_cid,
+					pi.info->'peerid' AS peer_id,
+					pi.info->'multiaddrs' AS multiaddrs
+				FROM spd.proposals pr
+				LEFT JOIN spd.providers_info pi USING ( provider_id )
+			WHERE
+				proposal_delivered IS NOT NULL
+					AND
+				proposal_failstamp = 0
+					AND
+				activated_deal_id IS NULL
+					AND
+				proposal_meta->'filmarket_proposal' IS NOT NULL
+					AND
+				start_epoch > spd.epoch_from_ts( NOW() )
+			ORDER BY proposal_delivered
+			`,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		perSP := make(map[filaddr.Address][]dealStatusPending, 4)
+		for _, p := range pending {
+			// the SP info went away since proposing: nothing to be done
+			if p.PeerID == nil || len(p.Multiaddrs) == 0 {
+				continue
+			}
+			perSP[p.ProposalPayload.Provider] = append(perSP[p.ProposalPayload.Provider], p)
+			countProposals++
+		}
+		countProviders = len(perSP)
+
+		// a client whose key can not sign status requests ( e.g. one using a remote signing
+		// service, which only signs deal proposals ) is skipped for the rest of the run
+		var unsignable sync.Map
+
+		eg, ctx := errgroup.WithContext(ctx)
+		for sp := range perSP {
+			sp := sp
+			eg.Go(func() error { return queryDealStatuses(ctx, perSP[sp], signer, &unsignable, tot) })
+		}
+		return eg.Wait()
+	},
+}
+
+func queryDealStatuses(ctx context.Context, props []dealStatusPending, signer app.Signer, unsignable *sync.Map, tot statusTotals) error {
+	ctx, log, db, _ := app.UnpackCtx(ctx)
+	sp := props[0].ProposalPayload.Provider
+
+	var queried, failed int
+	t0 := time.Now()
+	defer func() {
+		log.Infof(
+			"END querying status of %d deals with %s: %d succeeded, %d failed, took %s",
+			len(props), sp,
+			queried, failed,
+			time.Since(t0).String(),
+		)
+	}()
+
+	ctx, cancel := context.WithDeadline(ctx, t0.Add(time.Duration(statusPerSpTimeout)*time.Second))
+	defer cancel()
+
+	recordFailure := func(p dealStatusPending, err error) error {
+		failed++
+		atomic.AddInt32(tot.failed, 1)
+
+		// only note the failure: a previously obtained status remains valid
+		if _, err := db.Exec(
+			context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
+			`
+			UPDATE spd.proposals SET
+				proposal_meta = JSONB_SET(
+					proposal_meta,
+					'{ deal_status_failure }',
+					JSONB_BUILD_OBJECT( 'at', NOW(), 'error', $2::TEXT )
+				)
+			WHERE
+				proposal_uuid = $1
+			`,
+			p.ProposalUUID,
+			err.Error(),
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+		return nil
+	}
+
+	nodeHost, _, err := lp2p.NewPlainNodeTCP(time.Duration(statusQueryTimeout) * time.Second)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	defer func() {
+		if err := nodeHost.Close(); err != nil {
+			log.Warnf("unexpected error shutting down node %s: %s", nodeHost.ID().String(), err)
+		}
+	}()
+
+	peerID := *props[0].PeerID
+	addrs := make([]multiaddr.Multiaddr, len(props[0].Multiaddrs))
+	for i := range props[0].Multiaddrs {
+		addrs[i] = multiaddr.StringCast(props[0].Multiaddrs[i])
+	}
+	if err := nodeHost.Connect(ctx, lp2p.AddrInfo{ID: peerID, Addrs: addrs}); err != nil {
+		log.Warnf("unable to dial %s: %s", sp, err)
+		for _, p := range props {
+			if err := recordFailure(p, xerrors.Errorf("provider not dialable: %w", err)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, p := range props {
+		if ctx.Err() != nil {
+			return nil // timeout is not an error, pick up the rest next time
+		}
+
+		client := p.ProposalPayload.Client
+		if _, skip := unsignable.Load(client); skip {
+			continue
+		}
+
+		// the request is authenticated by a client signature over the uuid bytes
+		uuidBytes, _ := p.ProposalUUID.MarshalBinary()
+		sig, err := signer.Sign(ctx, client, uuidBytes)
+		if err != nil {
+			unsignable.Store(client, struct{}{})
+			log.Warnf("unable to sign status requests as %s, skipping its deals: %s", client, err)
+			continue
+		}
+
+		var resp filtypes.DealStatusV120Response
+		tCtx, tCtxCancel := context.WithTimeout(ctx, time.Duration(statusQueryTimeout)*time.Second)
+		t1 := time.Now()
+		err = lp2p.DoCborRPC(
+			tCtx,
+			nodeHost,
+			peerID,
+			filtypes.DealStatusV120,
+			&filtypes.DealStatusV120Request{
+				DealUUID:  p.ProposalUUID,
+				Signature: *sig,
+			},
+			&resp,
+		)
+		tookMsecs := time.Since(t1).Milliseconds()
+		tCtxCancel()
+
+		switch {
+		case err != nil:
+		case resp.Error != "":
+			err = xerrors.Errorf("provider returned error: %s", resp.Error)
+		case resp.DealStatus == nil:
+			err = xerrors.New("provider returned no deal status")
+		case resp.DealUUID != p.ProposalUUID:
+			err = xerrors.Errorf("provider returned status of deal %s instead", resp.DealUUID)
+		case resp.DealStatus.SignedProposalCid.String() != p.ProposalCid:
+			err = xerrors.Errorf("provider reports signed proposal %s instead of %s", resp.DealStatus.SignedProposalCid, p.ProposalCid)
+		}
+		if err != nil {
+			log.Warnf("status query of %s with %s failed: %s", p.ProposalUUID, sp, err)
+			if err := recordFailure(p, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ds := resp.DealStatus
+		rec := recordedDealStatus{
+			CheckedAt:     time.Now(),
+			Checkpoint:    ds.Status,
+			SealingStatus: ds.SealingStatus,
+			Error:         ds.Error,
+			// boost holds offline deals at the Accepted checkpoint until the data is imported
+			AwaitingData:  resp.IsOffline && ds.Status == "Accepted" && ds.Error == "",
+			BytesReceived: resp.NBytesReceived,
+			ChainDealID:   uint64(ds.ChainDealID),
+		}
+		if ds.PublishCid != nil {
+			pc := ds.PublishCid.String()
+			rec.PublishCid = &pc
+		}
+		j, err := json.Marshal(rec)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if _, err := db.Exec(
+			context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
+			`
+			UPDATE spd.proposals SET
+				proposal_meta = JSONB_SET(
+					JSONB_SET(
+						proposal_meta - 'deal_status_failure',
+						'{ deal_status }',
+						$2::JSONB
+					),
+					'{ status_took_msecs }',
+					TO_JSONB( $3::BIGINT )
+				)
+			WHERE
+				proposal_uuid = $1
+			`,
+			p.ProposalUUID,
+			string(j),
+			tookMsecs,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		queried++
+		atomic.AddInt32(tot.queried, 1)
+	}
+
+	return nil
+}
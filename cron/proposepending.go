@@ -10,6 +10,7 @@ import (
 	"github.com/georgysavva/scany/pgxscan"
 	"github.com/google/uuid"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
 	"github.com/ribasushi/go-toolbox-interplanetary/lp2p"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/go-toolbox/ufcli"
@@ -19,6 +20,7 @@ import (
 	"golang.org/x/xerrors"
 
 	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
 	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
 	filcrypto "github.com/filecoin-project/go-state-types/crypto"
 )
@@ -29,6 +31,7 @@ type proposalPending struct {
 	ProposalPayload   filmarket.DealProposal
 	ProposalSignature filcrypto.Signature
 	ProposalCid       string
+	DeliveryAttempts  int
 	PeerID            *lp2p.PeerID
 	Multiaddrs        []string
 }
@@ -40,12 +43,26 @@ type runTotals struct {
 	delivered120    *int32
 	timedout        *int32
 	failed          *int32
+	retrying        *int32
 }
 
+// how a single delivery attempt ended, recorded in proposal_meta.delivery_attempts
+type deliveryOutcome string
+
+const (
+	deliveryOK        = deliveryOutcome("delivered")
+	deliveryTransport = deliveryOutcome("transport") // dial failure, stream reset, garbled response: retryable
+	deliveryTimeout   = deliveryOutcome("timeout")   // retryable
+	deliveryRejected  = deliveryOutcome("rejected")  // explicit refusal by the SP: terminal
+)
+
 var (
-	spProposalSleep int
-	proposalTimeout int
-	perSpTimeout    int
+	spProposalSleep       int
+	proposalTimeout       int
+	perSpTimeout          int
+	maxDeliveryAttempts   int
+	deliveryRetryBackoff  int
+	deliveryRetryCutoffEp int
 )
 var proposePending = &ufcli.Command{
 	Usage: "Propose pending deals to providers",
@@ -69,6 +86,24 @@ var proposePending = &ufcli.Command{
 			Value:       270, // 4.5 mins
 			Destination: &perSpTimeout,
 		},
+		&ufcli.IntFlag{
+			Name:        "max-delivery-attempts",
+			Usage:       "Amount of transport failures or timeouts after which a proposal is marked failed",
+			Value:       5,
+			Destination: &maxDeliveryAttempts,
+		},
+		&ufcli.IntFlag{
+			Name:        "delivery-retry-backoff",
+			Usage:       "Amount of seconds to wait before retrying a failed delivery, doubling on every subsequent attempt",
+			Value:       300,
+			Destination: &deliveryRetryBackoff,
+		},
+		&ufcli.IntFlag{
+			Name:        "delivery-retry-cutoff-epochs",
+			Usage:       "Do not retry deliveries later than this many epochs before the deal start epoch",
+			Value:       2880, // 1 day, leaving the SP time to seal
+			Destination: &deliveryRetryCutoffEp,
+		},
 	},
 	Action: func(cctx *ufcli.Context) error {
 		ctx, log, db, _ := app.UnpackCtx(cctx.Context)
@@ -77,6 +112,7 @@ var proposePending = &ufcli.Command{
 			delivered120: new(int32),
 			timedout:     new(int32),
 			failed:       new(int32),
+			retrying:     new(int32),
 		}
 		defer func() {
 			log.Infow("summary",
@@ -85,6 +121,7 @@ var proposePending = &ufcli.Command{
 				"successfulV120", atomic.LoadInt32(tot.delivered120),
 				"failed", atomic.LoadInt32(tot.failed),
 				"timedout", atomic.LoadInt32(tot.timedout),
+				"retryScheduled", atomic.LoadInt32(tot.retrying),
 			)
 		}()
 
@@ -99,6 +136,7 @@ var proposePending = &ufcli.Command{
 					pr.proposal_meta->'filmarket_proposal' AS proposal_payload,
 					pr.proposal_meta->'signature' AS proposal_signature,
 					pr.proposal_meta->>'signed_proposal_cid' AS proposal_cid,
+					JSONB_ARRAY_LENGTH( COALESCE( pr.proposal_meta->'delivery_attempts', '[]'::JSONB ) ) AS delivery_attempts,
 					p.proposal_label,
 					pi.info->'peerid' AS peer_id,
 					pi.info->'multiaddrs' AS multiaddrs
@@ -111,6 +149,14 @@ var proposePending = &ufcli.Command{
 				signature_obtained IS NOT NULL
 					AND
 				proposal_failstamp = 0
+					AND
+				pr.proposal_meta->'filmarket_proposal' IS NOT NULL
+					AND
+				(
+					pr.proposal_meta->'delivery_retry_after' IS NULL
+						OR
+					( pr.proposal_meta->>'delivery_retry_after' )::TIMESTAMP WITH TIME ZONE <= NOW()
+				)
 			ORDER BY entry_created
 			`,
 		); err != nil {
@@ -161,15 +207,15 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 
 	dealCount := len(props)
 	jobDesc := fmt.Sprintf("proposing %d deals to %s", dealCount, sp)
-	var delivered, failed, timedout int
+	var delivered, failed, timedout, retrying int
 	log.Info("START " + jobDesc)
 	t0 := time.Now()
 	defer func() {
 		log.Infof(
-			"END %s, out of %d proposals: %d succeeded, %d failed, %d timed out, took %s",
+			"END %s, out of %d proposals: %d succeeded, %d failed, %d timed out, %d scheduled for retry, took %s",
 			jobDesc,
 			dealCount,
-			delivered, failed, timedout,
+			delivered, failed, timedout, retrying,
 			time.Since(t0).String(),
 		)
 	}()
@@ -197,6 +243,7 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 		}
 
 		var proposalLoopErr error
+		outcome := deliveryTransport
 
 		// connect if needed
 		if nodeHost == nil {
@@ -262,11 +309,23 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 			proposingTookMsecs = &pms
 			tCtxCancel()
 			if proposalLoopErr == nil && !resp.Accepted {
+				outcome = deliveryRejected
 				proposalLoopErr = xerrors.New(resp.Message)
 			}
 		}
 
-		// set a few extra common parts
+		if proposalLoopErr == nil {
+			outcome = deliveryOK
+		} else if errors.Is(proposalLoopErr, context.DeadlineExceeded) {
+			outcome = deliveryTimeout
+		}
+		var attemptErr *string
+		if proposalLoopErr != nil {
+			e := proposalLoopErr.Error()
+			attemptErr = &e
+		}
+
+		// set a few extra common parts, and record the attempt itself
 		if _, err := db.Exec(
 			context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
 			`
@@ -275,15 +334,27 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 					JSONB_SET(
 						JSONB_SET(
 							JSONB_SET(
-								proposal_meta,
-								'{ dialing_peerid }',
-								COALESCE( TO_JSONB( $2::TEXT ), 'null'::JSONB )
+								JSONB_SET(
+									proposal_meta,
+									'{ dialing_peerid }',
+									COALESCE( TO_JSONB( $2::TEXT ), 'null'::JSONB )
+								),
+								'{ dial_took_msecs }',
+								COALESCE( TO_JSONB( $3::BIGINT ), 'null'::JSONB )
 							),
-							'{ dial_took_msecs }',
-							COALESCE( TO_JSONB( $3::BIGINT ), 'null'::JSONB )
+							'{ proposal_took_msecs }',
+							COALESCE( TO_JSONB( $4::BIGINT ), 'null'::JSONB )
 						),
-						'{ proposal_took_msecs }',
-						COALESCE( TO_JSONB( $4::BIGINT ), 'null'::JSONB )
+						'{ delivery_attempts }',
+						COALESCE( proposal_meta->'delivery_attempts', '[]'::JSONB ) || JSONB_BUILD_ARRAY(
+							JSONB_BUILD_OBJECT(
+								'at', NOW(),
+								'outcome', $5::TEXT,
+								'error', $6::TEXT,
+								'dial_took_msecs', $3::BIGINT,
+								'proposal_took_msecs', $4::BIGINT
+							)
+						)
 					)
 				)
 			WHERE
@@ -293,6 +364,8 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 			localPeerid,
 			dialTookMsecs,
 			proposingTookMsecs,
+			string(outcome),
+			attemptErr,
 		); err != nil {
 			return cmn.WrErr(err)
 		}
@@ -315,11 +388,48 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 			); err != nil {
 				return cmn.WrErr(err)
 			}
-		} else {
-			log.Error(proposalLoopErr)
+			continue
+		}
 
-			didTimeout := errors.Is(proposalLoopErr, context.DeadlineExceeded)
-			if didTimeout {
+		log.Errorf("%s delivery of %s to %s: %s", outcome, p.ProposalUUID, sp, proposalLoopErr)
+
+		// only an explicit rejection, running out of attempts or running out of time is terminal
+		attempts := p.DeliveryAttempts + 1
+		retryAt := time.Now().Add(time.Duration(deliveryRetryBackoff) * time.Second << (attempts - 1))
+		var failure string
+		switch {
+		case outcome == deliveryRejected:
+			failure = proposalLoopErr.Error()
+		case attempts >= maxDeliveryAttempts:
+			failure = fmt.Sprintf("giving up after %d delivery attempts, last %s error: %s", attempts, outcome, proposalLoopErr)
+		case retryAt.After(fil.MainnetTime(p.ProposalPayload.StartEpoch - filabi.ChainEpoch(deliveryRetryCutoffEp))):
+			failure = fmt.Sprintf("giving up after %d delivery attempts, start epoch %d is too close for another retry, last %s error: %s", attempts, p.ProposalPayload.StartEpoch, outcome, proposalLoopErr)
+		}
+
+		// a retry could still land in time: record when and keep the proposal pending
+		if failure == "" {
+			retrying++
+			atomic.AddInt32(tot.retrying, 1)
+
+			if _, err := db.Exec(
+				context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
+				`
+				UPDATE spd.proposals SET
+					proposal_meta = JSONB_SET(
+						proposal_meta,
+						'{ delivery_retry_after }',
+						TO_JSONB( $2::TIMESTAMP WITH TIME ZONE )
+					)
+				WHERE
+					proposal_uuid = $1
+				`,
+				p.ProposalUUID,
+				retryAt,
+			); err != nil {
+				return cmn.WrErr(err)
+			}
+		} else {
+			if outcome == deliveryTimeout {
 				timedout++
 				atomic.AddInt32(tot.timedout, 1)
 			} else {
@@ -343,15 +453,15 @@ func proposeToSp(ctx context.Context, props []proposalPending, tot runTotals) er
 					proposal_uuid = $1
 				`,
 				p.ProposalUUID,
-				proposalLoopErr.Error(),
+				failure,
 			); err != nil {
 				return cmn.WrErr(err)
 			}
+		}
 
-			// in case of a timeout or connection failure: bail after failing just one proposal, retry next time
-			if nodeHost == nil || didTimeout {
-				return nil
-			}
+		// in case of a timeout or connection failure: bail after just one proposal, retry the rest next time
+		if outcome != deliveryRejected {
+			return nil
 		}
 	}
 
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	filaddr "github.com/filecoin-project/go-address"
 	cborutil "github.com/filecoin-project/go-cbor-util"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
 	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/ipfs/go-cid"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/go-toolbox/ufcli"
 	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/signsvc"
+	"golang.org/x/xerrors"
 )
 
 type signTotals struct {
@@ -18,12 +30,29 @@ type signTotals struct {
 	failed  *int32
 }
 
+// built on first use and retained: in daemon mode the keystore is not decrypted anew on every run
+var (
+	proposalSigner     app.Signer
+	proposalSignerErr  error
+	proposalSignerOnce sync.Once
+)
+
+func clientSigner(cctx *ufcli.Context) (app.Signer, error) {
+	proposalSignerOnce.Do(func() { proposalSigner, proposalSignerErr = app.NewSigner(cctx) })
+	return proposalSigner, proposalSignerErr
+}
+
 var signPending = &ufcli.Command{
 	Usage: "Sign pending deal proposals",
 	Name:  "sign-pending",
 	Flags: []ufcli.Flag{},
 	Action: func(cctx *ufcli.Context) error {
-		ctx, log, db, gctx := app.UnpackCtx(cctx.Context)
+		ctx, log, db, _ := app.UnpackCtx(cctx.Context)
+
+		signer, err := clientSigner(cctx)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
 
 		totals := signTotals{
 			signed:  new(int32),
@@ -58,11 +87,14 @@ var signPending = &ufcli.Command{
 				signature_obtained IS NULL
 					AND
 				proposal_failstamp = 0
+					AND
+				pr.proposal_meta->'filmarket_proposal' IS NOT NULL
 			`,
 		); err != nil {
 			return cmn.WrErr(err)
 		}
 
+		var signErr error
 		for _, p := range pending {
 			wallets[p.ProposalPayload.Client] = struct{}{}
 
@@ -71,9 +103,35 @@ var signPending = &ufcli.Command{
 				return cmn.WrErr(err)
 			}
 
-			sig, err := gctx.LotusAPI[app.FilHeavy].WalletSign(ctx, p.ProposalPayload.Client, raw)
+			sig, err := signer.Sign(ctx, p.ProposalPayload.Client, raw)
 			if err != nil {
-				return cmn.WrErr(err)
+				atomic.AddInt32(totals.failed, 1)
+
+				// a tenant signing service refusing on policy grounds: the proposal will never be signed
+				if errors.Is(err, signsvc.ErrRefused) {
+					log.Warnf("signing of proposal %s refused: %s", p.ProposalUUID, err)
+					if _, err := db.Exec(
+						ctx,
+						`
+						UPDATE spd.proposals SET
+							proposal_failstamp = spd.big_now(),
+							proposal_meta = JSONB_SET( proposal_meta, '{ failure }', TO_JSONB( $2::TEXT ) )
+						WHERE proposal_uuid = $1
+						`,
+						p.ProposalUUID,
+						err.Error(),
+					); err != nil {
+						return cmn.WrErr(err)
+					}
+					continue
+				}
+
+				// anything else is retried on the next run, without holding up the remaining clients
+				log.Errorf("signing of proposal %s failed: %s", p.ProposalUUID, err)
+				if signErr == nil {
+					signErr = err
+				}
+				continue
 			}
 
 			propNode, err := cborutil.AsIpld(&filmarket.ClientDealProposal{
@@ -110,6 +168,313 @@ var signPending = &ufcli.Command{
 			atomic.AddInt32(totals.signed, 1)
 		}
 
+		if err := signPendingAllocations(cctx, signer, totals, wallets); err != nil && signErr == nil {
+			signErr = err
+		}
+
+		if signErr != nil {
+			return xerrors.Errorf("%d proposals could not be signed, first error: %w", atomic.LoadInt32(totals.failed), signErr)
+		}
 		return nil
 	},
 }
+
+// at most this many allocations are requested within a single datacap transfer
+const maxAllocationsPerMessage = 128
+
+// signPendingAllocations submits the datacap transfers funding pending allocation
+// requests, one message per client and batch. The signature is obtained over
+// the message itself, thus once the signed message is recorded, right before it
+// is pushed to the mpool, the requests it contains are considered signed
+func signPendingAllocations(cctx *ufcli.Context, signer app.Signer, totals signTotals, wallets map[filaddr.Address]struct{}) error {
+	ctx, log, db, gctx := app.UnpackCtx(cctx.Context)
+
+	// the requests within a message recorded by a previous run must not be signed again
+	// hence the query below skips them, as they already carry a signature_obtained
+	if err := resumeAllocationSubmissions(ctx); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	type allocationPending struct {
+		ProposalUUID      string
+		ClientID          fil.ActorID
+		AllocationRequest filverifreg.AllocationRequest
+	}
+
+	pending := make([]allocationPending, 0, 128)
+	if err := pgxscan.Select(
+		ctx,
+		db,
+		&pending,
+		`
+		SELECT
+				pr.proposal_uuid,
+				pr.client_id,
+				pr.proposal_meta->'allocation_request' AS allocation_request
+			FROM spd.proposals pr
+		WHERE
+			signature_obtained IS NULL
+				AND
+			proposal_failstamp = 0
+				AND
+			pr.proposal_meta->'allocation_request' IS NOT NULL
+		ORDER BY pr.client_id, pr.entry_created
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	lapi := gctx.LotusAPI[app.FilHeavy]
+	if lapi == nil {
+		return xerrors.New("submitting allocations requires lotus-api-heavy to be set")
+	}
+
+	var firstErr error
+	for len(pending) > 0 {
+		batch := pending[:1]
+		for len(batch) < len(pending) && len(batch) < maxAllocationsPerMessage && pending[len(batch)].ClientID == batch[0].ClientID {
+			batch = pending[:len(batch)+1]
+		}
+		pending = pending[len(batch):]
+
+		client := batch[0].ClientID.AsFilAddr()
+		wallets[client] = struct{}{}
+
+		uuids := make([]string, len(batch))
+		reqs := make([]filverifreg.AllocationRequest, len(batch))
+		for i := range batch {
+			uuids[i] = batch[i].ProposalUUID
+			reqs[i] = batch[i].AllocationRequest
+		}
+
+		// a remote signing service only signs deal proposals: retrying would never get anywhere
+		isRemote, err := app.SignsRemotely(ctx, signer, client)
+		if err != nil {
+			atomic.AddInt32(totals.failed, int32(len(batch)))
+			log.Errorf("unable to determine signer of %s: %s", client, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if isRemote {
+			atomic.AddInt32(totals.failed, int32(len(batch)))
+			log.Errorf(
+				"client %s signs via a remote signing service, which can not fund allocations: either remove it from signer-remote-endpoints, or switch its tenant away from '%s' onboarding",
+				client,
+				app.OnboardingDDO,
+			)
+			if _, err := db.Exec(
+				ctx,
+				`
+				UPDATE spd.proposals SET
+					proposal_failstamp = spd.big_now(),
+					proposal_meta = JSONB_SET( proposal_meta, '{ failure }', TO_JSONB( 'client signs via a remote signing service, which does not support direct data onboarding'::TEXT ) )
+				WHERE proposal_uuid = ANY( $1::UUID[] )
+				`,
+				uuids,
+			); err != nil {
+				return cmn.WrErr(err)
+			}
+			continue
+		}
+
+		msg, err := app.AllocationMessage(client, reqs)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		sm, err := app.SignMessage(ctx, lapi, signer, msg)
+		if err != nil {
+			atomic.AddInt32(totals.failed, int32(len(batch)))
+
+			if errors.Is(err, signsvc.ErrRefused) {
+				log.Warnf("signing of allocation message by %s refused: %s", client, err)
+				if _, err := db.Exec(
+					ctx,
+					`
+					UPDATE spd.proposals SET
+						proposal_failstamp = spd.big_now(),
+						proposal_meta = JSONB_SET( proposal_meta, '{ failure }', TO_JSONB( $2::TEXT ) )
+					WHERE proposal_uuid = ANY( $1::UUID[] )
+					`,
+					uuids,
+					err.Error(),
+				); err != nil {
+					return cmn.WrErr(err)
+				}
+				continue
+			}
+
+			log.Errorf("signing of %d allocations by %s failed: %s", len(batch), client, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		smBytes, err := sm.Serialize()
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		// the message is recorded before it is pushed: a push may well reach the mpool despite
+		// returning an error, and the requests must never be signed anew once that is possible
+		// allocation_msg_signed is cleared once the push is confirmed, see resumeAllocationSubmissions
+		// the position within the message is how the resulting allocation ids are matched up later
+		if _, err := db.Exec(
+			ctx,
+			`
+			UPDATE spd.proposals pr SET
+				signature_obtained = NOW(),
+				proposal_meta = pr.proposal_meta || JSONB_BUILD_OBJECT(
+					'allocation_msg_cid', $2::TEXT,
+					'allocation_msg_nonce', $3::BIGINT,
+					'allocation_msg_signed', $4::TEXT,
+					'allocation_msg_index', m.idx - 1
+				)
+			FROM UNNEST( $1::UUID[] ) WITH ORDINALITY AS m( proposal_uuid, idx )
+			WHERE pr.proposal_uuid = m.proposal_uuid
+			`,
+			uuids,
+			sm.Cid().String(),
+			sm.Message.Nonce,
+			hex.EncodeToString(smBytes),
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if err := pushRecordedMessage(ctx, lapi, sm); err != nil {
+			atomic.AddInt32(totals.failed, int32(len(batch)))
+			log.Errorf("submission of %d allocations by %s within message %s unconfirmed, will be retried: %s", len(batch), client, sm.Cid(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		log.Infof("submitted %d allocations by %s within message %s", len(batch), client, sm.Cid())
+		atomic.AddInt32(totals.signed, int32(len(batch)))
+	}
+
+	return firstErr
+}
+
+// pushRecordedMessage submits a message already recorded by signPendingAllocations, and
+// marks its submission as confirmed
+func pushRecordedMessage(ctx context.Context, capi app.ChainAPI, sm *lotustypes.SignedMessage) error {
+	if _, err := capi.MpoolPush(ctx, sm); err != nil {
+		return xerrors.Errorf("mpool push failed: %w", err)
+	}
+	return confirmAllocationMessage(ctx, sm.Cid())
+}
+
+// confirmAllocationMessage drops the recorded copy of a message known to have reached the mpool
+func confirmAllocationMessage(ctx context.Context, msgCid cid.Cid) error {
+	_, err := app.GetGlobalCtx(ctx).Db[app.DbMain].Exec(
+		context.Background(), // deliberate: the message is out, we must record it no matter what
+		`
+		UPDATE spd.proposals SET
+			proposal_meta = proposal_meta - 'allocation_msg_signed'
+		WHERE
+			proposal_meta->>'allocation_msg_cid' = $1
+		`,
+		msgCid.String(),
+	)
+	return cmn.WrErr(err)
+}
+
+// resumeAllocationSubmissions settles the allocation messages recorded by signPendingAllocations
+// whose submission was never confirmed, e.g. due to an RPC timeout during MpoolPush or a failed
+// DB write. Such a message is never signed anew: unless it is already on chain, or its nonce is
+// already taken, the very same signed message is pushed again. Thus the datacap of the contained
+// requests can not be transferred twice
+func resumeAllocationSubmissions(ctx context.Context) error {
+	ctx, log, db, gctx := app.UnpackCtx(ctx)
+
+	type unconfirmedMessage struct {
+		MsgCid            string
+		MsgSigned         string
+		SignatureObtained time.Time
+	}
+
+	unconfirmed := make([]unconfirmedMessage, 0)
+	if err := pgxscan.Select(
+		ctx,
+		db,
+		&unconfirmed,
+		`
+		SELECT DISTINCT ON ( pr.proposal_meta->>'allocation_msg_cid' )
+				pr.proposal_meta->>'allocation_msg_cid' AS msg_cid,
+				pr.proposal_meta->>'allocation_msg_signed' AS msg_signed,
+				pr.signature_obtained
+			FROM spd.proposals pr
+		WHERE
+			proposal_failstamp = 0
+				AND
+			allocation_id IS NULL
+				AND
+			proposal_meta ? 'allocation_msg_signed'
+		ORDER BY pr.proposal_meta->>'allocation_msg_cid'
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+	if len(unconfirmed) == 0 {
+		return nil
+	}
+
+	capi := gctx.LotusAPI[app.FilHeavy]
+	if capi == nil {
+		return xerrors.New("submitting allocations requires lotus-api-heavy to be set")
+	}
+
+	for _, u := range unconfirmed {
+		b, err := hex.DecodeString(u.MsgSigned)
+		if err != nil {
+			return xerrors.Errorf("recorded allocation message %s is not valid hex: %w", u.MsgCid, err)
+		}
+		sm, err := lotustypes.DecodeSignedMessage(b)
+		if err != nil {
+			return xerrors.Errorf("recorded allocation message %s is undecodable: %w", u.MsgCid, err)
+		}
+		if sm.Cid().String() != u.MsgCid {
+			return xerrors.Errorf("recorded allocation message %s decodes to a message with cid %s", u.MsgCid, sm.Cid())
+		}
+
+		// only look as far back as the message could possibly have landed
+		lookback := fil.WallTimeEpoch(time.Now()) - fil.WallTimeEpoch(u.SignatureObtained) + filbuiltin.EpochsInHour
+		ml, err := capi.StateSearchMsg(ctx, lotustypes.EmptyTSK, sm.Cid(), lookback, true)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		if ml != nil {
+			log.Infof("unconfirmed allocation message %s found on chain", sm.Cid())
+			if err := confirmAllocationMessage(ctx, sm.Cid()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the nonce accounts for the mpool as well: if it moved past ours, the message is either
+		// pending already, or was superseded and can never land. Either way there is nothing to push,
+		// the missed DealStartEpoch sweep eventually fails the requests of a message that never lands
+		nextNonce, err := capi.MpoolGetNonce(ctx, sm.Message.From)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		if nextNonce > sm.Message.Nonce {
+			log.Infof("nonce %d of unconfirmed allocation message %s already taken, awaiting the message on chain", sm.Message.Nonce, sm.Cid())
+			continue
+		}
+
+		if err := pushRecordedMessage(ctx, capi, sm); err != nil {
+			return xerrors.Errorf("resubmission of allocation message %s failed: %w", sm.Cid(), err)
+		}
+		log.Infof("resubmitted unconfirmed allocation message %s", sm.Cid())
+	}
+
+	return nil
+}
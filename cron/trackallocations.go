@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/bits"
+	"time"
+
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	fildatacap "github.com/filecoin-project/go-state-types/builtin/v9/datacap"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgx/v4"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
+)
+
+type allocationTotals struct {
+	allocated  int
+	msgFailed  int
+	claimed    int
+	terminated int
+}
+
+var trackAllocations = &ufcli.Command{
+	Usage: "Track verified-registry allocations and claims of direct data onboarding proposals",
+	Name:  "track-allocations",
+	Flags: []ufcli.Flag{},
+	Action: func(cctx *ufcli.Context) error {
+		ctx, log, db, _ := app.UnpackCtx(cctx.Context)
+
+		curTipset, err := app.DefaultLookbackTipset(ctx)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		var tot allocationTotals
+		defer func() {
+			log.Infow("summary",
+				"allocated", tot.allocated,
+				"allocationMessagesFailed", tot.msgFailed,
+				"claimed", tot.claimed,
+				"claimsTerminated", tot.terminated,
+			)
+		}()
+
+		if err := trackAllocationMessages(ctx, curTipset, &tot); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		return db.BeginFunc(ctx, func(tx pgx.Tx) error {
+			if err := trackClaims(ctx, tx, curTipset, &tot); err != nil {
+				return cmn.WrErr(err)
+			}
+
+			if tot.claimed == 0 && tot.terminated == 0 {
+				return nil
+			}
+			return refreshMatviews(ctx, tx)
+		})
+	},
+}
+
+// trackAllocationMessages looks up the execution results of the datacap transfers
+// submitted by sign-pending, and records the ids of the resulting allocations
+func trackAllocationMessages(ctx context.Context, curTipset *lotustypes.TipSet, tot *allocationTotals) error {
+	ctx, log, db, gctx := app.UnpackCtx(ctx)
+
+	type allocationSubmitted struct {
+		ProposalUUID      string
+		MsgCid            string
+		MsgIndex          uint64
+		SignatureObtained time.Time
+	}
+
+	submitted := make([]allocationSubmitted, 0, 128)
+	if err := pgxscan.Select(
+		ctx,
+		db,
+		&submitted,
+		`
+		SELECT
+				pr.proposal_uuid,
+				pr.proposal_meta->>'allocation_msg_cid' AS msg_cid,
+				( pr.proposal_meta->'allocation_msg_index' )::BIGINT AS msg_index,
+				pr.signature_obtained
+			FROM spd.proposals pr
+		WHERE
+			proposal_failstamp = 0
+				AND
+			allocation_id IS NULL
+				AND
+			proposal_meta->'allocation_msg_cid' IS NOT NULL
+		ORDER BY signature_obtained
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	perMsg := make(map[string][]allocationSubmitted, 8)
+	for _, s := range submitted {
+		perMsg[s.MsgCid] = append(perMsg[s.MsgCid], s)
+	}
+
+	for msgCidStr, props := range perMsg {
+		msgCid, err := cid.Parse(msgCidStr)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		// only look as far back as the message could possibly have landed
+		lookback := curTipset.Height() - fil.WallTimeEpoch(props[0].SignatureObtained) + filbuiltin.EpochsInHour
+		ml, err := gctx.LotusAPI[app.FilLite].StateSearchMsg(ctx, curTipset.Key(), msgCid, lookback, true)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		if ml == nil {
+			log.Infof("allocation message %s not yet on chain", msgCid)
+			continue
+		}
+
+		failures := make(map[uint64]string, len(props))
+		var newAllocations []filverifreg.AllocationId
+
+		if !ml.Receipt.ExitCode.IsSuccess() {
+			for _, p := range props {
+				failures[p.MsgIndex] = "allocation message failed with exit code " + ml.Receipt.ExitCode.String()
+			}
+			tot.msgFailed++
+		} else {
+			var tr fildatacap.TransferReturn
+			if err := tr.UnmarshalCBOR(bytes.NewReader(ml.Receipt.Return)); err != nil {
+				return xerrors.Errorf("unable to decode return of allocation message %s: %w", msgCid, err)
+			}
+			var ar filverifreg.AllocationsResponse
+			if err := ar.UnmarshalCBOR(bytes.NewReader(tr.RecipientData)); err != nil {
+				return xerrors.Errorf("unable to decode allocations made by message %s: %w", msgCid, err)
+			}
+			for _, fc := range ar.AllocationResults.FailCodes {
+				failures[fc.Idx] = "allocation request rejected with exit code " + fc.Code.String()
+			}
+			newAllocations = ar.NewAllocations
+		}
+
+		// the new allocation ids are listed in request order, skipping the rejected ones
+		allocIDs := make(map[uint64]filverifreg.AllocationId, len(newAllocations))
+		for idx := uint64(0); len(allocIDs) < len(newAllocations); idx++ {
+			if _, failed := failures[idx]; !failed {
+				allocIDs[idx] = newAllocations[len(allocIDs)]
+			}
+		}
+
+		for _, p := range props {
+			if reason, failed := failures[p.MsgIndex]; failed {
+				if _, err := db.Exec(
+					context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
+					`
+					UPDATE spd.proposals SET
+						proposal_failstamp = spd.big_now(),
+						proposal_meta = JSONB_SET( proposal_meta, '{ failure }', TO_JSONB( $2::TEXT ) )
+					WHERE proposal_uuid = $1
+					`,
+					p.ProposalUUID,
+					reason,
+				); err != nil {
+					return cmn.WrErr(err)
+				}
+				continue
+			}
+
+			allocID, found := allocIDs[p.MsgIndex]
+			if !found {
+				return xerrors.Errorf("message %s did not result in an allocation at position %d of proposal %s", msgCid, p.MsgIndex, p.ProposalUUID)
+			}
+
+			// an allocation on chain is the equivalent of a delivered proposal
+			if _, err := db.Exec(
+				context.Background(), // deliberate: even if outer context is cancelled we still need to write to DB
+				`
+				UPDATE spd.proposals SET
+					allocation_id = $2,
+					proposal_delivered = NOW(),
+					proposal_meta = JSONB_SET( proposal_meta, '{ allocation_epoch }', TO_JSONB( $3::INTEGER ) )
+				WHERE proposal_uuid = $1
+				`,
+				p.ProposalUUID,
+				uint64(allocID),
+				ml.Height,
+			); err != nil {
+				return cmn.WrErr(err)
+			}
+			tot.allocated++
+		}
+	}
+
+	return nil
+}
+
+// trackClaims records the claims made against our allocations, and notes the ones
+// that have since disappeared from the verified registry
+func trackClaims(ctx context.Context, tx pgx.Tx, curTipset *lotustypes.TipSet, tot *allocationTotals) error {
+	ctx, log, _, gctx := app.UnpackCtx(ctx)
+
+	type trackedAllocation struct {
+		ProviderID   fil.ActorID
+		AllocationID *int64
+		ClaimID      *int64
+		ProposalUUID *string
+	}
+
+	tracked := make([]trackedAllocation, 0, 1024)
+	if err := pgxscan.Select(
+		ctx,
+		tx,
+		&tracked,
+		`
+		SELECT
+				provider_id,
+				allocation_id,
+				NULL::BIGINT AS claim_id,
+				proposal_uuid::TEXT
+			FROM spd.proposals
+		WHERE
+			proposal_failstamp = 0
+				AND
+			allocation_id IS NOT NULL
+				AND
+			activated_claim_id IS NULL
+	UNION ALL
+		SELECT
+				provider_id,
+				NULL::BIGINT AS allocation_id,
+				claim_id,
+				NULL::TEXT AS proposal_uuid
+			FROM spd.verified_claims
+		WHERE
+			status = 'active'
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	claimsOf := make(map[fil.ActorID]map[filverifreg.ClaimId]filverifreg.Claim, 64)
+	for _, t := range tracked {
+		if _, seen := claimsOf[t.ProviderID]; seen {
+			continue
+		}
+		claims, err := gctx.LotusAPI[app.FilLite].StateGetClaims(ctx, t.ProviderID.AsFilAddr(), curTipset.Key())
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		claimsOf[t.ProviderID] = claims
+	}
+
+	var gone []int64
+	for _, t := range tracked {
+
+		if t.ClaimID != nil {
+			if _, live := claimsOf[t.ProviderID][filverifreg.ClaimId(*t.ClaimID)]; !live {
+				gone = append(gone, *t.ClaimID)
+			}
+			continue
+		}
+
+		// claims take over the id of the allocation they are made against
+		claimID := filverifreg.ClaimId(*t.AllocationID)
+		c, claimed := claimsOf[t.ProviderID][claimID]
+		if !claimed {
+			continue
+		}
+		if _, err := tx.Exec(
+			ctx,
+			`
+			INSERT INTO spd.verified_claims
+				( claim_id, client_id, provider_id, piece_cid, claimed_log2_size, sector_number, term_start, term_min, term_max, status )
+				VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, 'active' )
+			ON CONFLICT ( claim_id ) DO UPDATE SET
+				status = EXCLUDED.status,
+				term_max = EXCLUDED.term_max
+			`,
+			uint64(claimID),
+			fil.ActorID(c.Client),
+			t.ProviderID,
+			c.Data.String(),
+			bits.TrailingZeros64(uint64(c.Size)),
+			uint64(c.Sector),
+			c.TermStart,
+			c.TermMin,
+			c.TermMax,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			`
+			UPDATE spd.proposals
+				SET activated_claim_id = $1
+			WHERE
+				proposal_uuid = $2
+			`,
+			uint64(claimID),
+			*t.ProposalUUID,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+		tot.claimed++
+	}
+
+	if len(gone) == 0 {
+		return nil
+	}
+
+	log.Infof("%d claims no longer present in the verified registry", len(gone))
+	tot.terminated = len(gone)
+
+	if _, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.verified_claims SET
+			status = 'terminated',
+			verified_claim_meta = verified_claim_meta || JSONB_BUILD_OBJECT( 'terminated_at_epoch', $2::INTEGER )
+		WHERE
+			claim_id = ANY ( $1::BIGINT[] )
+		`,
+		gone,
+		curTipset.Height(),
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	// same as with market deals: a replica that went away is a failed proposal
+	if _, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.proposals SET
+			activated_claim_id = NULL,
+			proposal_failstamp = spd.big_now(),
+			proposal_meta = JSONB_SET(
+				proposal_meta,
+				'{ failure }',
+				TO_JSONB( 'claim no longer part of verified-registry state'::TEXT )
+			)
+		WHERE
+			activated_claim_id = ANY ( $1::BIGINT[] )
+		`,
+		gone,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return nil
+}
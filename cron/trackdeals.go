@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"math/bits"
+	"os"
 	"strconv"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	filbig "github.com/filecoin-project/go-state-types/big"
 	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
 	lotusapi "github.com/filecoin-project/lotus/api"
+	lotusmarket "github.com/filecoin-project/lotus/chain/actors/builtin/market"
 	lotustypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/georgysavva/scany/pgxscan"
 	"github.com/ipfs/go-cid"
@@ -23,10 +26,42 @@ import (
 	"golang.org/x/xerrors"
 )
 
+// marketStateMark is what spd.global.metadata->market_state records about the last processed market state
+type marketStateMark struct {
+	Epoch  filabi.ChainEpoch    `json:"epoch"`
+	Tipset lotustypes.TipSetKey `json:"tipset"`
+
+	// allow the next run to diff against exactly this state, not present prior to incremental tracking
+	ActorCode *cid.Cid `json:"actor_code,omitempty"`
+	ActorHead *cid.Cid `json:"actor_head,omitempty"`
+}
+
+var (
+	trackDealsFullResync    bool
+	trackDealsSnapshot      string
+	trackDealsSnapshotEpoch int
+)
+
 var trackDeals = &ufcli.Command{
 	Usage: "Track state of fil deals related to known PieceCIDs",
 	Name:  "track-deals",
-	Flags: []ufcli.Flag{},
+	Flags: []ufcli.Flag{
+		&ufcli.BoolFlag{
+			Name:        "full-resync",
+			Usage:       "Reconcile against the entire StateMarketDeals list, instead of only the changes since the previously processed market state",
+			Destination: &trackDealsFullResync,
+		},
+		&ufcli.StringFlag{
+			Name:        "market-deals-snapshot",
+			Usage:       "Instead of the chain node, read the entire list of deals from this StateMarketDeals JSON dump, optionally zstd-compressed. Implies --full-resync, requires --snapshot-epoch. Deals missing from the dump are left for the next regular run to terminate",
+			Destination: &trackDealsSnapshot,
+		},
+		&ufcli.IntFlag{
+			Name:        "snapshot-epoch",
+			Usage:       "Epoch at which the market-deals-snapshot was taken, deal states are evaluated as of this epoch. Can not predate the previously processed market state",
+			Destination: &trackDealsSnapshotEpoch,
+		},
+	},
 	Action: func(cctx *ufcli.Context) error {
 		ctx, log, db, gctx := app.UnpackCtx(cctx.Context)
 
@@ -35,18 +70,98 @@ var trackDeals = &ufcli.Command{
 			return cmn.WrErr(err)
 		}
 
-		var stateDeals map[string]*lotusapi.MarketDeal
-		dealQueryDone := make(chan error, 1)
-		go func() {
-			defer close(dealQueryDone)
-			log.Infow("retrieving Market Deals from", "state", curTipset.Key(), "epoch", curTipset.Height(), "wallTime", time.Unix(int64(curTipset.Blocks()[0].Timestamp), 0))
-			stateDeals, err = gctx.LotusAPI[app.FilHeavy].StateMarketDeals(ctx, curTipset.Key())
+		// the epoch as of which all deal states are evaluated
+		stateEpoch := curTipset.Height()
+		stateTipsetKey := curTipset.Key()
+
+		var marketActor *lotustypes.Actor
+		if trackDealsSnapshot != "" {
+			// judging the dump as of the current epoch would consider everything that happened since as missed
+			if trackDealsSnapshotEpoch <= 0 {
+				return xerrors.New("the epoch at which the market-deals-snapshot was taken must be supplied via --snapshot-epoch")
+			}
+			if filabi.ChainEpoch(trackDealsSnapshotEpoch) > stateEpoch {
+				return xerrors.Errorf("snapshot epoch %d is in the future, current epoch is %d", trackDealsSnapshotEpoch, stateEpoch)
+			}
+			stateEpoch = filabi.ChainEpoch(trackDealsSnapshotEpoch)
+			stateTipsetKey = lotustypes.EmptyTSK // not derivable from a plain dump
+		} else {
+			marketActor, err = gctx.LotusAPI[app.FilHeavy].StateGetActor(ctx, filbuiltin.StorageMarketActorAddr, curTipset.Key())
 			if err != nil {
-				dealQueryDone <- cmn.WrErr(err)
-				return
+				return cmn.WrErr(err)
 			}
-			log.Infof("retrieved %s state deal records", humanize.Comma(int64(len(stateDeals))))
-		}()
+		}
+
+		var prevMarkJSON []byte
+		if err := db.QueryRow(
+			ctx,
+			`SELECT metadata->'market_state' FROM spd.global`,
+		).Scan(&prevMarkJSON); err != nil {
+			return cmn.WrErr(err)
+		}
+		var prevMark marketStateMark
+		if len(prevMarkJSON) > 0 {
+			if err := json.Unmarshal(prevMarkJSON, &prevMark); err != nil {
+				return cmn.WrErr(err)
+			}
+		}
+
+		if trackDealsSnapshot != "" && stateEpoch < prevMark.Epoch {
+			return xerrors.Errorf("snapshot epoch %d predates the already processed market state at epoch %d", stateEpoch, prevMark.Epoch)
+		}
+
+		fullResync := trackDealsFullResync || trackDealsSnapshot != "" || prevMark.ActorHead == nil || prevMark.ActorCode == nil
+		if !fullResync && prevMark.Epoch > curTipset.Height() {
+			log.Warnf("previously processed market state at epoch %d is ahead of current %d, performing full resync", prevMark.Epoch, curTipset.Height())
+			fullResync = true
+		}
+
+		// deals that may have changed since the previous run, keyed by deal id
+		var stateDeals map[int64]*lotusapi.MarketDeal
+		// deals no longer part of the market state, only known in incremental mode
+		var removedDeals []int64
+
+		if !fullResync {
+			log.Infow("diffing Market Deals against", "prevState", prevMark.Tipset, "prevEpoch", prevMark.Epoch, "state", curTipset.Key(), "epoch", curTipset.Height())
+			stateDeals, removedDeals, err = marketStateChanges(
+				ctx,
+				gctx.LotusAPI[app.FilHeavy],
+				&lotustypes.Actor{Code: *prevMark.ActorCode, Head: *prevMark.ActorHead},
+				marketActor,
+			)
+			if err != nil {
+				// most likely the node no longer has the old state: fall back to what always works
+				log.Warnf("incremental market state processing not possible, performing full resync: %s", err)
+				fullResync = true
+			} else {
+				log.Infof("found %s changed and %s removed deal records", humanize.Comma(int64(len(stateDeals))), humanize.Comma(int64(len(removedDeals))))
+			}
+		}
+
+		dealQueryDone := make(chan error, 1)
+		if !fullResync || trackDealsSnapshot != "" {
+			close(dealQueryDone) // a snapshot is streamed below instead
+		} else {
+			go func() {
+				defer close(dealQueryDone)
+				log.Infow("retrieving Market Deals from", "state", curTipset.Key(), "epoch", curTipset.Height(), "wallTime", time.Unix(int64(curTipset.Blocks()[0].Timestamp), 0))
+				sd, err := gctx.LotusAPI[app.FilHeavy].StateMarketDeals(ctx, curTipset.Key())
+				if err != nil {
+					dealQueryDone <- cmn.WrErr(err)
+					return
+				}
+				stateDeals = make(map[int64]*lotusapi.MarketDeal, len(sd))
+				for dealIDString, d := range sd {
+					dID, err := strconv.ParseInt(dealIDString, 10, 64)
+					if err != nil {
+						dealQueryDone <- cmn.WrErr(err)
+						return
+					}
+					stateDeals[dID] = d
+				}
+				log.Infof("retrieved %s state deal records", humanize.Comma(int64(len(stateDeals))))
+			}()
+		}
 
 		tenantClients := make([]fil.ActorID, 0, 32)
 		if err := pgxscan.Select(
@@ -58,13 +173,13 @@ var trackDeals = &ufcli.Command{
 			return cmn.WrErr(err)
 		}
 
-		tenantClientDatacap := make(map[filaddr.Address]*filbig.Int, len(tenantClients))
+		tenantClientDatacap := make(map[fil.ActorID]*filbig.Int, len(tenantClients))
 		for _, c := range tenantClients {
 			dcap, err := gctx.LotusAPI[app.FilLite].StateVerifiedClientStatus(ctx, c.AsFilAddr(), curTipset.Key())
 			if err != nil {
 				return cmn.WrErr(err)
 			}
-			tenantClientDatacap[c.AsFilAddr()] = dcap
+			tenantClientDatacap[c] = dcap
 		}
 
 		log.Infof("queried datacap for %d clients", len(tenantClientDatacap))
@@ -75,15 +190,29 @@ var trackDeals = &ufcli.Command{
 			status   string
 		}
 
-		// entries from this list are deleted below as we process the new state
+		// on full resync entries from this list are deleted below as we process the new state
+		// otherwise it only holds the subset of deals that changed
 		initialDbDeals := make(map[int64]filDeal)
 
+		var changedDealIDs []int64 // NULL: all of them
+		if !fullResync {
+			changedDealIDs = make([]int64, 0, len(stateDeals))
+			for dID := range stateDeals {
+				changedDealIDs = append(changedDealIDs, dID)
+			}
+		}
+
 		rows, err := db.Query(
 			ctx,
 			`
 			SELECT d.deal_id, d.piece_id, d.piece_cid, d.status
 				FROM spd.published_deals d
+			WHERE
+				$1::BIGINT[] IS NULL
+					OR
+				d.deal_id = ANY ( $1::BIGINT[] )
 			`,
+			changedDealIDs,
 		)
 		if err != nil {
 			return cmn.WrErr(err)
@@ -116,6 +245,7 @@ var trackDeals = &ufcli.Command{
 
 		defer func() {
 			log.Infow("summary",
+				"fullResync", fullResync,
 				"totalDeals", dealCountsByState,
 				"uniquePieces", len(seenPieces),
 				"uniqueProviders", len(seenProviders),
@@ -146,21 +276,17 @@ var trackDeals = &ufcli.Command{
 
 		toUpsert := make([]*deal, 0, 8<<10)
 
-		for dealIDString, protoDeal := range stateDeals {
+		classifyDeal := func(dealID int64, protoDeal *lotusapi.MarketDeal) {
 
 			d := deal{
 				MarketDeal: protoDeal,
+				dealID:     dealID,
 				status:     "published", // always begin as "published" adjust accordingly below
 			}
 
-			d.dealID, err = strconv.ParseInt(dealIDString, 10, 64)
-			if err != nil {
-				return cmn.WrErr(err)
-			}
-
 			if kd, known := initialDbDeals[d.dealID]; known {
 				d.prevState = &kd
-				delete(initialDbDeals, d.dealID) // at the end whatever remains is not in SMA list, thus will be marked "terminated"
+				delete(initialDbDeals, d.dealID) // on full resync whatever remains at the end is not in SMA list, thus will be marked "terminated"
 			}
 
 			seenPieces[d.Proposal.PieceCID] = struct{}{}
@@ -173,21 +299,12 @@ var trackDeals = &ufcli.Command{
 			} else if d.State.SectorStartEpoch > 0 {
 				d.sectorStart = &d.State.SectorStartEpoch
 				d.status = "active"
-			} else if d.Proposal.StartEpoch+filbuiltin.EpochsInDay < curTipset.Height() { // FIXME replace with DealUpdatesInterval
+			} else if d.Proposal.StartEpoch+filbuiltin.EpochsInDay < stateEpoch { // FIXME replace with DealUpdatesInterval
 				// if things are that late: they are never going to make it
 				d.status = "terminated"
 				d.terminationReason = "containing sector missed expected sealing epoch"
 			}
 
-			// because of how we account for datacap, the in-db value must reflect everything not-yet-activated
-			if dcap, known := tenantClientDatacap[d.Proposal.Client]; known && d.Proposal.VerifiedDeal && d.status == "published" {
-				if dcap == nil {
-					return xerrors.Errorf("client %s does not seem to have datacap yet published fil+ deal %d", d.Proposal.Client, d.dealID)
-				}
-				nv := filbig.Add(*dcap, filbig.NewInt(int64(d.Proposal.PieceSize)))
-				tenantClientDatacap[d.Proposal.Client] = &nv
-			}
-
 			dealCountsByState[d.status]++
 			if d.prevState == nil {
 				if d.status == "terminated" {
@@ -204,6 +321,20 @@ var trackDeals = &ufcli.Command{
 			}
 		}
 
+		if trackDealsSnapshot != "" {
+			log.Infow("streaming Market Deals from", "snapshot", trackDealsSnapshot, "epoch", stateEpoch)
+			if err := streamSnapshotDeals(trackDealsSnapshot, func(dealID filabi.DealID, d *lotusapi.MarketDeal) error {
+				classifyDeal(int64(dealID), d)
+				return nil
+			}); err != nil {
+				return cmn.WrErr(err)
+			}
+		} else {
+			for dealID, protoDeal := range stateDeals {
+				classifyDeal(dealID, protoDeal)
+			}
+		}
+
 		// fill in some blanks
 		for _, d := range toUpsert {
 
@@ -246,13 +377,21 @@ var trackDeals = &ufcli.Command{
 			d.pieceLog2Size = uint8(bits.TrailingZeros64(uint64(d.Proposal.PieceSize)))
 		}
 
-		toFail := make([]int64, 0, len(initialDbDeals))
-		// whatever remains here is gone from the state entirely
-		for dID, d := range initialDbDeals {
-			dealCountsByState["terminated"]++
-			if d.status != "terminated" {
-				dealCountsByState["terminatedNew"]++
-				toFail = append(toFail, dID)
+		toFail := removedDeals
+		if trackDealsSnapshot != "" {
+			// a dump can be incomplete or stale in ways the chain can not: never terminate based on one
+			if len(initialDbDeals) > 0 {
+				log.Infof("leaving %s deals not present in the snapshot for the next regular run", humanize.Comma(int64(len(initialDbDeals))))
+			}
+		} else if fullResync {
+			// whatever remains here is gone from the state entirely
+			toFail = make([]int64, 0, len(initialDbDeals))
+			for dID, d := range initialDbDeals {
+				dealCountsByState["terminated"]++
+				if d.status != "terminated" {
+					dealCountsByState["terminatedNew"]++
+					toFail = append(toFail, dID)
+				}
 			}
 		}
 
@@ -264,6 +403,9 @@ var trackDeals = &ufcli.Command{
 
 		return db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
 
+			// how many deals changed status within this run
+			dealChanges := int64(len(toUpsert))
+
 			for _, d := range toUpsert {
 				if err = tx.QueryRow(
 					ctx,
@@ -325,7 +467,7 @@ var trackDeals = &ufcli.Command{
 
 			// we may have some terminations ( no longer in the market state )
 			if len(toFail) > 0 {
-				if _, err = tx.Exec(
+				ct, err := tx.Exec(
 					ctx,
 					`
 					UPDATE spd.published_deals SET
@@ -337,17 +479,55 @@ var trackDeals = &ufcli.Command{
 						status != 'terminated'
 					`,
 					toFail,
-				); err != nil {
+				)
+				if err != nil {
 					return cmn.WrErr(err)
 				}
+				dealChanges += ct.RowsAffected()
+				if !fullResync {
+					dealCountsByState["terminatedNew"] += ct.RowsAffected()
+				}
+			}
+
+			// failing deals or proposals wholesale is left to the next regular run when importing
+			// a snapshot: the dump need not cover all that happened up to its epoch, and knows
+			// nothing of what activated since
+			if trackDealsSnapshot == "" {
+				terminatedBefore := dealCountsByState["terminatedNew"]
+				if err := failStaleDealsAndProposals(ctx, tx, stateEpoch, dealCountsByState); err != nil {
+					return cmn.WrErr(err)
+				}
+				dealChanges += dealCountsByState["terminatedNew"] - terminatedBefore
 			}
 
 			// update datacap
-			for c, d := range tenantClientDatacap {
+			for c, dcap := range tenantClientDatacap {
+
+				// because of how we account for datacap, the in-db value must reflect everything not-yet-activated
+				var pendingBytes int64
+				if err := tx.QueryRow(
+					ctx,
+					`
+					SELECT COALESCE( SUM( 1::BIGINT << claimed_log2_size ), 0 )
+						FROM spd.published_deals
+					WHERE
+						client_id = $1
+							AND
+						is_filplus
+							AND
+						status = 'published'
+					`,
+					c,
+				).Scan(&pendingBytes); err != nil {
+					return cmn.WrErr(err)
+				}
+
 				var di *int64
-				if d != nil {
-					v := d.Int64()
+				if dcap != nil {
+					v := dcap.Int64() + pendingBytes
 					di = &v
+				} else if pendingBytes > 0 {
+					return xerrors.Errorf("client %s does not seem to have datacap yet has published fil+ deals", c)
 				}
 				if _, err := tx.Exec(
 					ctx,
@@ -358,7 +538,7 @@ var trackDeals = &ufcli.Command{
 						client_id = $2
 					`,
 					di,
-					fil.MustParseActorString(c.String()),
+					c,
 				); err != nil {
 					return cmn.WrErr(err)
 				}
@@ -389,73 +569,23 @@ var trackDeals = &ufcli.Command{
 				return cmn.WrErr(err)
 			}
 
-			// clear out proposals that will never make it
-			if _, err := tx.Exec(
-				ctx,
-				`
-				UPDATE spd.proposals SET
-					proposal_failstamp = spd.big_now(),
-					proposal_meta = JSONB_SET(
-						proposal_meta,
-						'{ failure }',
-						TO_JSONB( 'proposal DealStartEpoch missed without activation'::TEXT )
-					)
-				WHERE
-					proposal_failstamp = 0
-						AND
-					activated_deal_id IS NULL
-						AND
-					start_epoch < $1
-				`,
-				curTipset.Height()-filbuiltin.EpochsInDay, // FIXME replace with DealUpdatesInterval
-			); err != nil {
-				return cmn.WrErr(err)
+			// Nothing moved: neither bump the state epoch, nor spend minutes recomputing identical
+			// matviews. The previous mark remains a valid base to diff against on the next run.
+			if !fullResync && dealChanges == 0 {
+				log.Infof("no deal changed status since the market state at epoch %d, leaving it and the materialized views as-is", prevMark.Epoch)
+				return nil
 			}
 
-			// clear out proposals that had an active deal which subsequently terminated
-			if _, err := tx.Exec(
-				ctx,
-				`
-				UPDATE spd.proposals SET
-					activated_deal_id = NULL,
-					proposal_failstamp = spd.big_now(),
-					proposal_meta = JSONB_SET(
-						proposal_meta,
-						'{ failure }',
-						TO_JSONB( 'sector containing deal was terminated'::TEXT )
-					)
-				WHERE
-					activated_deal_id IN ( SELECT deal_id FROM spd.published_deals WHERE status = 'terminated' )
-				`,
-			); err != nil {
-				return cmn.WrErr(err)
+			ms := marketStateMark{
+				Epoch:  stateEpoch,
+				Tipset: stateTipsetKey,
 			}
-
-			// clear out proposals that had an active deal which subsequently was deemed invalid
-			if _, err := tx.Exec(
-				ctx,
-				`
-				UPDATE spd.proposals SET
-					proposal_failstamp = spd.big_now(),
-					proposal_meta = JSONB_SET(
-						proposal_meta,
-						'{ failure }',
-						TO_JSONB( 'deal declared invalid'::TEXT )
-					)
-				WHERE
-					activated_deal_id IN ( SELECT deal_id FROM spd.invalidated_deals )
-				`,
-			); err != nil {
-				return cmn.WrErr(err)
+			// a snapshot can not be diffed against: the next run will perform a full resync
+			if marketActor != nil {
+				ms.ActorCode = &marketActor.Code
+				ms.ActorHead = &marketActor.Head
 			}
-
-			msJ, _ := json.Marshal(struct {
-				Epoch  filabi.ChainEpoch    `json:"epoch"`
-				Tipset lotustypes.TipSetKey `json:"tipset"`
-			}{
-				Epoch:  curTipset.Height(),
-				Tipset: curTipset.Key(),
-			})
+			msJ, _ := json.Marshal(ms)
 
 			if _, err := tx.Exec(
 				ctx,
@@ -471,3 +601,193 @@ var trackDeals = &ufcli.Command{
 		})
 	},
 }
+
+// failStaleDealsAndProposals terminates deals that can no longer make it as of stateEpoch, and
+// fails the proposals that will never activate or whose deal went away
+func failStaleDealsAndProposals(ctx context.Context, tx pgx.Tx, stateEpoch filabi.ChainEpoch, dealCountsByState map[string]int64) error {
+
+	// deals that are this late are never going to make it, yet remain in the
+	// market state until cron gets to them: they never show up as changed
+	ct, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.published_deals SET
+			status = 'terminated',
+			published_deal_meta = published_deal_meta || '{ "termination_reason":"containing sector missed expected sealing epoch" }'
+		WHERE
+			status = 'published'
+				AND
+			start_epoch < $1
+		`,
+		stateEpoch-filbuiltin.EpochsInDay, // FIXME replace with DealUpdatesInterval
+	)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	dealCountsByState["terminatedNew"] += ct.RowsAffected()
+
+	// clear out proposals that will never make it
+	if _, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.proposals SET
+			proposal_failstamp = spd.big_now(),
+			proposal_meta = JSONB_SET(
+				proposal_meta,
+				'{ failure }',
+				TO_JSONB( 'proposal DealStartEpoch missed without activation'::TEXT )
+			)
+		WHERE
+			proposal_failstamp = 0
+				AND
+			activated_deal_id IS NULL
+				AND
+			activated_claim_id IS NULL
+				AND
+			start_epoch < $1
+		`,
+		stateEpoch-filbuiltin.EpochsInDay, // FIXME replace with DealUpdatesInterval
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	// clear out proposals that had an active deal which subsequently terminated
+	if _, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.proposals SET
+			activated_deal_id = NULL,
+			proposal_failstamp = spd.big_now(),
+			proposal_meta = JSONB_SET(
+				proposal_meta,
+				'{ failure }',
+				TO_JSONB( 'sector containing deal was terminated'::TEXT )
+			)
+		WHERE
+			activated_deal_id IN ( SELECT deal_id FROM spd.published_deals WHERE status = 'terminated' )
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	// clear out proposals that had an active deal which subsequently was deemed invalid
+	if _, err := tx.Exec(
+		ctx,
+		`
+		UPDATE spd.proposals SET
+			proposal_failstamp = spd.big_now(),
+			proposal_meta = JSONB_SET(
+				proposal_meta,
+				'{ failure }',
+				TO_JSONB( 'deal declared invalid'::TEXT )
+			)
+		WHERE
+			activated_deal_id IN ( SELECT deal_id FROM spd.invalidated_deals )
+		`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return nil
+}
+
+// marketStateChanges returns the deals whose proposal or state differs between two instances
+// of the market actor, as seen in cur, along with the ids of the deals no longer present in cur.
+// Only the parts of the proposals and states AMTs that differ are ever read.
+func marketStateChanges(ctx context.Context, capi app.ChainAPI, prevActor, curActor *lotustypes.Actor) (map[int64]*lotusapi.MarketDeal, []int64, error) {
+	changed := make(map[int64]*lotusapi.MarketDeal, 1024)
+	var removed []int64
+
+	if prevActor.Head == curActor.Head {
+		return changed, removed, nil
+	}
+
+	prev, err := app.MarketState(ctx, capi, prevActor)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, err := app.MarketState(ctx, capi, curActor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	curProposals, err := cur.Proposals()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if proposalsChanged, err := cur.ProposalsChanged(prev); err != nil {
+		return nil, nil, err
+	} else if proposalsChanged {
+		prevProposals, err := prev.Proposals()
+		if err != nil {
+			return nil, nil, err
+		}
+		pc, err := lotusmarket.DiffDealProposals(prevProposals, curProposals)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range pc.Added {
+			changed[int64(p.ID)] = &lotusapi.MarketDeal{
+				Proposal: p.Proposal,
+				State:    *lotusmarket.EmptyDealState(), // overwritten below if it is also already in the states AMT
+			}
+		}
+		for _, p := range pc.Removed {
+			removed = append(removed, int64(p.ID))
+		}
+	}
+
+	if statesChanged, err := cur.StatesChanged(prev); err != nil {
+		return nil, nil, err
+	} else if statesChanged {
+		prevStates, err := prev.States()
+		if err != nil {
+			return nil, nil, err
+		}
+		curStates, err := cur.States()
+		if err != nil {
+			return nil, nil, err
+		}
+		sc, err := lotusmarket.DiffDealStates(prevStates, curStates)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		upd := make([]lotusmarket.DealIDState, 0, len(sc.Added)+len(sc.Modified))
+		upd = append(upd, sc.Added...)
+		for _, m := range sc.Modified {
+			upd = append(upd, lotusmarket.DealIDState{ID: m.ID, Deal: *m.To})
+		}
+		// removed states always come with a removed proposal, handled above
+
+		for _, u := range upd {
+			if d, seen := changed[int64(u.ID)]; seen {
+				d.State = u.Deal
+				continue
+			}
+			p, found, err := curProposals.Get(u.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !found {
+				return nil, nil, xerrors.Errorf("state of deal %d present without a corresponding proposal", u.ID)
+			}
+			changed[int64(u.ID)] = &lotusapi.MarketDeal{Proposal: *p, State: u.Deal}
+		}
+	}
+
+	return changed, removed, nil
+}
+
+// streamSnapshotDeals feeds every deal within a StateMarketDeals dump to cb, without holding
+// more than one of them in memory
+func streamSnapshotDeals(path string, cb func(filabi.DealID, *lotusapi.MarketDeal) error) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	return app.ReadMarketDealsSnapshot(fh, cb)
+}
@@ -30,5 +30,14 @@ func refreshMatviews(ctx context.Context, tx pgx.Tx) error {
 		log.Infow("refreshed", "view", mv, "took_seconds", time.Since(t0).Truncate(time.Millisecond).Seconds())
 	}
 
+	// listings paginated across a refresh would skip or repeat entries: the webapi keys its
+	// continuation tokens on this marker, as the market state epoch does not move on every refresh
+	if _, err := tx.Exec(
+		ctx,
+		`UPDATE spd.global SET metadata = JSONB_SET( metadata, '{ matviews_refreshed }', TO_JSONB( spd.big_now() ) )`,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
 	return nil
 }
@@ -0,0 +1,133 @@
+package app //nolint:revive
+
+import (
+	"bytes"
+	"context"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	fildatacap "github.com/filecoin-project/go-state-types/builtin/v9/datacap"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ribasushi/spade/internal/filtypes"
+	"golang.org/x/xerrors"
+)
+
+// Onboarding modes selectable via tenant_meta.onboarding
+//
+//nolint:revive
+const (
+	OnboardingMarket = "market" // the default: f05 deals proposed over boost's storage protocol
+	OnboardingDDO    = "ddo"    // direct data onboarding: f06 allocations funded by a datacap transfer
+)
+
+// AllocationTerms returns the verified-registry term bounds corresponding to a deal
+// of the given duration, adjusted to what the registry accepts
+func AllocationTerms(duration filabi.ChainEpoch) (termMin, termMax filabi.ChainEpoch) { //nolint:revive
+	termMin = duration
+	if termMin < filverifreg.MinimumVerifiedAllocationTerm {
+		termMin = filverifreg.MinimumVerifiedAllocationTerm
+	}
+
+	// leave room for the SP to extend the sector without the claim falling off
+	termMax = termMin + 90*filbuiltin.EpochsInDay
+	if termMax > filverifreg.MaximumVerifiedAllocationTerm {
+		termMax = filverifreg.MaximumVerifiedAllocationTerm
+	}
+	if termMin > termMax {
+		termMin = termMax
+	}
+
+	return termMin, termMax
+}
+
+// AllocationMessage returns the unsigned datacap transfer by which a verified client
+// funds the given allocation requests with the verified registry
+func AllocationMessage(from filaddr.Address, reqs []filverifreg.AllocationRequest) (*lotustypes.Message, error) { //nolint:revive
+	if len(reqs) == 0 {
+		return nil, xerrors.New("no allocation requests supplied")
+	}
+
+	ar := filtypes.AllocationRequests{
+		Allocations: make([]filtypes.AllocationRequest, len(reqs)),
+		Extensions:  []filtypes.ClaimExtensionRequest{},
+	}
+	for i := range reqs {
+		ar.Allocations[i] = filtypes.AllocationRequest(reqs[i])
+	}
+	var opData bytes.Buffer
+	if err := ar.MarshalCBOR(&opData); err != nil {
+		return nil, xerrors.Errorf("encoding allocation requests failed: %w", err)
+	}
+
+	// datacap is a token: 1 byte == 1 whole unit
+	var totalBytes uint64
+	for _, r := range reqs {
+		totalBytes += uint64(r.Size)
+	}
+
+	var params bytes.Buffer
+	if err := (&fildatacap.TransferParams{
+		To:           filbuiltin.VerifiedRegistryActorAddr,
+		Amount:       filbig.Mul(filbig.NewIntUnsigned(totalBytes), filbuiltin.TokenPrecision),
+		OperatorData: opData.Bytes(),
+	}).MarshalCBOR(&params); err != nil {
+		return nil, xerrors.Errorf("encoding transfer params failed: %w", err)
+	}
+
+	return &lotustypes.Message{
+		From:   from,
+		To:     filbuiltin.DatacapActorAddr,
+		Method: filbuiltin.MethodsDatacap.Transfer,
+		Params: params.Bytes(),
+		Value:  filbig.Zero(),
+	}, nil
+}
+
+// PushMessage signs msg via SignMessage and submits it to the mpool of capi
+func PushMessage(ctx context.Context, capi ChainAPI, signer Signer, msg *lotustypes.Message) (*lotustypes.SignedMessage, error) { //nolint:revive
+	sm, err := SignMessage(ctx, capi, signer, msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := capi.MpoolPush(ctx, sm); err != nil {
+		return nil, xerrors.Errorf("mpool push failed: %w", err)
+	}
+	return sm, nil
+}
+
+// SignMessage assigns nonce and gas to msg and signs it on behalf of its sender, without
+// submitting it anywhere. The sender may be an ID address, in which case it is replaced
+// by the key address behind it, as the mpool validates signatures against the latter
+func SignMessage(ctx context.Context, capi ChainAPI, signer Signer, msg *lotustypes.Message) (*lotustypes.SignedMessage, error) { //nolint:revive
+	m := *msg // do not modify what we were given
+	msg = &m
+
+	if msg.From.Protocol() == filaddr.ID {
+		k, err := capi.StateAccountKey(ctx, msg.From, lotustypes.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("unable to resolve key of %s: %w", msg.From, err)
+		}
+		msg.From = k
+	}
+
+	nonce, err := capi.MpoolGetNonce(ctx, msg.From)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to get nonce of %s: %w", msg.From, err)
+	}
+	msg.Nonce = nonce
+
+	msg, err = capi.GasEstimateMessageGas(ctx, msg, nil, lotustypes.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("gas estimation failed: %w", err)
+	}
+
+	sig, err := signer.Sign(ctx, msg.From, msg.Cid().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &lotustypes.SignedMessage{Message: *msg, Signature: *sig}, nil
+}
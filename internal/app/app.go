@@ -6,7 +6,6 @@ import (
 
 	filabi "github.com/filecoin-project/go-state-types/abi"
 	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
-	lotusapi "github.com/filecoin-project/lotus/api"
 	lotustypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -37,8 +36,8 @@ type (
 	dbtype        int
 	DbConns       map[dbtype]*pgxpool.Pool //nolint:revive
 	filapitype    int
-	FilAPIs       map[filapitype]*lotusapi.FullNodeStruct //nolint:revive
-	GlobalContext struct {                                //nolint:revive
+	FilAPIs       map[filapitype]ChainAPI //nolint:revive
+	GlobalContext struct {                //nolint:revive
 		Db       DbConns
 		LotusAPI FilAPIs
 		Logger   ufcli.Logger
@@ -52,6 +51,11 @@ func GetGlobalCtx(ctx context.Context) GlobalContext { //nolint:revive
 	return ctx.Value(ck).(GlobalContext)
 }
 
+// WithGlobalCtx returns a context carrying gctx, as set up by GlobalInit
+func WithGlobalCtx(ctx context.Context, gctx GlobalContext) context.Context { //nolint:revive
+	return context.WithValue(ctx, ck, gctx)
+}
+
 func UnpackCtx(ctx context.Context) ( //nolint:revive
 	origCtx context.Context,
 	logger ufcli.Logger,
@@ -65,7 +69,7 @@ func UnpackCtx(ctx context.Context) ( //nolint:revive
 var lotusLookbackEpochs uint
 
 func DefaultLookbackTipset(ctx context.Context) (*lotustypes.TipSet, error) { //nolint:revive
-	return fil.GetTipset(ctx, GetGlobalCtx(ctx).LotusAPI[FilLite], filabi.ChainEpoch(lotusLookbackEpochs))
+	return GetTipset(ctx, GetGlobalCtx(ctx).LotusAPI[FilLite], filabi.ChainEpoch(lotusLookbackEpochs))
 }
 
 var CommonFlags = []ufcli.Flag{ //nolint:revive
@@ -73,14 +77,6 @@ var CommonFlags = []ufcli.Flag{ //nolint:revive
 		Name:  "lotus-api-lite",
 		Value: "https://api.chain.love",
 	}),
-	ufcli.ConfStringFlag(&ufcli.StringFlag{
-		Name:  "lotus-api-heavy",
-		Value: "http://localhost:1234",
-	}),
-	ufcli.ConfStringFlag(&ufcli.StringFlag{
-		Name:        "lotus-api-heavy-token",
-		DefaultText: "  {{ private, read from config file }}  ",
-	}),
 	&ufcli.UintFlag{
 		Name:  "lotus-lookback-epochs",
 		Value: uint(FilDefaultLookback),
@@ -100,6 +96,24 @@ var CommonFlags = []ufcli.Flag{ //nolint:revive
 	}),
 }
 
+// HeavyFlags configure the full lotus node used for state reads a gateway can not serve
+// Only needed by components that actually access LotusAPI[FilHeavy]
+var HeavyFlags = []ufcli.Flag{ //nolint:revive
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:  "lotus-api-heavy",
+		Value: "http://localhost:1234",
+	}),
+}
+
+// HeavyTokenFlags additionally configure the token needed for wallet operations against
+// LotusAPI[FilHeavy]. Read-only components should leave them out
+var HeavyTokenFlags = []ufcli.Flag{ //nolint:revive
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:        "lotus-api-heavy-token",
+		DefaultText: "  {{ private, read from config file }}  ",
+	}),
+}
+
 func GlobalInit(cctx *ufcli.Context, uf *ufcli.UFcli) (func() error, error) { //nolint:revive
 
 	gctx := GlobalContext{
@@ -114,11 +128,15 @@ func GlobalInit(cctx *ufcli.Context, uf *ufcli.UFcli) (func() error, error) { //
 	}
 	gctx.LotusAPI[FilLite] = apiL
 
-	apiH, apiHeavyCloser, err := fil.LotusAPIClientV0(cctx.Context, cctx.String("lotus-api-heavy"), 300, cctx.String("lotus-api-heavy-token"))
-	if err != nil {
-		return nil, cmn.WrErr(err)
+	apiHeavyCloser := func() {}
+	if heavyURL := cctx.String("lotus-api-heavy"); heavyURL != "" {
+		apiH, closer, err := fil.LotusAPIClientV0(cctx.Context, heavyURL, 300, cctx.String("lotus-api-heavy-token"))
+		if err != nil {
+			return nil, cmn.WrErr(err)
+		}
+		gctx.LotusAPI[FilHeavy] = apiH
+		apiHeavyCloser = closer
 	}
-	gctx.LotusAPI[FilHeavy] = apiH
 
 	dbConnCfg, err := pgxpool.ParseConfig(cctx.String("pg-connstring"))
 	if err != nil {
@@ -136,7 +154,7 @@ func GlobalInit(cctx *ufcli.Context, uf *ufcli.UFcli) (func() error, error) { //
 		return nil, cmn.WrErr(err)
 	}
 
-	cctx.Context = context.WithValue(cctx.Context, ck, gctx)
+	cctx.Context = WithGlobalCtx(cctx.Context, gctx)
 
 	return func() error {
 		apiLiteCloser()
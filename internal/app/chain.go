@@ -0,0 +1,80 @@
+package app //nolint:revive
+
+import (
+	"context"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	lotusbuild "github.com/filecoin-project/lotus/build"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"golang.org/x/xerrors"
+)
+
+// ChainAPI is the complete set of Filecoin node calls spade makes, and nothing more
+// When adding a call make sure to also add it to internal/fakechain
+type ChainAPI interface { //nolint:revive
+	ChainHead(context.Context) (*lotustypes.TipSet, error)
+	ChainGetTipSetByHeight(context.Context, filabi.ChainEpoch, lotustypes.TipSetKey) (*lotustypes.TipSet, error)
+	StateGetBeaconEntry(context.Context, filabi.ChainEpoch) (*lotustypes.BeaconEntry, error)
+	StateMinerInfo(context.Context, filaddr.Address, lotustypes.TipSetKey) (lotusapi.MinerInfo, error)
+	StateAccountKey(context.Context, filaddr.Address, lotustypes.TipSetKey) (filaddr.Address, error)
+	MinerGetBaseInfo(context.Context, filaddr.Address, filabi.ChainEpoch, lotustypes.TipSetKey) (*lotusapi.MiningBaseInfo, error)
+	StateDealProviderCollateralBounds(context.Context, filabi.PaddedPieceSize, bool, lotustypes.TipSetKey) (lotusapi.DealCollateralBounds, error)
+	StateMarketDeals(context.Context, lotustypes.TipSetKey) (map[string]*lotusapi.MarketDeal, error)
+	StateGetActor(context.Context, filaddr.Address, lotustypes.TipSetKey) (*lotustypes.Actor, error)
+	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	StateVerifiedClientStatus(context.Context, filaddr.Address, lotustypes.TipSetKey) (*filabi.StoragePower, error)
+	StateGetAllocations(context.Context, filaddr.Address, lotustypes.TipSetKey) (map[filverifreg.AllocationId]filverifreg.Allocation, error)
+	StateGetClaims(context.Context, filaddr.Address, lotustypes.TipSetKey) (map[filverifreg.ClaimId]filverifreg.Claim, error)
+	StateSearchMsg(context.Context, lotustypes.TipSetKey, cid.Cid, filabi.ChainEpoch, bool) (*lotusapi.MsgLookup, error)
+	WalletSign(context.Context, filaddr.Address, []byte) (*filcrypto.Signature, error)
+	MpoolGetNonce(context.Context, filaddr.Address) (uint64, error)
+	GasEstimateMessageGas(context.Context, *lotustypes.Message, *lotusapi.MessageSendSpec, lotustypes.TipSetKey) (*lotustypes.Message, error)
+	MpoolPush(context.Context, *lotustypes.SignedMessage) (cid.Cid, error)
+}
+
+// the lotus RPC client is the production implementation
+var _ ChainAPI = (*lotusapi.FullNodeStruct)(nil)
+
+// GetTipset returns the tipset lookback epochs behind the current head, after making sure
+// the node is in sync with the wall clock
+func GetTipset(ctx context.Context, capi ChainAPI, lookback filabi.ChainEpoch) (*lotustypes.TipSet, error) { //nolint:revive
+	latestHead, err := capi.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("failed getting chain head: %w", err)
+	}
+
+	wallUnix := time.Now().Unix()
+	filUnix := int64(latestHead.Blocks()[0].Timestamp)
+
+	if wallUnix < filUnix-2 || // allow couple seconds clock-drift tolerance
+		wallUnix > filUnix+int64(
+			lotusbuild.PropagationDelaySecs+(fil.APIMaxTipsetsBehind*filbuiltin.EpochDurationSeconds),
+		) {
+		return nil, xerrors.Errorf(
+			"lotus API out of sync: chainHead reports unixtime %d (height: %d) while walltime is %d (delta: %s)",
+			filUnix,
+			latestHead.Height(),
+			wallUnix,
+			time.Second*time.Duration(wallUnix-filUnix),
+		)
+	}
+
+	if lookback == 0 {
+		return latestHead, nil
+	}
+
+	tipsetAtLookback, err := capi.ChainGetTipSetByHeight(ctx, latestHead.Height()-lookback, latestHead.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("determining target tipset %d epochs ago failed: %w", lookback, err)
+	}
+
+	return tipsetAtLookback, nil
+}
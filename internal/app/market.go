@@ -0,0 +1,46 @@
+package app //nolint:revive
+
+import (
+	"context"
+
+	lotusadt "github.com/filecoin-project/lotus/chain/actors/adt"
+	lotusmarket "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipldcbor "github.com/ipfs/go-ipld-cbor"
+	"golang.org/x/xerrors"
+)
+
+// chainBlockstore serves actor state blocks straight from the chain node, one
+// ChainReadObj per block. Only the blocks a diff actually visits are ever fetched
+type chainBlockstore struct {
+	capi ChainAPI
+}
+
+func (bs *chainBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	b, err := bs.capi.ChainReadObj(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("reading block %s failed: %w", c, err)
+	}
+	return blocks.NewBlockWithCid(b, c)
+}
+
+func (bs *chainBlockstore) Put(context.Context, blocks.Block) error {
+	return xerrors.New("chain state is read-only")
+}
+
+// ChainStore returns an actor-state store reading its blocks from capi
+func ChainStore(ctx context.Context, capi ChainAPI) lotusadt.Store { //nolint:revive
+	return lotusadt.WrapStore(ctx, ipldcbor.NewCborStore(&chainBlockstore{capi: capi}))
+}
+
+// MarketState loads the state of the given market actor instance, which need not be
+// the current one: any state whose blocks are still retained by the node will do
+func MarketState(ctx context.Context, capi ChainAPI, act *lotustypes.Actor) (lotusmarket.State, error) { //nolint:revive
+	st, err := lotusmarket.Load(ChainStore(ctx, capi), act)
+	if err != nil {
+		return nil, xerrors.Errorf("loading market state %s failed: %w", act.Head, err)
+	}
+	return st, nil
+}
@@ -0,0 +1,75 @@
+package app //nolint:revive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/xerrors"
+)
+
+// every zstd frame begins with this, see RFC 8878 section 3.1.1
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// ReadMarketDealsSnapshot decodes a StateMarketDeals dump in the JSON format lotus emits, or a
+// zstd-compressed stream of the same, invoking cb for every deal in the order they appear.
+// The dump is decoded incrementally: only a single deal is held in memory at any time.
+func ReadMarketDealsSnapshot(r io.Reader, cb func(filabi.DealID, *lotusapi.MarketDeal) error) error { //nolint:revive
+	br := bufio.NewReaderSize(r, 1<<20)
+	r = br
+
+	// a short read is left for the JSON decoder to complain about
+	if magic, err := br.Peek(len(zstdMagic)); err == nil && bytes.Equal(magic, zstdMagic) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return xerrors.Errorf("unable to initialize zstd decompression: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	dec := json.NewDecoder(r)
+
+	if err := expectJSONDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return xerrors.Errorf("unable to read deal id: %w", err)
+		}
+		k, isString := t.(string)
+		if !isString {
+			return xerrors.Errorf("unexpected deal id token %v", t)
+		}
+		dealID, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			return xerrors.Errorf("unexpected deal id '%s': %w", k, err)
+		}
+
+		var md lotusapi.MarketDeal
+		if err := dec.Decode(&md); err != nil {
+			return xerrors.Errorf("decoding deal %d failed: %w", dealID, err)
+		}
+		if err := cb(filabi.DealID(dealID), &md); err != nil {
+			return err
+		}
+	}
+	return expectJSONDelim(dec, '}')
+}
+
+func expectJSONDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return xerrors.Errorf("unable to read market deals snapshot: %w", err)
+	}
+	if t != d {
+		return xerrors.Errorf("unexpected token %v in market deals snapshot, expected '%s'", t, d)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package app //nolint:revive
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/keystore"
+	"github.com/ribasushi/spade/internal/signsvc"
+	"golang.org/x/xerrors"
+)
+
+// Signer produces signatures on behalf of tenant clients. The signer address may be
+// either an ID address or the key address behind it, same as with lotus' WalletSign
+type Signer interface { //nolint:revive
+	Sign(ctx context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error)
+}
+
+//nolint:revive
+const (
+	SignerLotusWallet = "lotus-wallet"
+	SignerKeystore    = "keystore"
+)
+
+// SignerFlags select and configure the Signer used by NewSigner()
+var SignerFlags = []ufcli.Flag{ //nolint:revive
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:  "signer-backend",
+		Usage: "How to sign deal proposals: '" + SignerLotusWallet + "' ( via lotus-api-heavy ) or '" + SignerKeystore + "'",
+		Value: SignerLotusWallet,
+	}),
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:  "signer-keystore-dir",
+		Usage: "Directory of encrypted client keys, used by the '" + SignerKeystore + "' signer backend",
+	}),
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:        "signer-keystore-passphrase",
+		DefaultText: "  {{ private, read from config file }}  ",
+	}),
+	ufcli.ConfStringFlag(&ufcli.StringFlag{
+		Name:        "signer-remote-endpoints",
+		Usage:       "Clients signing via their own remote signing service, overriding signer-backend. Such services sign deal proposals only, thus can not serve tenants onboarding via '" + OnboardingDDO + "'",
+		DefaultText: "  {{ f1client1=token1@https://signer1,f3client2=token2@https://signer2 read from config file }}  ",
+	}),
+}
+
+// NewSigner returns the Signer selected by SignerFlags
+// Must be called with a context set up by GlobalInit
+func NewSigner(cctx *ufcli.Context) (Signer, error) { //nolint:revive
+	gctx := GetGlobalCtx(cctx.Context)
+
+	var base Signer
+	switch b := cctx.String("signer-backend"); b {
+
+	case SignerLotusWallet:
+		if gctx.LotusAPI[FilHeavy] == nil {
+			return nil, xerrors.Errorf("signer backend '%s' requires lotus-api-heavy to be set", b)
+		}
+		base = &WalletSigner{API: gctx.LotusAPI[FilHeavy]}
+
+	case SignerKeystore:
+		dir := cctx.String("signer-keystore-dir")
+		if dir == "" {
+			return nil, xerrors.Errorf("signer backend '%s' requires signer-keystore-dir to be set", b)
+		}
+		ks, err := keystore.Open(dir, cctx.String("signer-keystore-passphrase"))
+		if err != nil {
+			return nil, err
+		}
+		base = NewKeystoreSigner(ks, gctx.LotusAPI[FilLite])
+
+	default:
+		return nil, xerrors.Errorf("unknown signer backend '%s'", b)
+	}
+
+	remotes, err := parseRemoteSigners(cctx.String("signer-remote-endpoints"))
+	if err != nil {
+		return nil, err
+	}
+	if len(remotes) == 0 {
+		return base, nil
+	}
+	return &RoutingSigner{
+		keys:     newKeyAddrCache(gctx.LotusAPI[FilLite]),
+		routes:   remotes,
+		fallback: base,
+	}, nil
+}
+
+// parseRemoteSigners parses a comma-separated list of client=token@baseURL entries
+func parseRemoteSigners(s string) (map[filaddr.Address]Signer, error) {
+	remotes := make(map[filaddr.Address]Signer)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addrStr, endpoint, found := strings.Cut(entry, "=")
+		token, baseURL, hasToken := strings.Cut(endpoint, "@")
+		if !found || !hasToken || token == "" {
+			return nil, xerrors.Errorf("remote signer entry for '%s' is not in the form client=token@url", addrStr)
+		}
+		addr, err := filaddr.NewFromString(strings.TrimSpace(addrStr))
+		if err != nil {
+			return nil, xerrors.Errorf("remote signer entry for '%s' has an invalid client address: %w", addrStr, err)
+		}
+		if addr.Protocol() != filaddr.SECP256K1 && addr.Protocol() != filaddr.BLS {
+			return nil, xerrors.Errorf("remote signer entry for %s must use the client key address", addr)
+		}
+		u, err := url.Parse(baseURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, xerrors.Errorf("remote signer entry for %s has an invalid URL", addr)
+		}
+		// the bearer token and the proposals must not cross the network in the clear
+		if u.Scheme == "http" && !isLoopbackHost(u.Hostname()) {
+			return nil, xerrors.Errorf("remote signer entry for %s must use https, plain http is only accepted for loopback hosts", addr)
+		}
+		if _, dup := remotes[addr]; dup {
+			return nil, xerrors.Errorf("remote signer for %s is listed more than once", addr)
+		}
+		remotes[addr] = &signsvc.Client{BaseURL: baseURL, Token: token}
+	}
+	return remotes, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// WalletSigner signs via the wallet of a lotus node, which must hold every client key
+type WalletSigner struct { //nolint:revive
+	API ChainAPI
+}
+
+func (ws *WalletSigner) Sign(ctx context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error) { //nolint:revive
+	return ws.API.WalletSign(ctx, signer, msg)
+}
+
+// KeystoreSigner signs in-process with the keys of a local keystore. ID addresses are
+// resolved to their key address via chain state, and remembered for the life of the signer
+type KeystoreSigner struct { //nolint:revive
+	ks   *keystore.Keystore
+	keys *keyAddrCache
+}
+
+func NewKeystoreSigner(ks *keystore.Keystore, resolver ChainAPI) *KeystoreSigner { //nolint:revive
+	return &KeystoreSigner{
+		ks:   ks,
+		keys: newKeyAddrCache(resolver),
+	}
+}
+
+func (kss *KeystoreSigner) Sign(ctx context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error) { //nolint:revive
+	ka, err := kss.keys.resolve(ctx, signer)
+	if err != nil {
+		return nil, err
+	}
+	return kss.ks.Sign(ka, msg)
+}
+
+// RoutingSigner sends the proposals of clients with a remote signing service there,
+// and everything else to the fallback Signer
+type RoutingSigner struct { //nolint:revive
+	keys     *keyAddrCache
+	routes   map[filaddr.Address]Signer // by client key address
+	fallback Signer
+}
+
+func (rs *RoutingSigner) Sign(ctx context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error) { //nolint:revive
+	ka, err := rs.keys.resolve(ctx, signer)
+	if err != nil {
+		return nil, err
+	}
+	if remote, found := rs.routes[ka]; found {
+		return remote.Sign(ctx, ka, msg)
+	}
+	return rs.fallback.Sign(ctx, signer, msg)
+}
+
+// SignsRemotely returns whether the signatures of client are produced by a remote signing service
+func SignsRemotely(ctx context.Context, s Signer, client filaddr.Address) (bool, error) { //nolint:revive
+	rs, isRouting := s.(*RoutingSigner)
+	if !isRouting {
+		return false, nil
+	}
+	ka, err := rs.keys.resolve(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	_, found := rs.routes[ka]
+	return found, nil
+}
+
+type keyAddrCache struct {
+	resolver ChainAPI
+	mu       sync.Mutex
+	keyAddr  map[filaddr.Address]filaddr.Address
+}
+
+func newKeyAddrCache(resolver ChainAPI) *keyAddrCache {
+	return &keyAddrCache{
+		resolver: resolver,
+		keyAddr:  make(map[filaddr.Address]filaddr.Address),
+	}
+}
+
+func (kc *keyAddrCache) resolve(ctx context.Context, a filaddr.Address) (filaddr.Address, error) {
+	if a.Protocol() == filaddr.SECP256K1 || a.Protocol() == filaddr.BLS {
+		return a, nil
+	}
+
+	kc.mu.Lock()
+	ka, known := kc.keyAddr[a]
+	kc.mu.Unlock()
+	if known {
+		return ka, nil
+	}
+
+	// an account key never changes: no need for a lookback tipset
+	ka, err := kc.resolver.StateAccountKey(ctx, a, lotustypes.EmptyTSK)
+	if err != nil {
+		return filaddr.Undef, xerrors.Errorf("unable to resolve key address of %s: %w", a, err)
+	}
+
+	kc.mu.Lock()
+	kc.keyAddr[a] = ka
+	kc.mu.Unlock()
+	return ka, nil
+}
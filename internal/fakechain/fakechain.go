@@ -0,0 +1,527 @@
+// Package fakechain provides a deterministic, in-memory implementation of app.ChainAPI,
+// allowing webapi and cron code to be exercised without a live Filecoin node.
+//
+// All state is scripted by the caller. Tipsets and beacon entries are derived from the
+// epoch alone, everything else must be explicitly set, or results in the same kind of
+// "not found" response lotus would give.
+//
+// The market actor state is materialized as actual v9 actor state blocks whenever it is
+// requested after a change, and all previous versions are retained, allowing consumers
+// to diff any two states they have seen.
+package fakechain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filadt "github.com/filecoin-project/go-state-types/builtin/v9/util/adt"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	lotusblockstore "github.com/filecoin-project/lotus/blockstore"
+	lotusactors "github.com/filecoin-project/lotus/chain/actors"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	ipldcbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
+)
+
+// Chain is the fake. The zero value is not usable, use New()
+type Chain struct {
+	mu sync.Mutex
+
+	head       *filabi.ChainEpoch // nil follows the wall clock
+	nullRounds map[filabi.ChainEpoch]struct{}
+	beacons    map[filabi.ChainEpoch][]byte
+
+	miners      map[filaddr.Address]lotusapi.MinerInfo
+	eligible    map[filaddr.Address]bool
+	accountKeys map[filaddr.Address]filaddr.Address
+	walletKeys  map[filaddr.Address]walletKey
+
+	deals      map[string]*lotusapi.MarketDeal
+	marketHead cid.Cid // cid.Undef after any deal change, until the state is next materialized
+	blocks     lotusblockstore.MemBlockstore
+	datacap    map[filaddr.Address]filabi.StoragePower
+	collateral lotusapi.DealCollateralBounds
+
+	allocations map[filaddr.Address]map[filverifreg.AllocationId]filverifreg.Allocation // by client
+	claims      map[filaddr.Address]map[filverifreg.ClaimId]filverifreg.Claim           // by provider
+
+	nonces     map[filaddr.Address]uint64
+	pushed     []*lotustypes.SignedMessage
+	msgLookups map[cid.Cid]lotusapi.MsgLookup
+}
+
+var _ app.ChainAPI = (*Chain)(nil)
+
+// New returns an empty fake chain, with its head following the wall clock
+func New() *Chain {
+	return &Chain{
+		nullRounds:  make(map[filabi.ChainEpoch]struct{}),
+		beacons:     make(map[filabi.ChainEpoch][]byte),
+		miners:      make(map[filaddr.Address]lotusapi.MinerInfo),
+		eligible:    make(map[filaddr.Address]bool),
+		accountKeys: make(map[filaddr.Address]filaddr.Address),
+		walletKeys:  make(map[filaddr.Address]walletKey),
+		deals:       make(map[string]*lotusapi.MarketDeal),
+		blocks:      lotusblockstore.NewMemory(),
+		datacap:     make(map[filaddr.Address]filabi.StoragePower),
+		allocations: make(map[filaddr.Address]map[filverifreg.AllocationId]filverifreg.Allocation),
+		claims:      make(map[filaddr.Address]map[filverifreg.ClaimId]filverifreg.Claim),
+		nonces:      make(map[filaddr.Address]uint64),
+		msgLookups:  make(map[cid.Cid]lotusapi.MsgLookup),
+		collateral: lotusapi.DealCollateralBounds{
+			Min: filbig.NewInt(1 << 20),
+			Max: filbig.NewInt(1 << 30),
+		},
+	}
+}
+
+//
+// Scripting
+//
+
+// SetHead pins the chain head to the given epoch
+// Note that app.GetTipset() refuses a head too far from the wall clock
+func (fc *Chain) SetHead(e filabi.ChainEpoch) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.head = &e
+}
+
+// FollowWallClock reverts the effect of SetHead()
+func (fc *Chain) FollowWallClock() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.head = nil
+}
+
+// SetNullRound marks an epoch as having no tipset
+func (fc *Chain) SetNullRound(e filabi.ChainEpoch) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.nullRounds[e] = struct{}{}
+}
+
+// SetBeacon overrides the otherwise derived beacon entry data of an epoch
+func (fc *Chain) SetBeacon(e filabi.ChainEpoch, data []byte) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.beacons[e] = data
+}
+
+// SetMiner defines a storage provider, and whether it is eligible to mine
+func (fc *Chain) SetMiner(sp filaddr.Address, mi lotusapi.MinerInfo, eligibleForMining bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.miners[sp] = mi
+	fc.eligible[sp] = eligibleForMining
+}
+
+// SetAccountKey defines the key address behind an ID address
+func (fc *Chain) SetAccountKey(id, key filaddr.Address) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.accountKeys[id] = key
+}
+
+// SetDeal adds or replaces a market deal
+func (fc *Chain) SetDeal(dealID filabi.DealID, d lotusapi.MarketDeal) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.deals[dealIDKey(dealID)] = &d
+	fc.marketHead = cid.Undef
+}
+
+// RemoveDeal removes a market deal, as happens on expiration or slashing
+func (fc *Chain) RemoveDeal(dealID filabi.DealID) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.deals, dealIDKey(dealID))
+	fc.marketHead = cid.Undef
+}
+
+// SetDatacap sets the remaining datacap of a verified client
+func (fc *Chain) SetDatacap(client filaddr.Address, dcap filabi.StoragePower) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.datacap[client] = dcap
+}
+
+// SetCollateralBounds sets the response of StateDealProviderCollateralBounds
+func (fc *Chain) SetCollateralBounds(b lotusapi.DealCollateralBounds) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.collateral = b
+}
+
+// SetAllocation adds or replaces a verified-registry allocation of the client named within
+func (fc *Chain) SetAllocation(id filverifreg.AllocationId, a filverifreg.Allocation) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	cl := mustIDAddr(a.Client)
+	if fc.allocations[cl] == nil {
+		fc.allocations[cl] = make(map[filverifreg.AllocationId]filverifreg.Allocation)
+	}
+	fc.allocations[cl][id] = a
+}
+
+// RemoveAllocation removes an allocation, as happens when it is claimed or expires
+func (fc *Chain) RemoveAllocation(client filaddr.Address, id filverifreg.AllocationId) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.allocations[client], id)
+}
+
+// SetClaim adds or replaces a verified-registry claim of the provider named within
+func (fc *Chain) SetClaim(id filverifreg.ClaimId, c filverifreg.Claim) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	sp := mustIDAddr(c.Provider)
+	if fc.claims[sp] == nil {
+		fc.claims[sp] = make(map[filverifreg.ClaimId]filverifreg.Claim)
+	}
+	fc.claims[sp][id] = c
+}
+
+// RemoveClaim removes a claim, as happens when its term is over or the sector is terminated
+func (fc *Chain) RemoveClaim(provider filaddr.Address, id filverifreg.ClaimId) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.claims[provider], id)
+}
+
+// SetMsgLookup sets the execution result StateSearchMsg returns for a message
+func (fc *Chain) SetMsgLookup(msgCid cid.Cid, ml lotusapi.MsgLookup) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.msgLookups[msgCid] = ml
+}
+
+// PushedMessages returns all messages submitted via MpoolPush, in order of submission
+func (fc *Chain) PushedMessages() []*lotustypes.SignedMessage {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]*lotustypes.SignedMessage(nil), fc.pushed...)
+}
+
+//
+// app.ChainAPI
+//
+
+func (fc *Chain) ChainHead(context.Context) (*lotustypes.TipSet, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.tipsetAtOrBelow(fc.headEpoch())
+}
+
+func (fc *Chain) ChainGetTipSetByHeight(_ context.Context, e filabi.ChainEpoch, _ lotustypes.TipSetKey) (*lotustypes.TipSet, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if e > fc.headEpoch() {
+		return nil, xerrors.Errorf("looking for tipset with height greater than start point")
+	}
+	return fc.tipsetAtOrBelow(e)
+}
+
+func (fc *Chain) StateGetBeaconEntry(_ context.Context, e filabi.ChainEpoch) (*lotustypes.BeaconEntry, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.beacon(e), nil
+}
+
+func (fc *Chain) StateMinerInfo(_ context.Context, sp filaddr.Address, _ lotustypes.TipSetKey) (lotusapi.MinerInfo, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	mi, found := fc.miners[sp]
+	if !found {
+		return lotusapi.MinerInfo{}, xerrors.Errorf("actor not found: %s", sp)
+	}
+	return mi, nil
+}
+
+func (fc *Chain) StateAccountKey(_ context.Context, a filaddr.Address, _ lotustypes.TipSetKey) (filaddr.Address, error) { //nolint:revive
+	if a.Protocol() == filaddr.BLS || a.Protocol() == filaddr.SECP256K1 {
+		return a, nil
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	k, found := fc.accountKeys[a]
+	if !found {
+		return filaddr.Undef, xerrors.Errorf("actor %s is not an account", a)
+	}
+	return k, nil
+}
+
+func (fc *Chain) MinerGetBaseInfo(_ context.Context, sp filaddr.Address, _ filabi.ChainEpoch, _ lotustypes.TipSetKey) (*lotusapi.MiningBaseInfo, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if _, found := fc.miners[sp]; !found {
+		return nil, nil
+	}
+	return &lotusapi.MiningBaseInfo{EligibleForMining: fc.eligible[sp]}, nil
+}
+
+func (fc *Chain) StateDealProviderCollateralBounds(context.Context, filabi.PaddedPieceSize, bool, lotustypes.TipSetKey) (lotusapi.DealCollateralBounds, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.collateral, nil
+}
+
+func (fc *Chain) StateMarketDeals(context.Context, lotustypes.TipSetKey) (map[string]*lotusapi.MarketDeal, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ret := make(map[string]*lotusapi.MarketDeal, len(fc.deals))
+	for k, d := range fc.deals {
+		dc := *d
+		ret[k] = &dc
+	}
+	return ret, nil
+}
+
+func (fc *Chain) StateGetActor(_ context.Context, a filaddr.Address, _ lotustypes.TipSetKey) (*lotustypes.Actor, error) { //nolint:revive
+	if a != filbuiltin.StorageMarketActorAddr {
+		return nil, xerrors.Errorf("actor not found: %s", a)
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.marketActor()
+}
+
+func (fc *Chain) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	b, err := fc.blocks.Get(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read object %s: %w", c, err)
+	}
+	return b.RawData(), nil
+}
+
+func (fc *Chain) StateVerifiedClientStatus(_ context.Context, client filaddr.Address, _ lotustypes.TipSetKey) (*filabi.StoragePower, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	dcap, found := fc.datacap[client]
+	if !found {
+		return nil, nil
+	}
+	return &dcap, nil
+}
+
+func (fc *Chain) StateGetAllocations(_ context.Context, client filaddr.Address, _ lotustypes.TipSetKey) (map[filverifreg.AllocationId]filverifreg.Allocation, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ret := make(map[filverifreg.AllocationId]filverifreg.Allocation, len(fc.allocations[client]))
+	for id, a := range fc.allocations[client] {
+		ret[id] = a
+	}
+	return ret, nil
+}
+
+func (fc *Chain) StateGetClaims(_ context.Context, provider filaddr.Address, _ lotustypes.TipSetKey) (map[filverifreg.ClaimId]filverifreg.Claim, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ret := make(map[filverifreg.ClaimId]filverifreg.Claim, len(fc.claims[provider]))
+	for id, c := range fc.claims[provider] {
+		ret[id] = c
+	}
+	return ret, nil
+}
+
+func (fc *Chain) StateSearchMsg(_ context.Context, _ lotustypes.TipSetKey, msgCid cid.Cid, _ filabi.ChainEpoch, _ bool) (*lotusapi.MsgLookup, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	ml, found := fc.msgLookups[msgCid]
+	if !found {
+		return nil, nil // same as lotus: not ( yet ) on chain
+	}
+	return &ml, nil
+}
+
+func (fc *Chain) WalletSign(_ context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error) { //nolint:revive
+	fc.mu.Lock()
+	// same as lotus: an ID address signs with the key behind it
+	if k, isID := fc.accountKeys[signer]; isID {
+		signer = k
+	}
+	wk, found := fc.walletKeys[signer]
+	fc.mu.Unlock()
+	if !found {
+		return nil, xerrors.Errorf("key not found for %s", signer)
+	}
+	return wk.sign(msg)
+}
+
+func (fc *Chain) MpoolGetNonce(_ context.Context, a filaddr.Address) (uint64, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.nonces[a], nil
+}
+
+func (fc *Chain) GasEstimateMessageGas(_ context.Context, msg *lotustypes.Message, _ *lotusapi.MessageSendSpec, _ lotustypes.TipSetKey) (*lotustypes.Message, error) { //nolint:revive
+	m := *msg
+	m.GasLimit = 10_000_000
+	m.GasFeeCap = filbig.NewInt(1000)
+	m.GasPremium = filbig.NewInt(100)
+	return &m, nil
+}
+
+func (fc *Chain) MpoolPush(_ context.Context, sm *lotustypes.SignedMessage) (cid.Cid, error) { //nolint:revive
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	// signatures are not checked: BLS verification is not available without filecoin-ffi
+	if expected := fc.nonces[sm.Message.From]; sm.Message.Nonce != expected {
+		return cid.Undef, xerrors.Errorf("message nonce %d does not match expected %d of %s", sm.Message.Nonce, expected, sm.Message.From)
+	}
+	fc.nonces[sm.Message.From]++
+	fc.pushed = append(fc.pushed, sm)
+	return sm.Cid(), nil
+}
+
+//
+// internals
+//
+
+func (fc *Chain) headEpoch() filabi.ChainEpoch {
+	if fc.head != nil {
+		return *fc.head
+	}
+	return fil.WallTimeEpoch(time.Now())
+}
+
+func (fc *Chain) tipsetAtOrBelow(e filabi.ChainEpoch) (*lotustypes.TipSet, error) {
+	for {
+		if e < 0 {
+			return nil, xerrors.New("no tipsets below genesis")
+		}
+		if _, isNull := fc.nullRounds[e]; !isNull {
+			break
+		}
+		e--
+	}
+
+	bh := &lotustypes.BlockHeader{
+		Miner:                 minerAddr,
+		Ticket:                &lotustypes.Ticket{VRFProof: epochDigest("ticket", e)},
+		ElectionProof:         &lotustypes.ElectionProof{WinCount: 1, VRFProof: epochDigest("election", e)},
+		BeaconEntries:         []lotustypes.BeaconEntry{*fc.beacon(e)},
+		Parents:               []cid.Cid{},
+		ParentWeight:          filbig.NewInt(int64(e)),
+		Height:                e,
+		ParentStateRoot:       placeholderCid,
+		ParentMessageReceipts: placeholderCid,
+		Messages:              placeholderCid,
+		Timestamp:             uint64(fil.MainnetTime(e).Unix()),
+		ParentBaseFee:         filbig.NewInt(100),
+	}
+	return lotustypes.NewTipSet([]*lotustypes.BlockHeader{bh})
+}
+
+// marketActor returns the market actor with a head reflecting the current deals
+func (fc *Chain) marketActor() (*lotustypes.Actor, error) {
+	code, found := lotusactors.GetActorCodeID(actorstypes.Version9, lotusactors.MarketKey)
+	if !found {
+		return nil, xerrors.New("market actor code not registered")
+	}
+
+	if fc.marketHead == cid.Undef {
+		store := filadt.WrapStore(context.Background(), ipldcbor.NewCborStore(fc.blocks))
+
+		st, err := filmarket.ConstructState(store)
+		if err != nil {
+			return nil, err
+		}
+		proposals, err := filadt.AsArray(store, st.Proposals, filmarket.ProposalsAmtBitwidth)
+		if err != nil {
+			return nil, err
+		}
+		states, err := filadt.AsArray(store, st.States, filmarket.StatesAmtBitwidth)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, d := range fc.deals {
+			dealID, err := strconv.ParseUint(k, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if err := proposals.Set(dealID, &d.Proposal); err != nil {
+				return nil, err
+			}
+			// same as on chain: a deal has no state entry until its sector is activated or slashed
+			if d.State.SectorStartEpoch > 0 || d.State.SlashEpoch > 0 {
+				if err := states.Set(dealID, &d.State); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if st.Proposals, err = proposals.Root(); err != nil {
+			return nil, err
+		}
+		if st.States, err = states.Root(); err != nil {
+			return nil, err
+		}
+		if fc.marketHead, err = store.Put(store.Context(), st); err != nil {
+			return nil, err
+		}
+	}
+
+	return &lotustypes.Actor{
+		Code:    code,
+		Head:    fc.marketHead,
+		Balance: filbig.Zero(),
+	}, nil
+}
+
+func (fc *Chain) beacon(e filabi.ChainEpoch) *lotustypes.BeaconEntry {
+	data, found := fc.beacons[e]
+	if !found {
+		data = epochDigest("beacon", e)
+	}
+	return &lotustypes.BeaconEntry{Round: uint64(e), Data: data}
+}
+
+func epochDigest(domain string, e filabi.ChainEpoch) []byte {
+	buf := make([]byte, 8, 8+len(domain))
+	binary.BigEndian.PutUint64(buf, uint64(e))
+	d := sha256.Sum256(append(buf, domain...))
+	return d[:]
+}
+
+func mustIDAddr(id filabi.ActorID) filaddr.Address {
+	a, err := filaddr.NewIDAddress(uint64(id))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func dealIDKey(dealID filabi.DealID) string {
+	return filbig.NewIntUnsigned(uint64(dealID)).String()
+}
+
+var (
+	minerAddr, _   = filaddr.NewIDAddress(1000)
+	placeholderCid = func() cid.Cid {
+		mh, err := multihash.Sum([]byte("fakechain"), multihash.IDENTITY, -1)
+		if err != nil {
+			panic(err)
+		}
+		return cid.NewCidV1(cid.Raw, mh)
+	}()
+)
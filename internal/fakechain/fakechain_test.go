@@ -0,0 +1,343 @@
+package fakechain
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	fildatacap "github.com/filecoin-project/go-state-types/builtin/v9/datacap"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	lotusmarket "github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/filtypes"
+)
+
+func TestTipsets(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+
+	// following the wall clock satisfies the sync check
+	if _, err := app.GetTipset(ctx, fc, 10); err != nil {
+		t.Fatalf("wall-clock head not accepted: %s", err)
+	}
+
+	fc.SetHead(1000)
+	fc.SetNullRound(999)
+	fc.SetNullRound(998)
+
+	ts, err := fc.ChainGetTipSetByHeight(ctx, 999, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Height() != 997 {
+		t.Errorf("expected null rounds to resolve to height 997, got %d", ts.Height())
+	}
+
+	again, err := fc.ChainGetTipSetByHeight(ctx, 997, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Key() != ts.Key() {
+		t.Errorf("tipset keys are not deterministic: %s != %s", again.Key(), ts.Key())
+	}
+
+	if _, err := fc.ChainGetTipSetByHeight(ctx, 1001, lotustypes.EmptyTSK); err == nil {
+		t.Error("tipset above head unexpectedly returned")
+	}
+
+	// far from the wall clock: must be refused
+	if _, err := app.GetTipset(ctx, fc, 0); err == nil {
+		t.Error("out of sync head unexpectedly accepted")
+	}
+}
+
+func TestState(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+
+	sp, _ := filaddr.NewIDAddress(1234)
+	worker, _ := filaddr.NewIDAddress(1235)
+	workerKey, err := fc.NewWalletKey(filcrypto.SigTypeBLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.SetAccountKey(worker, workerKey)
+	fc.SetMiner(sp, lotusapi.MinerInfo{Owner: worker, Worker: worker}, true)
+
+	mi, err := fc.StateMinerInfo(ctx, sp, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := fc.StateAccountKey(ctx, mi.Worker, lotustypes.EmptyTSK); err != nil || k != workerKey {
+		t.Errorf("unexpected worker key %s ( err: %v )", k, err)
+	}
+	if mbi, err := fc.MinerGetBaseInfo(ctx, sp, 0, lotustypes.EmptyTSK); err != nil || mbi == nil || !mbi.EligibleForMining {
+		t.Errorf("miner unexpectedly ineligible ( err: %v )", err)
+	}
+
+	other, _ := filaddr.NewIDAddress(4321)
+	if _, err := fc.StateMinerInfo(ctx, other, lotustypes.EmptyTSK); err == nil {
+		t.Error("info of undefined miner unexpectedly returned")
+	}
+	if dc, err := fc.StateVerifiedClientStatus(ctx, other, lotustypes.EmptyTSK); err != nil || dc != nil {
+		t.Errorf("undefined client unexpectedly has datacap %v ( err: %v )", dc, err)
+	}
+
+	fc.SetDatacap(other, filbig.NewInt(1<<40))
+	if dc, err := fc.StateVerifiedClientStatus(ctx, other, lotustypes.EmptyTSK); err != nil || dc == nil || !dc.Equals(filbig.NewInt(1<<40)) {
+		t.Errorf("unexpected datacap %v ( err: %v )", dc, err)
+	}
+
+	fc.SetDeal(42, lotusapi.MarketDeal{})
+	fc.SetDeal(43, lotusapi.MarketDeal{})
+	fc.RemoveDeal(43)
+	deals, err := fc.StateMarketDeals(ctx, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := deals["42"]; !found || len(deals) != 1 {
+		t.Errorf("unexpected deal set %v", deals)
+	}
+}
+
+func TestWalletSign(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+	msg := []byte("spade")
+
+	for _, st := range []filcrypto.SigType{filcrypto.SigTypeSecp256k1, filcrypto.SigTypeBLS} {
+		addr, err := fc.NewWalletKey(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := fc.WalletSign(ctx, addr, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Type != st {
+			t.Errorf("expected signature type %d, got %d", st, sig.Type)
+		}
+		// lotus' own verifier is only available for secp256k1 without filecoin-ffi
+		if st == filcrypto.SigTypeSecp256k1 {
+			if err := sigs.Verify(sig, addr, msg); err != nil {
+				t.Errorf("signature does not verify: %s", err)
+			}
+		}
+	}
+
+	unknown, _ := filaddr.NewIDAddress(1)
+	if _, err := fc.WalletSign(ctx, unknown, msg); err == nil {
+		t.Error("signing with an unknown key unexpectedly succeeded")
+	}
+}
+
+func TestRPCHandler(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+
+	srv := httptest.NewServer(fc.RPCHandler())
+	defer srv.Close()
+
+	api, closer, err := fil.LotusAPIClientV0(ctx, srv.URL, 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	// the lotus client satisfies the sync check against the fake just like the fake itself
+	if _, err := app.GetTipset(ctx, api, 10); err != nil {
+		t.Fatalf("wall-clock head not accepted over RPC: %s", err)
+	}
+
+	sp, _ := filaddr.NewIDAddress(1234)
+	worker, _ := filaddr.NewIDAddress(1235)
+	workerKey, err := fc.NewWalletKey(filcrypto.SigTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.SetAccountKey(worker, workerKey)
+	fc.SetMiner(sp, lotusapi.MinerInfo{Owner: worker, Worker: worker, SectorSize: 1 << 35}, true)
+
+	mi, err := api.StateMinerInfo(ctx, sp, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.Worker != worker || mi.SectorSize != 1<<35 {
+		t.Errorf("unexpected miner info %#v", mi)
+	}
+
+	// signing with the ID address resolves to its key, same as lotus
+	msg := []byte("spade")
+	sig, err := api.WalletSign(ctx, worker, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sigs.Verify(sig, workerKey, msg); err != nil {
+		t.Errorf("signature does not verify: %s", err)
+	}
+}
+
+func TestAllocationMessage(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+
+	client, _ := filaddr.NewIDAddress(3000)
+	clientKey, err := fc.NewWalletKey(filcrypto.SigTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.SetAccountKey(client, clientKey)
+
+	req := filverifreg.AllocationRequest{
+		Provider:   1234,
+		Data:       placeholderCid,
+		Size:       1 << 35,
+		TermMin:    filverifreg.MinimumVerifiedAllocationTerm,
+		TermMax:    filverifreg.MinimumVerifiedAllocationTerm + 90*filbuiltin.EpochsInDay,
+		Expiration: 100_000,
+	}
+	msg, err := app.AllocationMessage(client, []filverifreg.AllocationRequest{req, req})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(0); i < 2; i++ {
+		sm, err := app.PushMessage(ctx, fc, &app.WalletSigner{API: fc}, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Message.From != clientKey || sm.Message.Nonce != i {
+			t.Errorf("unexpected sender %s / nonce %d", sm.Message.From, sm.Message.Nonce)
+		}
+		if err := sigs.Verify(&sm.Signature, clientKey, sm.Message.Cid().Bytes()); err != nil {
+			t.Errorf("message signature does not verify: %s", err)
+		}
+	}
+	if len(fc.PushedMessages()) != 2 {
+		t.Fatalf("expected 2 pushed messages, got %d", len(fc.PushedMessages()))
+	}
+
+	var tp fildatacap.TransferParams
+	if err := tp.UnmarshalCBOR(bytes.NewReader(fc.PushedMessages()[0].Message.Params)); err != nil {
+		t.Fatal(err)
+	}
+	if !tp.Amount.Equals(filbig.Mul(filbig.NewInt(2<<35), filbuiltin.TokenPrecision)) {
+		t.Errorf("unexpected datacap amount %s", tp.Amount)
+	}
+	var reqs filtypes.AllocationRequests
+	if err := reqs.UnmarshalCBOR(bytes.NewReader(tp.OperatorData)); err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs.Allocations) != 2 || filverifreg.AllocationRequest(reqs.Allocations[1]) != req {
+		t.Errorf("unexpected allocation requests %#v", reqs)
+	}
+
+	fc.SetAllocation(7, filverifreg.Allocation{Client: 3000, Provider: 1234, Data: placeholderCid, Size: 1 << 35})
+	if al, err := fc.StateGetAllocations(ctx, client, lotustypes.EmptyTSK); err != nil || len(al) != 1 || al[7].Provider != 1234 {
+		t.Errorf("unexpected allocations %v ( err: %v )", al, err)
+	}
+	fc.RemoveAllocation(client, 7)
+	if al, err := fc.StateGetAllocations(ctx, client, lotustypes.EmptyTSK); err != nil || len(al) != 0 {
+		t.Errorf("unexpected allocations %v ( err: %v )", al, err)
+	}
+}
+
+func TestMarketStateDiff(t *testing.T) {
+	ctx := context.Background()
+	fc := New()
+
+	srv := httptest.NewServer(fc.RPCHandler())
+	defer srv.Close()
+
+	// read the state blocks over RPC, same as spade-cron does
+	api, closer, err := fil.LotusAPIClientV0(ctx, srv.URL, 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	sp, _ := filaddr.NewIDAddress(1234)
+	client, _ := filaddr.NewIDAddress(3000)
+	label, err := filmarket.NewLabelFromString("spade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := filmarket.DealProposal{
+		PieceCID:             placeholderCid,
+		PieceSize:            1 << 35,
+		VerifiedDeal:         true,
+		Client:               client,
+		Provider:             sp,
+		Label:                label,
+		StartEpoch:           1000,
+		EndEpoch:             2000,
+		StoragePricePerEpoch: filbig.Zero(),
+		ProviderCollateral:   filbig.Zero(),
+		ClientCollateral:     filbig.Zero(),
+	}
+	published := filmarket.DealState{SectorStartEpoch: -1, LastUpdatedEpoch: -1, SlashEpoch: -1}
+	active := filmarket.DealState{SectorStartEpoch: 900, LastUpdatedEpoch: -1, SlashEpoch: -1}
+
+	fc.SetDeal(1, lotusapi.MarketDeal{Proposal: prop, State: published})
+	fc.SetDeal(2, lotusapi.MarketDeal{Proposal: prop, State: active})
+
+	prevActor, err := api.StateGetActor(ctx, filbuiltin.StorageMarketActorAddr, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := api.StateGetActor(ctx, filbuiltin.StorageMarketActorAddr, lotustypes.EmptyTSK); err != nil || again.Head != prevActor.Head {
+		t.Fatalf("unchanged deals resulted in a different market state %v ( err: %v )", again, err)
+	}
+
+	fc.SetDeal(1, lotusapi.MarketDeal{Proposal: prop, State: active})
+	fc.RemoveDeal(2)
+	fc.SetDeal(3, lotusapi.MarketDeal{Proposal: prop, State: published})
+
+	curActor, err := api.StateGetActor(ctx, filbuiltin.StorageMarketActorAddr, lotustypes.EmptyTSK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := app.MarketState(ctx, api, prevActor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := app.MarketState(ctx, api, curActor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prevProposals, _ := prev.Proposals()
+	curProposals, _ := cur.Proposals()
+	pc, err := lotusmarket.DiffDealProposals(prevProposals, curProposals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pc.Added) != 1 || pc.Added[0].ID != 3 || len(pc.Removed) != 1 || pc.Removed[0].ID != 2 {
+		t.Errorf("unexpected proposal changes %+v", pc)
+	}
+
+	prevStates, _ := prev.States()
+	curStates, _ := cur.States()
+	sc, err := lotusmarket.DiffDealStates(prevStates, curStates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Added) != 1 || sc.Added[0].ID != 1 || sc.Added[0].Deal != active || len(sc.Removed) != 1 || sc.Removed[0].ID != 2 || len(sc.Modified) != 0 {
+		t.Errorf("unexpected state changes %+v", sc)
+	}
+
+	if _, err := api.StateGetActor(ctx, filbuiltin.VerifiedRegistryActorAddr, lotustypes.EmptyTSK); err == nil {
+		t.Error("state of unsupported actor unexpectedly returned")
+	}
+}
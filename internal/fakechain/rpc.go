@@ -0,0 +1,20 @@
+package fakechain
+
+import (
+	"net/http"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+// RPCHandler returns a handler serving the fake as a lotus JSON-RPC endpoint at /rpc/v0,
+// allowing the spade binaries to be pointed at it via their regular --lotus-api-* flags.
+// Only the app.ChainAPI calls are exposed, not the scripting methods.
+func (fc *Chain) RPCHandler() http.Handler {
+	srv := jsonrpc.NewServer()
+	srv.Register("Filecoin", struct{ app.ChainAPI }{fc})
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", srv)
+	return mux
+}
@@ -0,0 +1,73 @@
+package fakechain
+
+import (
+	"crypto/rand"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp" // register secp256k1 with lib/sigs
+	blst "github.com/supranational/blst/bindings/go"
+	"golang.org/x/xerrors"
+)
+
+// same as in webapi/utilSigVerify.go
+var blsDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+
+type walletKey struct {
+	sigType filcrypto.SigType
+	secp    []byte
+	bls     *blst.SecretKey
+}
+
+func (wk walletKey) sign(msg []byte) (*filcrypto.Signature, error) {
+	if wk.sigType == filcrypto.SigTypeBLS {
+		return &filcrypto.Signature{
+			Type: filcrypto.SigTypeBLS,
+			Data: new(blst.P2Affine).Sign(wk.bls, msg, blsDST).Compress(),
+		}, nil
+	}
+	return sigs.Sign(filcrypto.SigTypeSecp256k1, wk.secp, msg)
+}
+
+// NewWalletKey generates a fresh key of the given type, usable with WalletSign
+func (fc *Chain) NewWalletKey(sigType filcrypto.SigType) (filaddr.Address, error) {
+	var wk walletKey
+	var addr filaddr.Address
+
+	switch sigType {
+	case filcrypto.SigTypeSecp256k1:
+		pk, err := sigs.Generate(filcrypto.SigTypeSecp256k1)
+		if err != nil {
+			return filaddr.Undef, err
+		}
+		pub, err := sigs.ToPublic(filcrypto.SigTypeSecp256k1, pk)
+		if err != nil {
+			return filaddr.Undef, err
+		}
+		if addr, err = filaddr.NewSecp256k1Address(pub); err != nil {
+			return filaddr.Undef, err
+		}
+		wk = walletKey{sigType: sigType, secp: pk}
+
+	case filcrypto.SigTypeBLS:
+		ikm := make([]byte, 32)
+		if _, err := rand.Read(ikm); err != nil {
+			return filaddr.Undef, err
+		}
+		sk := blst.KeyGen(ikm)
+		var err error
+		if addr, err = filaddr.NewBLSAddress(new(blst.P1Affine).From(sk).Compress()); err != nil {
+			return filaddr.Undef, err
+		}
+		wk = walletKey{sigType: sigType, bls: sk}
+
+	default:
+		return filaddr.Undef, xerrors.Errorf("unsupported signature type %d", sigType)
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.walletKeys[addr] = wk
+	return addr, nil
+}
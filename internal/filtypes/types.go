@@ -6,19 +6,22 @@ package filtypes
 import (
 	"io"
 
+	filabi "github.com/filecoin-project/go-state-types/abi"
 	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
 	"github.com/fxamacker/cbor/v2"
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 )
 
-//go:generate go run github.com/hannahhoward/cbor-gen-for --map-encoding StorageProposalV12xParams StorageProposalV120Response
+//go:generate go run github.com/hannahhoward/cbor-gen-for --map-encoding StorageProposalV12xParams StorageProposalV120Response DealStatusV120Request DealStatusV120Response DealStatusV120State
 
 //nolint:revive
 const (
 	RetrievalQueryAsk   = "/fil/retrieval/qry/1.0.0"        // use the 1.0 protocol even if we do not care about PCIDs
 	RetrievalTransports = "/fil/retrieval/transports/1.0.0" // this is boost-specific, do not bring extra dependency
 	StorageProposalV120 = "/fil/storage/mk/1.2.0"           // same: boost-specific
+	DealStatusV120      = "/boost/status/1.2.0"             // same: boost-specific
 )
 
 // StorageProposalV12xParams is an amalgam of
@@ -42,6 +45,40 @@ type StorageProposalV120Response struct {
 	Message string
 }
 
+// DealStatusV120Request is a copy of https://github.com/filecoin-project/boost/blob/v1.5.1-rc3/storagemarket/types/types.go#L94-L99
+// The Signature is made by the deal client over the binary form of DealUUID
+type DealStatusV120Request struct {
+	DealUUID  uuid.UUID
+	Signature filcrypto.Signature
+}
+
+// DealStatusV120Response is a copy of https://github.com/filecoin-project/boost/blob/v1.5.1-rc3/storagemarket/types/types.go#L101-L111
+type DealStatusV120Response struct {
+	DealUUID uuid.UUID
+	// Error is non-empty if there is an error getting the deal status
+	// (eg invalid request signature)
+	Error          string
+	DealStatus     *DealStatusV120State
+	IsOffline      bool
+	TransferSize   uint64
+	NBytesReceived uint64
+}
+
+// DealStatusV120State is a copy of https://github.com/filecoin-project/boost/blob/v1.5.1-rc3/storagemarket/types/types.go#L113-L130
+type DealStatusV120State struct {
+	// Error is non-empty if the deal is in the error state
+	Error string
+	// Status is a string corresponding to a deal checkpoint
+	Status string
+	// SealingStatus is the sealing status reported by lotus miner
+	SealingStatus     string
+	Proposal          filmarket.DealProposal
+	SignedProposalCid cid.Cid
+	// PublishCid is nil until the deal has been published
+	PublishCid  *cid.Cid
+	ChainDealID filabi.DealID
+}
+
 // RetrievalTransports100RawResponse is a copy of https://github.com/filecoin-project/boost/blob/v1.5.0/retrievalmarket/types/transports.go#L12-L21
 type RetrievalTransports100RawResponse struct {
 	Protocols []struct {
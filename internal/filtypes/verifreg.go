@@ -0,0 +1,36 @@
+package filtypes
+
+import (
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	"github.com/ipfs/go-cid"
+)
+
+// The verified registry types below ship without CBOR encoders in go-state-types v0.9.9
+// They are the datacap transfer OperatorData funding allocations, thus tuple-encoded
+//
+//go:generate go run github.com/hannahhoward/cbor-gen-for AllocationRequests AllocationRequest ClaimExtensionRequest
+
+// AllocationRequests is a copy of https://github.com/filecoin-project/go-state-types/blob/v0.9.9/builtin/v9/verifreg/verifreg_types.go#L225-L228
+type AllocationRequests struct {
+	Allocations []AllocationRequest
+	Extensions  []ClaimExtensionRequest
+}
+
+// AllocationRequest is a copy of https://github.com/filecoin-project/go-state-types/blob/v0.9.9/builtin/v9/verifreg/verifreg_types.go#L202-L217
+// Convertible to and from filverifreg.AllocationRequest
+type AllocationRequest struct {
+	Provider   filabi.ActorID
+	Data       cid.Cid
+	Size       filabi.PaddedPieceSize
+	TermMin    filabi.ChainEpoch
+	TermMax    filabi.ChainEpoch
+	Expiration filabi.ChainEpoch
+}
+
+// ClaimExtensionRequest is a copy of https://github.com/filecoin-project/go-state-types/blob/v0.9.9/builtin/v9/verifreg/verifreg_types.go#L219-L223
+type ClaimExtensionRequest struct {
+	Provider filabi.ActorID
+	Claim    filverifreg.ClaimId
+	TermMax  filabi.ChainEpoch
+}
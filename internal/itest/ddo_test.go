@@ -0,0 +1,206 @@
+//go:build integration
+
+package itest
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	fildatacap "github.com/filecoin-project/go-state-types/builtin/v9/datacap"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/filtypes"
+)
+
+const testAllocationID = 77
+
+// TestDDOPipeline walks a single piece of a direct-data-onboarding tenant through the lifecycle:
+// eligible -> requested -> allocation submitted -> allocated -> claimed
+func TestDDOPipeline(t *testing.T) {
+	h := newHarness(t)
+
+	h.setupProvider(1 << 35)
+	h.setupClient(1 << 40)
+
+	pCid := testPieceCid(t, "itest ddo piece")
+	h.seedTenant(pCid)
+	h.mustExec(
+		`UPDATE spd.tenants SET tenant_meta = tenant_meta || '{ "onboarding": "ddo" }' WHERE tenant_id = $1`,
+		testTenantID,
+	)
+
+	h.cron("track-deals")
+	h.cron("poll-providers")
+	h.startWebapi()
+
+	var reservation apitypes.ResponseDealRequest
+	if env := h.spGet("/sp/request_piece/"+pCid.String(), &reservation); env.ResponseCode != http.StatusOK {
+		t.Fatalf("unexpected response to piece request: %d %v", env.ResponseCode, env.ErrLines)
+	}
+
+	var proposalUUID string
+	var hasMarketProposal bool
+	if err := h.queryRow(
+		`
+		SELECT proposal_uuid::TEXT, proposal_meta ? 'filmarket_proposal'
+			FROM spd.proposals
+		WHERE provider_id = $1 AND proposal_failstamp = 0 AND proposal_meta ? 'allocation_request'
+		`,
+		h.spID,
+	).Scan(&proposalUUID, &hasMarketProposal); err != nil {
+		t.Fatalf("reservation did not result in an allocation request: %s", err)
+	}
+	if hasMarketProposal {
+		t.Error("allocation request unexpectedly carries a market proposal")
+	}
+
+	//
+	// the client funds the allocation
+	//
+	h.cron("sign-pending")
+	h.cron("propose-pending", "--sleep-between-proposals=0")
+
+	if received := h.sp.received(); len(received) != 0 {
+		t.Fatalf("market proposals delivered for a DDO tenant: %+v", received)
+	}
+
+	pushed := h.chain.PushedMessages()
+	if len(pushed) != 1 {
+		t.Fatalf("expected exactly 1 allocation message, got %d", len(pushed))
+	}
+	msg := pushed[0].Message
+	if msg.From != h.clientKey || msg.To != filbuiltin.DatacapActorAddr || msg.Method != filbuiltin.MethodsDatacap.Transfer {
+		t.Fatalf("unexpected allocation message %+v", msg)
+	}
+	var tp fildatacap.TransferParams
+	if err := tp.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		t.Fatal(err)
+	}
+	var reqs filtypes.AllocationRequests
+	if err := reqs.UnmarshalCBOR(bytes.NewReader(tp.OperatorData)); err != nil {
+		t.Fatal(err)
+	}
+	if tp.To != filbuiltin.VerifiedRegistryActorAddr || len(reqs.Allocations) != 1 {
+		t.Fatalf("unexpected transfer %+v of %+v", tp, reqs)
+	}
+	req := reqs.Allocations[0]
+	if !req.Data.Equals(pCid) || req.Provider != filabi.ActorID(h.spID) || req.Size != 1<<35 {
+		t.Errorf("unexpected allocation request %+v", req)
+	}
+	if req.TermMin < filverifreg.MinimumVerifiedAllocationTerm || req.TermMax < req.TermMin {
+		t.Errorf("allocation request terms out of bounds: %d..%d", req.TermMin, req.TermMax)
+	}
+
+	//
+	// the message lands
+	//
+	h.cron("track-allocations")
+	if allocID, _ := h.allocationState(proposalUUID); allocID != nil {
+		t.Fatalf("allocation recorded before the message executed: %d", *allocID)
+	}
+
+	h.chain.SetMsgLookup(pushed[0].Cid(), lotusapi.MsgLookup{
+		Message: pushed[0].Cid(),
+		Receipt: lotustypes.MessageReceipt{
+			Return: transferReturn(t, filverifreg.AllocationsResponse{
+				AllocationResults: filverifreg.BatchReturn{SuccessCount: 1},
+				NewAllocations:    []filverifreg.AllocationId{testAllocationID},
+			}),
+		},
+		Height: fil.WallTimeEpoch(time.Now()) - 20,
+	})
+	h.cron("track-allocations")
+
+	if allocID, claimID := h.allocationState(proposalUUID); allocID == nil || *allocID != testAllocationID || claimID != nil {
+		t.Fatalf("unexpected state after allocation: allocation %v, claim %v", allocID, claimID)
+	}
+
+	pending := h.pendingProposals()
+	if len(pending) != 1 || pending[0].ProposalID != proposalUUID {
+		t.Fatalf("unexpected pending proposals: %+v", pending)
+	}
+	if pending[0].AllocationID == nil || *pending[0].AllocationID != testAllocationID || !strings.Contains(pending[0].ImportCmd, "--allocation-id=77") {
+		t.Errorf("pending allocation lacks import instructions: %+v", pending[0])
+	}
+
+	//
+	// the SP claims it
+	//
+	h.chain.SetClaim(testAllocationID, filverifreg.Claim{
+		Provider:  filabi.ActorID(h.spID),
+		Client:    filabi.ActorID(h.clientID),
+		Data:      pCid,
+		Size:      1 << 35,
+		TermMin:   req.TermMin,
+		TermMax:   req.TermMax,
+		TermStart: fil.WallTimeEpoch(time.Now()) - 10,
+		Sector:    1,
+	})
+	h.cron("track-allocations")
+
+	if _, claimID := h.allocationState(proposalUUID); claimID == nil || *claimID != testAllocationID {
+		t.Fatalf("claim not recorded: %v", claimID)
+	}
+
+	var reRequest apitypes.ResponseDealRequest
+	env := h.spGet("/sp/request_piece/"+pCid.String(), &reRequest)
+	if env.ResponseCode != http.StatusForbidden || env.ErrCode != int(apitypes.ErrProviderHasReplica) {
+		t.Fatalf("unexpected response to repeated piece request: %d/%d %v", env.ResponseCode, env.ErrCode, env.ErrLines)
+	}
+	if pending := h.pendingProposals(); len(pending) != 0 {
+		t.Errorf("claimed allocation still listed as pending: %+v", pending)
+	}
+
+	//
+	// and eventually the claim goes away
+	//
+	h.chain.RemoveClaim(h.spID.AsFilAddr(), testAllocationID)
+	h.cron("track-allocations")
+
+	var claimStatus string
+	if err := h.queryRow(`SELECT status FROM spd.verified_claims WHERE claim_id = $1`, testAllocationID).Scan(&claimStatus); err != nil {
+		t.Fatal(err)
+	}
+	if claimStatus != "terminated" {
+		t.Errorf("removed claim has status %q", claimStatus)
+	}
+}
+
+// allocationState returns the allocation and claim recorded against a proposal
+func (h *harness) allocationState(proposalUUID string) (allocationID, claimID *int64) {
+	h.t.Helper()
+
+	if err := h.queryRow(
+		`SELECT allocation_id, activated_claim_id FROM spd.proposals WHERE proposal_uuid = $1`,
+		proposalUUID,
+	).Scan(&allocationID, &claimID); err != nil {
+		h.t.Fatal(err)
+	}
+	return allocationID, claimID
+}
+
+// transferReturn is what the datacap actor returns once the verified registry accepted the allocations
+func transferReturn(t *testing.T, ar filverifreg.AllocationsResponse) []byte {
+	var rd bytes.Buffer
+	if err := ar.MarshalCBOR(&rd); err != nil {
+		t.Fatal(err)
+	}
+	var ret bytes.Buffer
+	if err := (&fildatacap.TransferReturn{
+		FromBalance:   filbig.Zero(),
+		ToBalance:     filbig.Zero(),
+		RecipientData: rd.Bytes(),
+	}).MarshalCBOR(&ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret.Bytes()
+}
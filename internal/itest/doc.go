@@ -0,0 +1,10 @@
+// Package itest holds the end-to-end tests of spade. They exercise the actual webapi and
+// cron binaries against a throwaway PostgreSQL instance initialized from misc/pg_schema.sql,
+// a scripted chain ( internal/fakechain served over JSON-RPC ) and a fake storage provider
+// speaking the boost libp2p protocols.
+//
+// The tests are behind the `integration` build tag and require the PostgreSQL server
+// binaries ( initdb, pg_ctl, psql ) to be available either in $PATH or via pg_config:
+//
+//	make itest
+package itest
@@ -0,0 +1,513 @@
+//go:build integration
+
+package itest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	lp2p "github.com/libp2p/go-libp2p"
+	lp2phost "github.com/libp2p/go-libp2p/core/host"
+	lp2pnet "github.com/libp2p/go-libp2p/core/network"
+	lp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	lp2ptcp "github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/fakechain"
+	"github.com/ribasushi/spade/internal/filtypes"
+)
+
+// tests run within the package directory
+const repoRoot = "../.."
+
+// harness wires the spade binaries to a throwaway database, the scripted chain and a fake SP
+type harness struct {
+	t     *testing.T
+	ctx   context.Context
+	dir   string
+	db    *pgxpool.Pool
+	chain *fakechain.Chain
+	sp    *fakeSP
+
+	binDir     string
+	homeDir    string
+	webapiAddr string
+
+	spID      fil.ActorID
+	spWorker  filaddr.Address
+	clientID  fil.ActorID
+	clientKey filaddr.Address
+
+	authNonce int
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:        t,
+		ctx:      context.Background(),
+		dir:      t.TempDir(),
+		chain:    fakechain.New(),
+		spID:     17000,
+		clientID: 17100,
+	}
+
+	pgConnString := startPostgres(t, h.dir)
+	var err error
+	if h.db, err = pgxpool.Connect(h.ctx, pgConnString); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.db.Close)
+
+	chainSrv := httptest.NewServer(h.chain.RPCHandler())
+	t.Cleanup(chainSrv.Close)
+
+	h.sp = startFakeSP(t)
+	h.binDir = buildBinaries(t, h.dir)
+	h.webapiAddr = freeLocalAddr(t)
+
+	// the binaries read their configuration from $HOME
+	h.homeDir = filepath.Join(h.dir, "home")
+	if err := os.MkdirAll(h.homeDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(h.homeDir, app.AppName+".toml"),
+		[]byte(fmt.Sprintf(
+			"lotus-api-lite = %q\nlotus-api-heavy = %q\npg-connstring = %q\nwebapi-listen-address = %q\n",
+			chainSrv.URL,
+			chainSrv.URL,
+			pgConnString,
+			h.webapiAddr,
+		)),
+		0o600,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// env is the environment the spade binaries are executed with
+func (h *harness) env() []string {
+	return append(
+		os.Environ(),
+		"HOME="+h.homeDir,
+		"TMPDIR="+h.homeDir, // location of the ufcli run-locks
+	)
+}
+
+// cron runs a spade-cron subcommand to completion, failing the test if it does not succeed
+func (h *harness) cron(subcmd string, args ...string) {
+	h.t.Helper()
+	cmd := exec.Command(filepath.Join(h.binDir, app.AppName+"-cron"), append([]string{subcmd}, args...)...)
+	cmd.Env = h.env()
+	run(h.t, cmd)
+}
+
+// startWebapi runs spade-webapi in the background until the end of the test
+func (h *harness) startWebapi() {
+	h.t.Helper()
+
+	logPath := filepath.Join(h.dir, "webapi.log")
+	logFh, err := os.Create(logPath)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	cmd := exec.Command(filepath.Join(h.binDir, app.AppName+"-webapi"))
+	cmd.Env = h.env()
+	cmd.Stdout, cmd.Stderr = logFh, logFh
+	if err := cmd.Start(); err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM) //nolint:errcheck
+		cmd.Wait()                          //nolint:errcheck
+		logFh.Close()                       //nolint:errcheck
+		if h.t.Failed() {
+			if l, err := os.ReadFile(logPath); err == nil {
+				h.t.Logf("spade-webapi output:\n%s", l)
+			}
+		}
+	})
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get("http://" + h.webapiAddr + "/openapi.json")
+		if err == nil {
+			resp.Body.Close() //nolint:errcheck
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("spade-webapi did not start listening on %s", h.webapiAddr)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// apiEnvelope mirrors apitypes.ResponseEnvelope, with the payload left for the caller to decode
+type apiEnvelope struct {
+	ResponseCode int             `json:"response_code"`
+	ErrCode      int             `json:"error_code"`
+	ErrLines     []string        `json:"error_lines"`
+	Response     json.RawMessage `json:"response"`
+}
+
+// spGet makes a request authenticated by the SP worker key, decoding the response payload into out
+func (h *harness) spGet(uri string, out interface{}) apiEnvelope {
+	h.t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+h.webapiAddr+uri, nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", h.spAuthHeader(http.MethodGet, uri))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	var env apiEnvelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		h.t.Fatalf("undecodable response to %s: %s", uri, err)
+	}
+	if env.ResponseCode != resp.StatusCode {
+		h.t.Errorf("envelope response_code %d does not match HTTP status %d", env.ResponseCode, resp.StatusCode)
+	}
+	if out != nil && len(env.Response) > 0 && string(env.Response) != "null" {
+		if err := json.Unmarshal(env.Response, out); err != nil {
+			h.t.Fatalf("undecodable response payload to %s: %s", uri, err)
+		}
+	}
+	return env
+}
+
+// spAuthHeader produces a single-use FIL-SPID-V1 header bound to the given request
+func (h *harness) spAuthHeader(method, uri string) string {
+	h.t.Helper()
+
+	h.authNonce++
+	arg := []byte(fmt.Sprintf("%s %s n%d", method, uri, h.authNonce))
+	epoch := fil.WallTimeEpoch(time.Now())
+
+	be, err := h.chain.StateGetBeaconEntry(h.ctx, epoch)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	sig, err := h.chain.WalletSign(h.ctx, h.spWorker, append(append([]byte{0x20, 0x20, 0x20}, be.Data...), arg...))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	return fmt.Sprintf(
+		"FIL-SPID-V1 %d;%s;%s;%s",
+		epoch,
+		h.spID,
+		base64.StdEncoding.EncodeToString(sig.Data),
+		base64.StdEncoding.EncodeToString(arg),
+	)
+}
+
+func (h *harness) mustExec(sql string, args ...interface{}) {
+	h.t.Helper()
+	if _, err := h.db.Exec(h.ctx, sql, args...); err != nil {
+		h.t.Fatalf("%s\n%s", err, sql)
+	}
+}
+
+func (h *harness) queryRow(sql string, args ...interface{}) pgx.Row {
+	return h.db.QueryRow(h.ctx, sql, args...)
+}
+
+//
+// Chain and database fixtures
+//
+
+// setupProvider defines the SP on chain, reachable at the fake SP peer
+func (h *harness) setupProvider(sectorSize filabi.SectorSize) {
+	h.t.Helper()
+
+	worker, _ := filaddr.NewIDAddress(uint64(h.spID) + 1)
+	var err error
+	if h.spWorker, err = h.chain.NewWalletKey(filcrypto.SigTypeSecp256k1); err != nil {
+		h.t.Fatal(err)
+	}
+	h.chain.SetAccountKey(worker, h.spWorker)
+
+	pid := h.sp.host.ID()
+	maddrs := make([]filabi.Multiaddrs, 0, len(h.sp.host.Addrs()))
+	for _, a := range h.sp.host.Addrs() {
+		maddrs = append(maddrs, a.Bytes())
+	}
+	h.chain.SetMiner(
+		h.spID.AsFilAddr(),
+		lotusapi.MinerInfo{
+			Owner:      worker,
+			Worker:     worker,
+			PeerId:     &pid,
+			Multiaddrs: maddrs,
+			SectorSize: sectorSize,
+		},
+		true,
+	)
+}
+
+// setupClient defines the tenant client on chain, holding the given datacap
+func (h *harness) setupClient(datacap int64) {
+	h.t.Helper()
+
+	var err error
+	if h.clientKey, err = h.chain.NewWalletKey(filcrypto.SigTypeSecp256k1); err != nil {
+		h.t.Fatal(err)
+	}
+	h.chain.SetAccountKey(h.clientID.AsFilAddr(), h.clientKey)
+	h.chain.SetDatacap(h.clientID.AsFilAddr(), filabi.NewStoragePower(datacap))
+}
+
+// testPieceCid returns a well-formed, deterministic PieceCID
+func testPieceCid(t *testing.T, seed string) cid.Cid {
+	digest := sha256.Sum256([]byte(seed))
+	digest[31] &= 0x3f // fr32 padding
+	mh, err := multihash.Encode(digest[:], multihash.SHA2_256_TRUNC254_PADDED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.FilCommitmentUnsealed, mh)
+}
+
+// testPayloadCid returns a deterministic v1 CID to use as a proposal label
+func testPayloadCid(t *testing.T, seed string) cid.Cid {
+	mh, err := multihash.Sum([]byte(seed), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+//
+// Fake storage provider
+//
+
+// fakeSP is a libp2p peer accepting every deal proposal, and advertising an HTTP retrieval transport
+type fakeSP struct {
+	host lp2phost.Host
+
+	mu             sync.Mutex
+	proposals      []filtypes.StorageProposalV12xParams
+	statusRequests []filtypes.DealStatusV120Request
+}
+
+var fakeSPRetrievalAddr = multiaddr.StringCast("/ip4/127.0.0.1/tcp/80/http")
+
+func startFakeSP(t *testing.T) *fakeSP {
+	// must be dialable by lp2p.NewPlainNodeTCP: TCP + TLS only
+	h, err := lp2p.New(
+		lp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		lp2p.NoTransports,
+		lp2p.Transport(lp2ptcp.NewTCPTransport),
+		lp2p.Security(lp2ptls.ID, lp2ptls.New),
+		lp2p.DisableRelay(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() }) //nolint:errcheck
+
+	sp := &fakeSP{host: h}
+	h.SetStreamHandler(filtypes.StorageProposalV120, sp.handleProposal)
+	h.SetStreamHandler(filtypes.RetrievalTransports, sp.handleTransports)
+	h.SetStreamHandler(filtypes.DealStatusV120, sp.handleDealStatus)
+	return sp
+}
+
+func (sp *fakeSP) handleProposal(st lp2pnet.Stream) {
+	defer st.Close() //nolint:errcheck
+
+	var p filtypes.StorageProposalV12xParams
+	if err := cborutil.ReadCborRPC(st, &p); err != nil {
+		st.Reset() //nolint:errcheck
+		return
+	}
+
+	sp.mu.Lock()
+	sp.proposals = append(sp.proposals, p)
+	sp.mu.Unlock()
+
+	cborutil.WriteCborRPC(st, &filtypes.StorageProposalV120Response{Accepted: true}) //nolint:errcheck
+}
+
+// every delivered deal is reported as an accepted offline deal awaiting data
+func (sp *fakeSP) handleDealStatus(st lp2pnet.Stream) {
+	defer st.Close() //nolint:errcheck
+
+	var req filtypes.DealStatusV120Request
+	if err := cborutil.ReadCborRPC(st, &req); err != nil {
+		st.Reset() //nolint:errcheck
+		return
+	}
+
+	sp.mu.Lock()
+	sp.statusRequests = append(sp.statusRequests, req)
+	var cdp *filmarket.ClientDealProposal
+	for i := range sp.proposals {
+		if sp.proposals[i].DealUUID == req.DealUUID {
+			cdp = &sp.proposals[i].ClientDealProposal
+		}
+	}
+	sp.mu.Unlock()
+
+	resp := filtypes.DealStatusV120Response{DealUUID: req.DealUUID}
+	if cdp == nil {
+		resp.Error = "deal not found"
+	} else if nd, err := cborutil.AsIpld(cdp); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.IsOffline = true
+		resp.DealStatus = &filtypes.DealStatusV120State{
+			Status:            "Accepted",
+			Proposal:          cdp.Proposal,
+			SignedProposalCid: nd.Cid(),
+		}
+	}
+
+	cborutil.WriteCborRPC(st, &resp) //nolint:errcheck
+}
+
+func (sp *fakeSP) handleTransports(st lp2pnet.Stream) {
+	defer st.Close() //nolint:errcheck
+
+	var resp filtypes.RetrievalTransports100RawResponse
+	resp.Protocols = append(resp.Protocols, struct {
+		Name      string
+		Addresses [][]byte
+	}{
+		Name:      "http",
+		Addresses: [][]byte{fakeSPRetrievalAddr.Bytes()},
+	})
+
+	cbor.NewEncoder(st).Encode(&resp) //nolint:errcheck
+}
+
+// received returns all deal proposals delivered to the SP so far
+func (sp *fakeSP) received() []filtypes.StorageProposalV12xParams {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return append([]filtypes.StorageProposalV12xParams(nil), sp.proposals...)
+}
+
+func (sp *fakeSP) statusQueried() []filtypes.DealStatusV120Request {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return append([]filtypes.DealStatusV120Request(nil), sp.statusRequests...)
+}
+
+//
+// Process management
+//
+
+// run executes a command to completion, failing the test on a non-zero exit
+func run(t *testing.T, cmd *exec.Cmd) string {
+	t.Helper()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s failed: %s\n%s", strings.Join(cmd.Args, " "), err, out)
+	}
+	return string(out)
+}
+
+func buildBinaries(t *testing.T, dir string) string {
+	binDir := filepath.Join(dir, "bin")
+	for _, c := range []string{"webapi", "cron"} {
+		cmd := exec.Command("go", "build", "-o", filepath.Join(binDir, app.AppName+"-"+c), "./"+c)
+		cmd.Dir = repoRoot
+		run(t, cmd)
+	}
+	return binDir
+}
+
+func freeLocalAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() //nolint:errcheck
+	return l.Addr().String()
+}
+
+// startPostgres initializes and starts a private PostgreSQL instance listening only on a
+// unix socket within dir, applies the spade schema to it, and returns its connection string
+func startPostgres(t *testing.T, dir string) string {
+	binDir := postgresBinDir(t)
+	dataDir := filepath.Join(dir, "pgdata")
+
+	run(t, exec.Command(
+		filepath.Join(binDir, "initdb"),
+		"--no-sync", "-A", "trust", "-E", "UTF8", "-U", "spade", "-D", dataDir,
+	))
+	run(t, exec.Command(
+		filepath.Join(binDir, "pg_ctl"),
+		"start", "-w",
+		"-D", dataDir,
+		"-l", filepath.Join(dir, "postgres.log"),
+		"-o", fmt.Sprintf("-c listen_addresses='' -c fsync=off -k %s", dir),
+	))
+	t.Cleanup(func() {
+		exec.Command(filepath.Join(binDir, "pg_ctl"), "stop", "-m", "immediate", "-D", dataDir).Run() //nolint:errcheck
+	})
+
+	run(t, exec.Command(
+		filepath.Join(binDir, "psql"),
+		"-X", "-q", "-v", "ON_ERROR_STOP=1",
+		"-h", dir, "-U", "spade", "-d", "postgres",
+		"-f", filepath.Join(repoRoot, "misc", "pg_schema.sql"),
+	))
+
+	return fmt.Sprintf("postgres:///postgres?user=spade&host=%s", dir)
+}
+
+func postgresBinDir(t *testing.T) string {
+	if os.Geteuid() == 0 {
+		t.Skip("initdb refuses to run as root")
+	}
+	if p, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(p)
+	}
+	if out, err := exec.Command("pg_config", "--bindir").Output(); err == nil {
+		d := strings.TrimSpace(string(out))
+		if _, err := os.Stat(filepath.Join(d, "initdb")); err == nil {
+			return d
+		}
+	}
+	t.Skip("PostgreSQL server binaries ( initdb, pg_ctl, psql ) not found in $PATH or via pg_config")
+	return ""
+}
@@ -0,0 +1,396 @@
+//go:build integration
+
+package itest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/filtypes"
+)
+
+const (
+	testTenantID  = 1
+	testDatasetID = 1
+	testDealID    = 4242
+)
+
+// TestDealPipeline walks a single piece through the entire lifecycle:
+// eligible -> requested -> signed -> delivered -> published -> active
+func TestDealPipeline(t *testing.T) {
+	h := newHarness(t)
+
+	h.setupProvider(1 << 35)
+	h.setupClient(1 << 40)
+
+	pCid := testPieceCid(t, "itest piece")
+	h.seedTenant(pCid)
+
+	//
+	// market state + provider info
+	//
+	h.cron("track-deals")
+	h.cron("poll-providers")
+
+	var hasMk120, hasHTTP bool
+	if err := h.queryRow(
+		`
+		SELECT
+				COALESCE( info->'peer_info'->'libp2p_protocols' ? $2, false ),
+				COALESCE( info->'retrieval_protocols' ? 'http', false )
+			FROM spd.providers_info
+		WHERE provider_id = $1
+		`,
+		h.spID,
+		filtypes.StorageProposalV120,
+	).Scan(&hasMk120, &hasHTTP); err != nil {
+		t.Fatalf("provider info not recorded: %s", err)
+	}
+	if !hasMk120 || !hasHTTP {
+		t.Fatalf("provider info incomplete: %s support %t, http retrieval %t", filtypes.StorageProposalV120, hasMk120, hasHTTP)
+	}
+
+	//
+	// the SP discovers and reserves the piece
+	//
+	h.startWebapi()
+
+	if !h.eligibleContains(pCid.String()) {
+		t.Fatalf("piece %s is not listed as eligible", pCid)
+	}
+
+	var reservation apitypes.ResponseDealRequest
+	if env := h.spGet("/sp/request_piece/"+pCid.String(), &reservation); env.ResponseCode != http.StatusOK {
+		t.Fatalf("unexpected response to piece request: %d %v", env.ResponseCode, env.ErrLines)
+	}
+	if reservation.DealStartEpoch == nil {
+		t.Fatal("reservation lacks a deal start epoch")
+	}
+	if len(reservation.ReplicationStates) != 1 || reservation.ReplicationStates[0].Total != 1 {
+		t.Fatalf("unexpected replication state after reservation: %+v", reservation.ReplicationStates)
+	}
+
+	var proposalUUID string
+	if err := h.queryRow(
+		`SELECT proposal_uuid::TEXT FROM spd.proposals WHERE provider_id = $1 AND proposal_failstamp = 0`,
+		h.spID,
+	).Scan(&proposalUUID); err != nil {
+		t.Fatalf("reservation did not result in a proposal: %s", err)
+	}
+
+	//
+	// sign
+	//
+	h.cron("sign-pending")
+
+	var signedCid *string
+	if err := h.queryRow(
+		`SELECT proposal_meta->>'signed_proposal_cid' FROM spd.proposals WHERE proposal_uuid = $1 AND signature_obtained IS NOT NULL`,
+		proposalUUID,
+	).Scan(&signedCid); err != nil {
+		t.Fatalf("proposal was not signed: %s", err)
+	}
+	if signedCid == nil {
+		t.Fatal("signed proposal lacks a CID")
+	}
+
+	//
+	// deliver
+	//
+	h.cron("propose-pending", "--sleep-between-proposals=0")
+
+	received := h.sp.received()
+	if len(received) != 1 {
+		t.Fatalf("expected exactly 1 proposal delivered to the SP, got %d", len(received))
+	}
+	delivered := received[0]
+	if delivered.DealUUID.String() != proposalUUID {
+		t.Errorf("delivered deal UUID %s does not match proposal %s", delivered.DealUUID, proposalUUID)
+	}
+	if !delivered.IsOffline {
+		t.Error("delivered proposal is not marked offline")
+	}
+	prop := delivered.ClientDealProposal.Proposal
+	if !prop.PieceCID.Equals(pCid) {
+		t.Errorf("delivered PieceCID %s does not match %s", prop.PieceCID, pCid)
+	}
+	if prop.Provider != h.spID.AsFilAddr() || prop.Client != h.clientID.AsFilAddr() {
+		t.Errorf("delivered proposal between unexpected parties: client %s provider %s", prop.Client, prop.Provider)
+	}
+	rawProp, err := cborutil.Dump(&prop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSig := delivered.ClientDealProposal.ClientSignature
+	if err := sigs.Verify(&clientSig, h.clientKey, rawProp); err != nil {
+		t.Errorf("delivered proposal signature does not verify: %s", err)
+	}
+
+	var deliveredAt *time.Time
+	if err := h.queryRow(
+		`SELECT proposal_delivered FROM spd.proposals WHERE proposal_uuid = $1`,
+		proposalUUID,
+	).Scan(&deliveredAt); err != nil {
+		t.Fatal(err)
+	}
+	if deliveredAt == nil {
+		t.Fatal("proposal not marked as delivered")
+	}
+
+	//
+	// ask the SP how the deal is doing
+	//
+	h.cron("poll-deal-status")
+
+	statusReqs := h.sp.statusQueried()
+	if len(statusReqs) != 1 || statusReqs[0].DealUUID.String() != proposalUUID {
+		t.Fatalf("expected exactly 1 status query for %s, got %+v", proposalUUID, statusReqs)
+	}
+	uuidBytes, _ := statusReqs[0].DealUUID.MarshalBinary()
+	if err := sigs.Verify(&statusReqs[0].Signature, h.clientKey, uuidBytes); err != nil {
+		t.Errorf("status query signature does not verify: %s", err)
+	}
+
+	pending := h.pendingProposals()
+	if len(pending) != 1 || pending[0].ProposalID != proposalUUID {
+		t.Fatalf("unexpected pending proposals: %+v", pending)
+	}
+	if ds := pending[0].DealStatus; ds == nil || ds.Checkpoint != "Accepted" || !ds.AwaitingData {
+		t.Errorf("unexpected SP-reported deal status: %+v", ds)
+	}
+
+	//
+	// the SP publishes the deal...
+	//
+	h.chain.SetDeal(testDealID, lotusapi.MarketDeal{
+		Proposal: prop,
+		State: filmarket.DealState{
+			SectorStartEpoch: -1,
+			LastUpdatedEpoch: -1,
+			SlashEpoch:       -1,
+		},
+	})
+	h.cron("track-deals")
+
+	if status, activatedID := h.dealState(proposalUUID); status != "published" || activatedID != nil {
+		t.Fatalf("unexpected state after publishing: deal status %q, activated deal %v", status, activatedID)
+	}
+
+	//
+	// ...and activates it
+	//
+	h.chain.SetDeal(testDealID, lotusapi.MarketDeal{
+		Proposal: prop,
+		State: filmarket.DealState{
+			SectorStartEpoch: fil.WallTimeEpoch(time.Now()) - 10,
+			LastUpdatedEpoch: -1,
+			SlashEpoch:       -1,
+		},
+	})
+	h.cron("track-deals")
+
+	if status, activatedID := h.dealState(proposalUUID); status != "active" || activatedID == nil || *activatedID != testDealID {
+		t.Fatalf("unexpected state after activation: deal status %q, activated deal %v", status, activatedID)
+	}
+
+	var sizeProven bool
+	if err := h.queryRow(
+		`SELECT COALESCE( (piece_meta->'size_proven_correct')::BOOL, false ) FROM spd.pieces WHERE piece_cid = $1`,
+		pCid.String(),
+	).Scan(&sizeProven); err != nil {
+		t.Fatal(err)
+	}
+	if !sizeProven {
+		t.Error("activated piece not marked as size_proven_correct")
+	}
+
+	//
+	// the API reflects the replica
+	//
+	var reRequest apitypes.ResponseDealRequest
+	env := h.spGet("/sp/request_piece/"+pCid.String(), &reRequest)
+	if env.ResponseCode != http.StatusForbidden || env.ErrCode != int(apitypes.ErrProviderHasReplica) {
+		t.Fatalf("unexpected response to repeated piece request: %d/%d %v", env.ResponseCode, env.ErrCode, env.ErrLines)
+	}
+	if len(reRequest.ReplicationStates) != 1 || reRequest.ReplicationStates[0].Total != 1 {
+		t.Errorf("unexpected replication state after activation: %+v", reRequest.ReplicationStates)
+	}
+
+	if pending := h.pendingProposals(); len(pending) != 0 {
+		t.Errorf("activated proposal still listed as pending: %+v", pending)
+	}
+
+	if h.eligibleContains(pCid.String()) {
+		t.Error("piece still listed as eligible after activation")
+	}
+
+	//
+	// everything above was picked up by diffing consecutive market states: a full resync agrees
+	//
+	var trackedHead *string
+	if err := h.queryRow(`SELECT metadata->'market_state'->'actor_head'->>'/' FROM spd.global`).Scan(&trackedHead); err != nil {
+		t.Fatal(err)
+	}
+	if trackedHead == nil {
+		t.Fatal("processed market state not recorded")
+	}
+
+	h.cron("track-deals", "--full-resync")
+	if status, activatedID := h.dealState(proposalUUID); status != "active" || activatedID == nil || *activatedID != testDealID {
+		t.Fatalf("unexpected state after full resync: deal status %q, activated deal %v", status, activatedID)
+	}
+
+	// with nothing changed since, an incremental run leaves the matviews alone
+	matviewsRefreshed := func() (ts int64) {
+		if err := h.queryRow(`SELECT ( metadata->'matviews_refreshed' )::BIGINT FROM spd.global`).Scan(&ts); err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	refreshedBefore := matviewsRefreshed()
+	h.cron("track-deals")
+	if refreshedAfter := matviewsRefreshed(); refreshedAfter != refreshedBefore {
+		t.Errorf("materialized views refreshed despite no deal changes: %d != %d", refreshedAfter, refreshedBefore)
+	}
+
+	//
+	// the deal disappears from the market state
+	//
+	h.chain.RemoveDeal(testDealID)
+	h.cron("track-deals")
+
+	if status, activatedID := h.dealState(proposalUUID); status != "terminated" || activatedID != nil {
+		t.Fatalf("unexpected state after removal: deal status %q, activated deal %v", status, activatedID)
+	}
+}
+
+// seedTenant registers a tenant with a single dataset containing the given piece, and makes
+// the test SP and client known to it
+func (h *harness) seedTenant(pCid fmt.Stringer) {
+	h.t.Helper()
+
+	h.mustExec(
+		`INSERT INTO spd.tenants ( tenant_id, tenant_name, tenant_meta ) VALUES ( $1, 'itest', $2 )`,
+		testTenantID,
+		`{
+			"deal_params": { "duration_days": 532, "start_within_hours": 72 },
+			"max": { "total_replicas": 10, "per_org": 2, "per_city": 3, "per_country": 5, "per_continent": 7 }
+		}`,
+	)
+	h.mustExec(
+		`INSERT INTO spd.clients ( client_id, tenant_id, client_address ) VALUES ( $1, $2, $3 )`,
+		h.clientID,
+		testTenantID,
+		h.clientKey.String(),
+	)
+	h.mustExec(
+		`INSERT INTO spd.datasets ( dataset_id, dataset_slug ) VALUES ( $1, 'itest' )`,
+		testDatasetID,
+	)
+	h.mustExec(
+		`INSERT INTO spd.tenants_datasets ( tenant_id, dataset_id ) VALUES ( $1, $2 )`,
+		testTenantID,
+		testDatasetID,
+	)
+
+	var pieceID int64
+	if err := h.queryRow(
+		`INSERT INTO spd.pieces ( piece_cid, piece_log2_size, proposal_label ) VALUES ( $1, 35, $2 ) RETURNING piece_id`,
+		pCid.String(),
+		testPayloadCid(h.t, "itest payload").String(),
+	).Scan(&pieceID); err != nil {
+		h.t.Fatal(err)
+	}
+	h.mustExec(
+		`INSERT INTO spd.datasets_pieces ( piece_id, dataset_id ) VALUES ( $1, $2 )`,
+		pieceID,
+		testDatasetID,
+	)
+	h.mustExec(
+		`INSERT INTO spd.datasets_pieces_http_sources ( piece_id, dataset_id, source_url ) VALUES ( $1, $2, $3 )`,
+		pieceID,
+		testDatasetID,
+		"https://itest.example/"+pCid.String()+".car",
+	)
+
+	h.mustExec(
+		`INSERT INTO spd.providers ( provider_id, org_id, city_id, country_id, continent_id ) VALUES ( $1, 1, 1, 1, 1 )`,
+		h.spID,
+	)
+	h.mustExec(
+		`INSERT INTO spd.tenants_providers ( tenant_id, provider_id ) VALUES ( $1, $2 )`,
+		testTenantID,
+		h.spID,
+	)
+}
+
+func (h *harness) eligibleContains(pCid string) bool {
+	h.t.Helper()
+
+	var eligible []struct {
+		PieceCid string `json:"piece_cid"`
+	}
+	if env := h.spGet("/sp/eligible_pieces", &eligible); env.ResponseCode != http.StatusOK {
+		h.t.Fatalf("unexpected response listing eligible pieces: %d %v", env.ResponseCode, env.ErrLines)
+	}
+	for _, p := range eligible {
+		if p.PieceCid == pCid {
+			return true
+		}
+	}
+	return false
+}
+
+type pendingProposal struct {
+	ProposalID   string `json:"deal_proposal_id"`
+	PieceCid     string `json:"piece_cid"`
+	ImportCmd    string `json:"sample_import_cmd"`
+	AllocationID *int64 `json:"allocation_id"`
+	DealStatus   *struct {
+		Checkpoint   string `json:"checkpoint"`
+		AwaitingData bool   `json:"awaiting_offline_data"`
+	} `json:"deal_status"`
+}
+
+func (h *harness) pendingProposals() []pendingProposal {
+	h.t.Helper()
+
+	var resp struct {
+		PendingProposals []pendingProposal `json:"pending_proposals"`
+		RecentFailures   []json.RawMessage `json:"recent_failures"`
+	}
+	if env := h.spGet("/sp/pending_proposals", &resp); env.ResponseCode != http.StatusOK {
+		h.t.Fatalf("unexpected response listing pending proposals: %d %v", env.ResponseCode, env.ErrLines)
+	}
+	if len(resp.RecentFailures) > 0 {
+		h.t.Errorf("unexpected proposal failures: %s", resp.RecentFailures)
+	}
+	return resp.PendingProposals
+}
+
+// dealState returns the tracked status of the test deal, and the deal recorded as activating the proposal
+func (h *harness) dealState(proposalUUID string) (status string, activatedDealID *int64) {
+	h.t.Helper()
+
+	if err := h.queryRow(
+		`
+		SELECT
+				COALESCE( ( SELECT status FROM spd.published_deals WHERE deal_id = $1 ), '' ),
+				( SELECT activated_deal_id FROM spd.proposals WHERE proposal_uuid = $2 )
+		`,
+		testDealID,
+		proposalUUID,
+	).Scan(&status, &activatedDealID); err != nil {
+		h.t.Fatal(err)
+	}
+	return status, activatedDealID
+}
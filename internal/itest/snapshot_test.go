@@ -0,0 +1,211 @@
+//go:build integration
+
+package itest
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+// TestMarketDealsSnapshot bootstraps deal tracking from offline StateMarketDeals dumps, and
+// hands over to the chain node afterwards
+func TestMarketDealsSnapshot(t *testing.T) {
+	h := newHarness(t)
+
+	h.setupProvider(1 << 35)
+	h.setupClient(1 << 40)
+
+	pCid := testPieceCid(t, "itest snapshot piece")
+	h.seedTenant(pCid)
+
+	now := fil.WallTimeEpoch(time.Now())
+	label, err := filmarket.NewLabelFromString(testPayloadCid(t, "itest payload").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := filmarket.DealProposal{
+		PieceCID:             pCid,
+		PieceSize:            1 << 35,
+		VerifiedDeal:         true,
+		Client:               h.clientID.AsFilAddr(),
+		Provider:             h.spID.AsFilAddr(),
+		Label:                label,
+		StartEpoch:           now + filbuiltin.EpochsInDay,
+		EndEpoch:             now + 400*filbuiltin.EpochsInDay,
+		StoragePricePerEpoch: filbig.Zero(),
+		ProviderCollateral:   filbig.Zero(),
+		ClientCollateral:     filbig.Zero(),
+	}
+	published := filmarket.DealState{SectorStartEpoch: -1, LastUpdatedEpoch: -1, SlashEpoch: -1}
+	active := filmarket.DealState{SectorStartEpoch: now - 10, LastUpdatedEpoch: -1, SlashEpoch: -1}
+
+	// a deal of someone else entirely
+	otherClient, _ := filaddr.NewIDAddress(98765)
+	otherProp := prop
+	otherProp.Client = otherClient
+	otherProp.PieceCID = testPieceCid(t, "itest unrelated piece")
+	const otherDealID = testDealID + 1
+
+	dir := t.TempDir()
+
+	//
+	// plain JSON
+	//
+	plain := filepath.Join(dir, "StateMarketDeals.json")
+	writeMarketDealsSnapshot(t, plain, false, map[int64]lotusapi.MarketDeal{
+		testDealID:  {Proposal: prop, State: published},
+		otherDealID: {Proposal: otherProp, State: published},
+	})
+	h.cron("track-deals", "--market-deals-snapshot="+plain, "--snapshot-epoch="+strconv.FormatInt(int64(now-10), 10))
+
+	if status := h.snapshotDealStatus(testDealID); status != "published" {
+		t.Fatalf("unexpected status of snapshot deal: %q", status)
+	}
+	if status := h.snapshotDealStatus(otherDealID); status != "published" {
+		t.Fatalf("unexpected status of unrelated snapshot deal: %q", status)
+	}
+
+	var activatableDatacap int64
+	if err := h.queryRow(
+		`SELECT ( client_meta->'activatable_datacap' )::BIGINT FROM spd.clients WHERE client_id = $1`,
+		h.clientID,
+	).Scan(&activatableDatacap); err != nil {
+		t.Fatal(err)
+	}
+	if activatableDatacap != 1<<40+1<<35 {
+		t.Errorf("published fil+ deal not accounted for in activatable datacap: %d", activatableDatacap)
+	}
+
+	var hasActorHead bool
+	if err := h.queryRow(`SELECT metadata->'market_state' ? 'actor_head' FROM spd.global`).Scan(&hasActorHead); err != nil {
+		t.Fatal(err)
+	}
+	if hasActorHead {
+		t.Error("snapshot import recorded a market state to diff against")
+	}
+
+	//
+	// zstd-compressed, replayed as of a specific epoch
+	//
+	compressed := filepath.Join(dir, "StateMarketDeals.json.zst")
+	writeMarketDealsSnapshot(t, compressed, true, map[int64]lotusapi.MarketDeal{
+		testDealID: {Proposal: prop, State: active},
+	})
+	h.cron("track-deals", "--market-deals-snapshot="+compressed, "--snapshot-epoch="+strconv.FormatInt(int64(now-5), 10))
+
+	if status := h.snapshotDealStatus(testDealID); status != "active" {
+		t.Fatalf("unexpected status of snapshot deal after replay: %q", status)
+	}
+	if status := h.snapshotDealStatus(otherDealID); status != "published" {
+		t.Fatalf("deal missing from snapshot not left alone: %q", status)
+	}
+
+	var stateEpoch int64
+	if err := h.queryRow(`SELECT ( metadata->'market_state'->'epoch' )::BIGINT FROM spd.global`).Scan(&stateEpoch); err != nil {
+		t.Fatal(err)
+	}
+	if stateEpoch != int64(now-5) {
+		t.Errorf("recorded market state epoch %d does not match snapshot epoch %d", stateEpoch, now-5)
+	}
+
+	// neither an epoch-less nor an older snapshot is acceptable
+	for _, args := range [][]string{
+		{"--market-deals-snapshot=" + plain},
+		{"--market-deals-snapshot=" + plain, "--snapshot-epoch=" + strconv.FormatInt(int64(now-10), 10)},
+	} {
+		cmd := exec.Command(filepath.Join(h.binDir, app.AppName+"-cron"), append([]string{"track-deals"}, args...)...)
+		cmd.Env = h.env()
+		if out, err := cmd.CombinedOutput(); err == nil {
+			t.Errorf("track-deals %v unexpectedly succeeded:\n%s", args, out)
+		}
+	}
+	if status := h.snapshotDealStatus(testDealID); status != "active" {
+		t.Fatalf("refused snapshot altered deal status: %q", status)
+	}
+
+	//
+	// the chain node takes over
+	//
+	h.chain.SetDeal(testDealID, lotusapi.MarketDeal{Proposal: prop, State: active})
+	h.cron("track-deals")
+
+	if status := h.snapshotDealStatus(testDealID); status != "active" {
+		t.Fatalf("unexpected status of snapshot deal after chain resync: %q", status)
+	}
+	if status := h.snapshotDealStatus(otherDealID); status != "terminated" {
+		t.Fatalf("deal missing from the chain not terminated: %q", status)
+	}
+	if err := h.queryRow(`SELECT metadata->'market_state' ? 'actor_head' FROM spd.global`).Scan(&hasActorHead); err != nil {
+		t.Fatal(err)
+	}
+	if !hasActorHead {
+		t.Error("market state to diff against not recorded after resync from chain")
+	}
+}
+
+// snapshotDealStatus returns the tracked status of a deal, empty if not tracked
+func (h *harness) snapshotDealStatus(dealID int64) string {
+	h.t.Helper()
+
+	var status string
+	if err := h.queryRow(
+		`SELECT COALESCE( ( SELECT status FROM spd.published_deals WHERE deal_id = $1 ), '' )`,
+		dealID,
+	).Scan(&status); err != nil {
+		h.t.Fatal(err)
+	}
+	return status
+}
+
+// writeMarketDealsSnapshot writes deals in the same format as lotus' StateMarketDeals
+func writeMarketDealsSnapshot(t *testing.T, path string, compress bool, deals map[int64]lotusapi.MarketDeal) {
+	t.Helper()
+
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	var w io.Writer = fh
+	var zw *zstd.Encoder
+	if compress {
+		if zw, err = zstd.NewWriter(fh); err != nil {
+			t.Fatal(err)
+		}
+		w = zw
+	}
+
+	sd := make(map[string]*lotusapi.MarketDeal, len(deals))
+	for id := range deals {
+		d := deals[id]
+		sd[strconv.FormatInt(id, 10)] = &d
+	}
+	if err := json.NewEncoder(w).Encode(sd); err != nil {
+		t.Fatal(err)
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
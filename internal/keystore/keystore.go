@@ -0,0 +1,331 @@
+// Package keystore implements a directory of passphrase-encrypted Filecoin wallet keys,
+// and in-process signing with them. It allows tenant client keys to be held by the
+// spade signing process itself, instead of by the wallet of a shared lotus node.
+//
+// Every key is a separate <address>.key file: a JSON envelope carrying the lotus
+// KeyInfo ( as produced by `lotus wallet export` ) sealed with XChaCha20-Poly1305,
+// under a key derived from the passphrase via scrypt.
+package keystore
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp" // register secp256k1 with lib/sigs
+	blst "github.com/supranational/blst/bindings/go"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	fileSuffix    = ".key"
+	formatVersion = 1
+	cipherName    = "xchacha20-poly1305"
+)
+
+// same as geth's "standard" scrypt settings: ~256MiB and ~1s per key
+// paid once per key per process, as decrypted keys are retained
+var scryptN, scryptR, scryptP = 1 << 18, 8, 1
+
+// same as in webapi/utilSigVerify.go
+var blsDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+
+// ErrKeyNotFound is returned when the keystore does not hold a key for the requested address
+var ErrKeyNotFound = errors.New("key not found in keystore")
+
+// Keystore is an open keystore directory. It is safe for concurrent use.
+type Keystore struct {
+	dir        string
+	passphrase []byte
+
+	mu   sync.Mutex
+	keys map[filaddr.Address]*key
+}
+
+type key struct {
+	sigType filcrypto.SigType
+	secp    []byte
+	bls     *blst.SecretKey
+}
+
+type envelope struct {
+	Version    int       `json:"version"`
+	Address    string    `json:"address"`
+	KDF        kdfParams `json:"kdf"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+type kdfParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// Open returns the keystore within dir. The directory must exist and must not be
+// accessible by anyone but its owner.
+func Open(dir, passphrase string) (*Keystore, error) {
+	if passphrase == "" {
+		return nil, xerrors.New("a keystore passphrase is required")
+	}
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to open keystore: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, xerrors.Errorf("keystore location %s is not a directory", dir)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, xerrors.Errorf("keystore directory %s has permissions %s: must not be accessible by group or others", dir, fi.Mode().Perm())
+	}
+
+	return &Keystore{
+		dir:        dir,
+		passphrase: []byte(passphrase),
+		keys:       make(map[filaddr.Address]*key),
+	}, nil
+}
+
+// Import encrypts and stores a key, returning its address. An already present key is not overwritten.
+func (ks *Keystore) Import(ki lotustypes.KeyInfo) (filaddr.Address, error) {
+	k, addr, err := parseKeyInfo(ki)
+	if err != nil {
+		return filaddr.Undef, err
+	}
+
+	plain, err := json.Marshal(ki)
+	if err != nil {
+		return filaddr.Undef, xerrors.Errorf("unable to serialize key: %w", err)
+	}
+
+	env := envelope{
+		Version: formatVersion,
+		Address: addr.String(),
+		KDF: kdfParams{
+			Name: "scrypt",
+			Salt: make([]byte, 32),
+			N:    scryptN,
+			R:    scryptR,
+			P:    scryptP,
+		},
+		Cipher: cipherName,
+		Nonce:  make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(env.KDF.Salt); err != nil {
+		return filaddr.Undef, err
+	}
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return filaddr.Undef, err
+	}
+
+	aead, err := ks.aead(env.KDF)
+	if err != nil {
+		return filaddr.Undef, err
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plain, []byte(env.Address))
+
+	encoded, err := json.Marshal(env)
+	if err != nil {
+		return filaddr.Undef, err
+	}
+
+	fh, err := os.OpenFile(ks.path(addr), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return filaddr.Undef, xerrors.Errorf("keystore already contains a key for %s", addr)
+		}
+		return filaddr.Undef, xerrors.Errorf("unable to store key: %w", err)
+	}
+	if _, err := fh.Write(encoded); err != nil {
+		fh.Close()               //nolint:errcheck
+		os.Remove(ks.path(addr)) //nolint:errcheck
+		return filaddr.Undef, xerrors.Errorf("unable to store key: %w", err)
+	}
+	if err := fh.Close(); err != nil {
+		return filaddr.Undef, xerrors.Errorf("unable to store key: %w", err)
+	}
+
+	ks.mu.Lock()
+	ks.keys[addr] = k
+	ks.mu.Unlock()
+
+	return addr, nil
+}
+
+// ImportExported imports a key in the hex-encoded form produced by `lotus wallet export`
+func (ks *Keystore) ImportExported(exported string) (filaddr.Address, error) {
+	kiJSON, err := hex.DecodeString(strings.TrimSpace(exported))
+	if err != nil {
+		return filaddr.Undef, xerrors.Errorf("exported key is not valid hex: %w", err)
+	}
+	var ki lotustypes.KeyInfo
+	if err := json.Unmarshal(kiJSON, &ki); err != nil {
+		return filaddr.Undef, xerrors.Errorf("exported key is not a valid KeyInfo: %w", err)
+	}
+	return ks.Import(ki)
+}
+
+// List returns the addresses of all keys within the keystore
+func (ks *Keystore) List() ([]filaddr.Address, error) {
+	ents, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, xerrors.Errorf("unable to list keystore: %w", err)
+	}
+
+	ret := make([]filaddr.Address, 0, len(ents))
+	for _, e := range ents {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		a, err := filaddr.NewFromString(strings.TrimSuffix(e.Name(), fileSuffix))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].String() < ret[j].String() })
+	return ret, nil
+}
+
+// Sign signs msg with the key of the given secp256k1 or BLS address
+func (ks *Keystore) Sign(keyAddr filaddr.Address, msg []byte) (*filcrypto.Signature, error) {
+	k, err := ks.load(keyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if k.sigType == filcrypto.SigTypeBLS {
+		return &filcrypto.Signature{
+			Type: filcrypto.SigTypeBLS,
+			Data: new(blst.P2Affine).Sign(k.bls, msg, blsDST).Compress(),
+		}, nil
+	}
+	return sigs.Sign(filcrypto.SigTypeSecp256k1, k.secp, msg)
+}
+
+func (ks *Keystore) load(addr filaddr.Address) (*key, error) {
+	if addr.Protocol() != filaddr.SECP256K1 && addr.Protocol() != filaddr.BLS {
+		return nil, xerrors.Errorf("unable to sign with %s: not a key address", addr)
+	}
+
+	// holding the lock throughout: no point decrypting the same key concurrently
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if k, found := ks.keys[addr]; found {
+		return k, nil
+	}
+
+	encoded, err := os.ReadFile(ks.path(addr))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, xerrors.Errorf("%w: %s", ErrKeyNotFound, addr)
+		}
+		return nil, xerrors.Errorf("unable to read key for %s: %w", addr, err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(encoded, &env); err != nil {
+		return nil, xerrors.Errorf("malformed keystore entry for %s: %w", addr, err)
+	}
+	if env.Version != formatVersion {
+		return nil, xerrors.Errorf("keystore entry for %s has unsupported version %d", addr, env.Version)
+	}
+	if env.Address != addr.String() {
+		return nil, xerrors.Errorf("keystore entry for %s claims to be for %s", addr, env.Address)
+	}
+	if env.Cipher != cipherName {
+		return nil, xerrors.Errorf("keystore entry for %s uses unsupported cipher '%s'", addr, env.Cipher)
+	}
+
+	aead, err := ks.aead(env.KDF)
+	if err != nil {
+		return nil, xerrors.Errorf("keystore entry for %s: %w", addr, err)
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, xerrors.Errorf("keystore entry for %s has a nonce of unexpected length %d", addr, len(env.Nonce))
+	}
+	plain, err := aead.Open(nil, env.Nonce, env.Ciphertext, []byte(env.Address))
+	if err != nil {
+		return nil, xerrors.Errorf("unable to decrypt key for %s: wrong passphrase or corrupted entry", addr)
+	}
+
+	var ki lotustypes.KeyInfo
+	if err := json.Unmarshal(plain, &ki); err != nil {
+		return nil, xerrors.Errorf("malformed key for %s: %w", addr, err)
+	}
+	k, derivedAddr, err := parseKeyInfo(ki)
+	if err != nil {
+		return nil, xerrors.Errorf("malformed key for %s: %w", addr, err)
+	}
+	if derivedAddr != addr {
+		return nil, xerrors.Errorf("keystore entry for %s contains the key of %s", addr, derivedAddr)
+	}
+
+	ks.keys[addr] = k
+	return k, nil
+}
+
+func (ks *Keystore) aead(p kdfParams) (cipher.AEAD, error) {
+	if p.Name != "scrypt" {
+		return nil, xerrors.Errorf("unsupported key derivation function '%s'", p.Name)
+	}
+	dk, err := scrypt.Key(ks.passphrase, p.Salt, p.N, p.R, p.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, xerrors.Errorf("key derivation failed: %w", err)
+	}
+	return chacha20poly1305.NewX(dk)
+}
+
+func (ks *Keystore) path(addr filaddr.Address) string {
+	return filepath.Join(ks.dir, addr.String()+fileSuffix)
+}
+
+func parseKeyInfo(ki lotustypes.KeyInfo) (*key, filaddr.Address, error) {
+	switch ki.Type {
+
+	case lotustypes.KTSecp256k1:
+		pub, err := sigs.ToPublic(filcrypto.SigTypeSecp256k1, ki.PrivateKey)
+		if err != nil {
+			return nil, filaddr.Undef, xerrors.Errorf("invalid secp256k1 key: %w", err)
+		}
+		addr, err := filaddr.NewSecp256k1Address(pub)
+		if err != nil {
+			return nil, filaddr.Undef, err
+		}
+		return &key{sigType: filcrypto.SigTypeSecp256k1, secp: ki.PrivateKey}, addr, nil
+
+	case lotustypes.KTBLS:
+		// lotus serializes BLS private keys little-endian
+		sk := new(blst.SecretKey).FromLEndian(ki.PrivateKey)
+		if len(ki.PrivateKey) != blst.BLST_SCALAR_BYTES || sk == nil || !sk.Valid() {
+			return nil, filaddr.Undef, xerrors.New("invalid bls key")
+		}
+		addr, err := filaddr.NewBLSAddress(new(blst.P1Affine).From(sk).Compress())
+		if err != nil {
+			return nil, filaddr.Undef, err
+		}
+		return &key{sigType: filcrypto.SigTypeBLS, bls: sk}, addr, nil
+
+	default:
+		return nil, filaddr.Undef, xerrors.Errorf("unsupported key type '%s'", ki.Type)
+	}
+}
@@ -0,0 +1,147 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	blst "github.com/supranational/blst/bindings/go"
+)
+
+func init() {
+	// keep the tests fast
+	scryptN = 1 << 10
+}
+
+func TestSignRoundtrip(t *testing.T) {
+	dir := privateTempDir(t)
+	msg := []byte("proposal bytes")
+
+	ks, err := Open(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secpAddr, err := ks.Import(newKeyInfo(t, lotustypes.KTSecp256k1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blsAddr, err := ks.Import(newKeyInfo(t, lotustypes.KTBLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secpAddr.Protocol() != filaddr.SECP256K1 || blsAddr.Protocol() != filaddr.BLS {
+		t.Fatalf("unexpected address protocols: %s %s", secpAddr, blsAddr)
+	}
+
+	if l, err := ks.List(); err != nil || len(l) != 2 {
+		t.Fatalf("expected 2 listed keys, got %v ( %v )", l, err)
+	}
+
+	// a fresh instance has to decrypt the keys from disk
+	reopened, err := Open(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := reopened.Sign(secpAddr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sigs.Verify(sig, secpAddr, msg); err != nil {
+		t.Errorf("secp256k1 signature does not verify: %s", err)
+	}
+
+	sig, err = reopened.Sign(blsAddr, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Type != filcrypto.SigTypeBLS || !new(blst.P2Affine).VerifyCompressed(sig.Data, true, blsAddr.Payload(), true, msg, blsDST) {
+		t.Error("bls signature does not verify")
+	}
+
+	if _, err := ks.Import(newKeyInfo(t, lotustypes.KTSecp256k1)); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := filaddr.NewIDAddress(1234)
+	if _, err := reopened.Sign(id, msg); err == nil {
+		t.Error("signing with an ID address unexpectedly succeeded")
+	}
+	unknown, _ := filaddr.NewSecp256k1Address(make([]byte, 65))
+	if _, err := reopened.Sign(unknown, msg); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for an unknown key, got %v", err)
+	}
+}
+
+func TestRefusals(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, "correct horse"); err == nil {
+		t.Error("world-readable keystore directory unexpectedly accepted")
+	}
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, ""); err == nil {
+		t.Error("empty passphrase unexpectedly accepted")
+	}
+
+	ks, err := Open(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki := newKeyInfo(t, lotustypes.KTSecp256k1)
+	addr, err := ks.Import(ki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Import(ki); err == nil {
+		t.Error("repeated import unexpectedly succeeded")
+	}
+
+	wrongPass, err := Open(dir, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrongPass.Sign(addr, []byte("msg")); err == nil {
+		t.Error("signing with the wrong passphrase unexpectedly succeeded")
+	}
+
+	if _, err := ks.Import(lotustypes.KeyInfo{Type: lotustypes.KTBLS, PrivateKey: []byte{1, 2, 3}}); err == nil {
+		t.Error("malformed key unexpectedly imported")
+	}
+}
+
+// privateTempDir is a t.TempDir() which Open accepts regardless of the umask
+func privateTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newKeyInfo(t *testing.T, kt lotustypes.KeyType) lotustypes.KeyInfo {
+	if kt == lotustypes.KTBLS {
+		ikm := make([]byte, 32)
+		if _, err := rand.Read(ikm); err != nil {
+			t.Fatal(err)
+		}
+		return lotustypes.KeyInfo{Type: kt, PrivateKey: blst.KeyGen(ikm).ToLEndian()}
+	}
+
+	pk, err := sigs.Generate(filcrypto.SigTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lotustypes.KeyInfo{Type: kt, PrivateKey: pk}
+}
@@ -0,0 +1,73 @@
+package signsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/ribasushi/spade/internal/sigverify"
+	"golang.org/x/xerrors"
+)
+
+// Client calls a remote signing service. Every returned signature is verified before use.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client // optional
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Sign requests a signature of the serialized DealProposal msg by the key address signer
+func (c *Client) Sign(ctx context.Context, signer filaddr.Address, msg []byte) (*filcrypto.Signature, error) {
+	if signer.Protocol() != filaddr.SECP256K1 && signer.Protocol() != filaddr.BLS {
+		return nil, xerrors.Errorf("remote signing requires a key address, not %s", signer)
+	}
+
+	body, err := json.Marshal(SignRequest{Signer: signer.String(), Proposal: msg})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.BaseURL, "/")+SignPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = defaultHTTPClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("remote signer %s unreachable: %w", c.BaseURL, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	var sr SignResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRequestBytes)).Decode(&sr); err != nil {
+		return nil, xerrors.Errorf("remote signer %s returned an undecodable HTTP %d response: %w", c.BaseURL, resp.StatusCode, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		reason := sr.Error
+		if len(sr.PolicyViolations) > 0 {
+			reason = strings.Join(sr.PolicyViolations, "; ")
+		}
+		return nil, xerrors.Errorf("%w: %s", ErrRefused, reason)
+	case resp.StatusCode != http.StatusOK:
+		return nil, xerrors.Errorf("remote signer %s returned HTTP %d: %s", c.BaseURL, resp.StatusCode, sr.Error)
+	case !sigverify.Signature(sr.Signature, signer, msg):
+		return nil, xerrors.Errorf("remote signer %s returned an invalid signature for %s", c.BaseURL, signer)
+	}
+
+	return sr.Signature, nil
+}
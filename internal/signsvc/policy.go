@@ -0,0 +1,49 @@
+package signsvc
+
+import (
+	"fmt"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+)
+
+// Policy describes which proposals a signing service is willing to sign
+type Policy struct {
+	AllowPaid        bool                         // accept a non-zero price or client collateral
+	AllowUnverified  bool                         // accept non-Fil+ deals
+	AllowedProviders map[filaddr.Address]struct{} // ID addresses, empty allows any provider
+	MaxDuration      filabi.ChainEpoch            // maximum end-start span, 0 means no limit
+}
+
+// Check returns every way the proposal violates the policy, or nothing if it is acceptable
+func (p Policy) Check(dp filmarket.DealProposal) []string {
+	var violations []string
+
+	if !p.AllowPaid {
+		if !dp.StoragePricePerEpoch.NilOrZero() {
+			violations = append(violations, fmt.Sprintf("non-zero storage price of %s per epoch", dp.StoragePricePerEpoch))
+		}
+		if !dp.ClientCollateral.NilOrZero() {
+			violations = append(violations, fmt.Sprintf("non-zero client collateral of %s", dp.ClientCollateral))
+		}
+	}
+
+	if !p.AllowUnverified && !dp.VerifiedDeal {
+		violations = append(violations, "not a verified deal")
+	}
+
+	if len(p.AllowedProviders) > 0 {
+		if _, allowed := p.AllowedProviders[dp.Provider]; !allowed {
+			violations = append(violations, fmt.Sprintf("provider %s is not allowed", dp.Provider))
+		}
+	}
+
+	if dp.EndEpoch <= dp.StartEpoch {
+		violations = append(violations, fmt.Sprintf("end epoch %d is not after start epoch %d", dp.EndEpoch, dp.StartEpoch))
+	} else if p.MaxDuration > 0 && dp.EndEpoch-dp.StartEpoch > p.MaxDuration {
+		violations = append(violations, fmt.Sprintf("duration of %d epochs exceeds maximum of %d", dp.EndEpoch-dp.StartEpoch, p.MaxDuration))
+	}
+
+	return violations
+}
@@ -0,0 +1,40 @@
+// Package signsvc implements the remote signing protocol, which allows tenants to keep
+// their client keys within their own infrastructure. For every proposal spade sends the
+// exact serialized filmarket.DealProposal to the tenant-operated service, which checks
+// it against its own Policy before returning a signature.
+//
+// The protocol is a single authenticated JSON-over-HTTP call:
+//
+//	POST <base-url>/v1/sign_proposal
+//	Authorization: Bearer <token>
+//	{ "signer":"f1...", "proposal":"<base64 of the DAG-CBOR DealProposal>" }
+//
+// answered by either a 200 carrying { "signature":{ "Type":1, "Data":"<base64>" } },
+// or an error status carrying { "error":"...", "policy_violations":[...] }.
+// A 403 signifies a policy refusal: retrying the same proposal is pointless.
+package signsvc
+
+import (
+	"errors"
+
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+)
+
+// SignPath is the location of the signing call, relative to the service base URL
+const SignPath = "/v1/sign_proposal"
+
+// ErrRefused is returned by Client.Sign when the service refuses to sign based on its policy
+var ErrRefused = errors.New("remote signer refused to sign the proposal")
+
+// SignRequest is the body of a signing call
+type SignRequest struct {
+	Signer   string `json:"signer"`   // key address, never an ID address
+	Proposal []byte `json:"proposal"` // serialized filmarket.DealProposal: the exact bytes to sign
+}
+
+// SignResponse is the body of every reply
+type SignResponse struct {
+	Signature        *filcrypto.Signature `json:"signature,omitempty"`
+	Error            string               `json:"error,omitempty"`
+	PolicyViolations []string             `json:"policy_violations,omitempty"`
+}
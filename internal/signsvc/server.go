@@ -0,0 +1,119 @@
+package signsvc
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	filaddr "github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ribasushi/spade/internal/keystore"
+)
+
+// maximum accepted request body: a serialized proposal is a few hundred bytes
+const maxRequestBytes = 64 << 10
+
+// Server is the reference signing service: it signs with the keys of a local keystore,
+// on behalf of any caller presenting one of the configured bearer tokens
+type Server struct {
+	Keystore *keystore.Keystore
+	Tokens   []string
+	Policy   Policy
+	Logger   *logging.ZapEventLogger // optional
+}
+
+// Handler returns the HTTP handler of the service
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(SignPath, s.handleSign)
+	return mux
+}
+
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		reply(w, http.StatusMethodNotAllowed, SignResponse{Error: "only POST is supported"})
+		return
+	}
+
+	if !s.authorized(r) {
+		reply(w, http.StatusUnauthorized, SignResponse{Error: "missing or invalid bearer token"})
+		return
+	}
+
+	var req SignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, SignResponse{Error: "malformed request: " + err.Error()})
+		return
+	}
+
+	signer, err := filaddr.NewFromString(req.Signer)
+	if err != nil {
+		reply(w, http.StatusBadRequest, SignResponse{Error: "invalid signer address: " + err.Error()})
+		return
+	}
+
+	// what is signed must be exactly the proposal that is checked
+	var dp filmarket.DealProposal
+	if err := dp.UnmarshalCBOR(bytes.NewReader(req.Proposal)); err != nil {
+		reply(w, http.StatusBadRequest, SignResponse{Error: "proposal is not a valid DealProposal: " + err.Error()})
+		return
+	}
+	if canonical, err := cborutil.Dump(&dp); err != nil || !bytes.Equal(canonical, req.Proposal) {
+		reply(w, http.StatusBadRequest, SignResponse{Error: "proposal is not in canonical form"})
+		return
+	}
+
+	if violations := s.Policy.Check(dp); len(violations) > 0 {
+		s.logw("refused proposal", "signer", signer.String(), "provider", dp.Provider.String(), "pieceCid", dp.PieceCID.String(), "violations", violations)
+		reply(w, http.StatusForbidden, SignResponse{Error: "proposal violates signing policy", PolicyViolations: violations})
+		return
+	}
+
+	sig, err := s.Keystore.Sign(signer, req.Proposal)
+	if err != nil {
+		if errors.Is(err, keystore.ErrKeyNotFound) {
+			reply(w, http.StatusNotFound, SignResponse{Error: err.Error()})
+		} else {
+			reply(w, http.StatusInternalServerError, SignResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	s.logw("signed proposal", "signer", signer.String(), "provider", dp.Provider.String(), "pieceCid", dp.PieceCID.String())
+	reply(w, http.StatusOK, SignResponse{Signature: sig})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, "Bearer ") {
+		return false
+	}
+	tok := strings.TrimPrefix(hdr, "Bearer ")
+	if tok == "" {
+		return false
+	}
+	var match bool
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(tok)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
+
+func (s *Server) logw(msg string, kv ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Infow(msg, kv...)
+	}
+}
+
+func reply(w http.ResponseWriter, code int, resp SignResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
+}
@@ -0,0 +1,127 @@
+package signsvc
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	filaddr "github.com/filecoin-project/go-address"
+	cborutil "github.com/filecoin-project/go-cbor-util"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filbig "github.com/filecoin-project/go-state-types/big"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	lotustypes "github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	"github.com/ipfs/go-cid"
+	"github.com/ribasushi/spade/internal/keystore"
+)
+
+func TestRemoteSigning(t *testing.T) {
+	ctx := context.Background()
+
+	ksDir := t.TempDir()
+	if err := os.Chmod(ksDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := keystore.Open(ksDir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := sigs.Generate(filcrypto.SigTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ks.Import(lotustypes.KeyInfo{Type: lotustypes.KTSecp256k1, PrivateKey: pk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowedSP, _ := filaddr.NewIDAddress(1000)
+	otherSP, _ := filaddr.NewIDAddress(2000)
+
+	srv := httptest.NewServer((&Server{
+		Keystore: ks,
+		Tokens:   []string{"t0k3n"},
+		Policy: Policy{
+			AllowedProviders: map[filaddr.Address]struct{}{allowedSP: {}},
+			MaxDuration:      540 * filbuiltin.EpochsInDay,
+		},
+	}).Handler())
+	t.Cleanup(srv.Close)
+
+	c := &Client{BaseURL: srv.URL, Token: "t0k3n"}
+
+	good := testProposal(t, allowedSP)
+	raw := dump(t, good)
+	sig, err := c.Sign(ctx, clientKey, raw)
+	if err != nil {
+		t.Fatalf("acceptable proposal not signed: %s", err)
+	}
+	if err := sigs.Verify(sig, clientKey, raw); err != nil {
+		t.Errorf("returned signature does not verify: %s", err)
+	}
+
+	for name, mutate := range map[string]func(*filmarket.DealProposal){
+		"paid":       func(dp *filmarket.DealProposal) { dp.StoragePricePerEpoch = filbig.NewInt(1) },
+		"unverified": func(dp *filmarket.DealProposal) { dp.VerifiedDeal = false },
+		"provider":   func(dp *filmarket.DealProposal) { dp.Provider = otherSP },
+		"duration":   func(dp *filmarket.DealProposal) { dp.EndEpoch = dp.StartEpoch + 541*filbuiltin.EpochsInDay },
+	} {
+		dp := testProposal(t, allowedSP)
+		mutate(&dp)
+		if _, err := c.Sign(ctx, clientKey, dump(t, dp)); !errors.Is(err, ErrRefused) {
+			t.Errorf("%s: expected a policy refusal, got %v", name, err)
+		}
+	}
+
+	if _, err := (&Client{BaseURL: srv.URL, Token: "wrong"}).Sign(ctx, clientKey, raw); err == nil || errors.Is(err, ErrRefused) {
+		t.Errorf("expected an authentication failure, got %v", err)
+	}
+
+	if _, err := c.Sign(ctx, clientKey, append(raw, 0)); err == nil || errors.Is(err, ErrRefused) {
+		t.Errorf("expected trailing bytes to be rejected as malformed, got %v", err)
+	}
+
+	unknownKey, _ := filaddr.NewSecp256k1Address(make([]byte, 65))
+	if _, err := c.Sign(ctx, unknownKey, raw); err == nil {
+		t.Error("signing with a key not held by the service unexpectedly succeeded")
+	}
+}
+
+func testProposal(t *testing.T, provider filaddr.Address) filmarket.DealProposal {
+	pCid, err := cid.Parse("baga6ea4seaqao7s73y24kcutaosvacpdjgfe5pw76ooefnyqw4ynr3d2y6x2mpq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lbl, err := filmarket.NewLabelFromString("label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := filaddr.NewIDAddress(3000)
+
+	return filmarket.DealProposal{
+		PieceCID:             pCid,
+		PieceSize:            filabi.PaddedPieceSize(1 << 35),
+		VerifiedDeal:         true,
+		Client:               client,
+		Provider:             provider,
+		Label:                lbl,
+		StartEpoch:           100_000,
+		EndEpoch:             100_000 + 530*filbuiltin.EpochsInDay,
+		StoragePricePerEpoch: filbig.Zero(),
+		ProviderCollateral:   filbig.NewInt(1 << 20),
+		ClientCollateral:     filbig.Zero(),
+	}
+}
+
+func dump(t *testing.T, dp filmarket.DealProposal) []byte {
+	b, err := cborutil.Dump(&dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,53 @@
+// Package sigverify checks filecoin key signatures in-process, equivalently to lotus'
+// WalletVerify but without the network round trip.
+package sigverify
+
+import (
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp" // register secp256k1 with lib/sigs
+	blst "github.com/supranational/blst/bindings/go"
+)
+
+// Domain separation tag of Filecoin BLS signatures: min-pubkey-size, G2 signatures
+var blsDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_")
+
+const blsSigLen = 96
+
+// KeySig checks whether the raw signature sig over msg was produced by the key address addr.
+// The signature type is implied by the address protocol.
+func KeySig(addr filaddr.Address, sig []byte, msg []byte) bool {
+	switch addr.Protocol() {
+
+	case filaddr.BLS:
+		if len(sig) != blsSigLen {
+			return false
+		}
+		// both the signature and the public key are group-checked
+		return new(blst.P2Affine).VerifyCompressed(sig, true, addr.Payload(), true, msg, blsDST)
+
+	case filaddr.SECP256K1:
+		return sigs.Verify(
+			&filcrypto.Signature{Type: filcrypto.SigTypeSecp256k1, Data: sig},
+			addr,
+			msg,
+		) == nil
+
+	default:
+		return false
+	}
+}
+
+// Signature is KeySig for a typed signature, whose type must match the protocol of addr.
+func Signature(sig *filcrypto.Signature, addr filaddr.Address, msg []byte) bool {
+	switch {
+	case sig == nil:
+		return false
+	case addr.Protocol() == filaddr.BLS && sig.Type == filcrypto.SigTypeBLS,
+		addr.Protocol() == filaddr.SECP256K1 && sig.Type == filcrypto.SigTypeSecp256k1:
+		return KeySig(addr, sig.Data, msg)
+	default:
+		return false
+	}
+}
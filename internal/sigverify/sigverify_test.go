@@ -0,0 +1,38 @@
+package sigverify_test
+
+import (
+	"context"
+	"testing"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/ribasushi/spade/internal/fakechain"
+	"github.com/ribasushi/spade/internal/sigverify"
+)
+
+func TestKeySigRejectsMismatchedKeys(t *testing.T) {
+	fc := fakechain.New()
+	ctx := context.Background()
+	msg := []byte("spade")
+
+	secp, _ := fc.NewWalletKey(filcrypto.SigTypeSecp256k1)
+	bls, _ := fc.NewWalletKey(filcrypto.SigTypeBLS)
+	id, _ := filaddr.NewIDAddress(1234)
+
+	secpSig, _ := fc.WalletSign(ctx, secp, msg)
+	blsSig, _ := fc.WalletSign(ctx, bls, msg)
+
+	for _, tc := range []struct {
+		addr filaddr.Address
+		sig  []byte
+	}{
+		{bls, secpSig.Data},
+		{secp, blsSig.Data},
+		{id, secpSig.Data},
+		{bls, blsSig.Data[1:]},
+	} {
+		if sigverify.KeySig(tc.addr, tc.sig, msg) {
+			t.Errorf("signature of length %d unexpectedly valid for %s", len(tc.sig), tc.addr)
+		}
+	}
+}
@@ -162,6 +162,30 @@ CREATE TABLE IF NOT EXISTS spd.datasets_pieces (
   CONSTRAINT datasets_pieces_singleton UNIQUE ( piece_id, dataset_id )
 );
 
+-- HTTP(S) locations from which SPs can fetch the CAR file of a piece
+-- Removing a piece from a dataset removes the URLs registered for it within that dataset
+CREATE TABLE IF NOT EXISTS spd.datasets_pieces_http_sources (
+  piece_id BIGINT NOT NULL,
+  dataset_id SMALLINT NOT NULL,
+  source_url TEXT NOT NULL CONSTRAINT http_source_valid_url CHECK ( source_url ~ '^https?://[^/]+' ),
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT datasets_pieces_http_sources_singleton UNIQUE ( piece_id, dataset_id, source_url ),
+  CONSTRAINT datasets_pieces_http_sources_fkey FOREIGN KEY ( piece_id, dataset_id ) REFERENCES spd.datasets_pieces ( piece_id, dataset_id ) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- Per-dataset URL templates, applying to every piece within the dataset
+-- Recognized placeholders are {piece_cid} and {payload_cid}
+CREATE TABLE IF NOT EXISTS spd.datasets_http_templates (
+  dataset_id SMALLINT NOT NULL REFERENCES spd.datasets ( dataset_id ) ON UPDATE CASCADE,
+  url_template TEXT NOT NULL CONSTRAINT http_template_valid_url CHECK (
+    url_template ~ '^https?://[^/]+'
+      AND
+    ( url_template LIKE '%{piece\_cid}%' OR url_template LIKE '%{payload\_cid}%' )
+  ),
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT datasets_http_templates_singleton UNIQUE ( dataset_id, url_template )
+);
+
 CREATE TABLE IF NOT EXISTS spd.clients (
   client_id INTEGER UNIQUE NOT NULL,
   tenant_id SMALLINT REFERENCES spd.tenants ( tenant_id ) ON UPDATE CASCADE,
@@ -242,13 +266,131 @@ CREATE TABLE IF NOT EXISTS spd.tenants_providers (
 
 
 CREATE TABLE IF NOT EXISTS spd.requests (
-  provider_id INTEGER NOT NULL REFERENCES spd.providers ( provider_id ),
+  provider_id INTEGER REFERENCES spd.providers ( provider_id ),
+  tenant_id SMALLINT REFERENCES spd.tenants ( tenant_id ) ON UPDATE CASCADE,
   request_uuid UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
   entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
   request_dump JSONB NOT NULL,
-  request_meta JSONB NOT NULL DEFAULT '{}'
+  request_meta JSONB NOT NULL DEFAULT '{}',
+  CONSTRAINT requests_single_requestor CHECK ( ( provider_id IS NULL ) != ( tenant_id IS NULL ) )
 );
+-- upgrade pre-tenant-API installations
+ALTER TABLE spd.requests ALTER COLUMN provider_id DROP NOT NULL;
+ALTER TABLE spd.requests ADD COLUMN IF NOT EXISTS tenant_id SMALLINT REFERENCES spd.tenants ( tenant_id ) ON UPDATE CASCADE;
+DO $$
+BEGIN
+  -- ADD CONSTRAINT has no IF NOT EXISTS: match what CREATE TABLE declares above
+  IF NOT EXISTS ( SELECT 42 FROM pg_constraint WHERE conrelid = 'spd.requests'::REGCLASS AND conname = 'requests_single_requestor' ) THEN
+    ALTER TABLE spd.requests ADD CONSTRAINT requests_single_requestor CHECK ( ( provider_id IS NULL ) != ( tenant_id IS NULL ) );
+  END IF;
+END
+$$;
+ALTER TABLE spd.requests ADD COLUMN IF NOT EXISTS session_id UUID; -- no FK: sessions reference their issuing request
+ALTER TABLE spd.requests ADD COLUMN IF NOT EXISTS auth_role TEXT CONSTRAINT request_valid_auth_role CHECK ( auth_role IN ( 'owner', 'worker', 'control' ) );
 CREATE INDEX IF NOT EXISTS requests_entry_created ON spd.requests ( entry_created);
+
+-- Uses of Authorization headers within their validity window, shared by all webapi instances
+-- Entries are pruned by the webapi itself once they can no longer pass the epoch check
+-- Not UNLOGGED: emptied by a crash, it would let headers used within the window be replayed
+CREATE TABLE IF NOT EXISTS spd.auth_uses (
+  auth_digest BYTEA NOT NULL UNIQUE,
+  auth_epoch INTEGER NOT NULL,
+  use_count INTEGER NOT NULL DEFAULT 1
+);
+ALTER TABLE spd.auth_uses SET LOGGED;
+CREATE INDEX IF NOT EXISTS auth_uses_epoch ON spd.auth_uses ( auth_epoch );
+
+-- Key addresses allowed to sign on behalf of an SP, as of a finalized tipset
+-- Shared by all webapi instances, so that lotus is consulted once per SP per height
+-- No FK to spd.providers: keys are resolved before the SP is known to exist
+CREATE TABLE IF NOT EXISTS spd.sp_auth_keys (
+  provider_id INTEGER NOT NULL,
+  keys_height INTEGER NOT NULL,
+  keys_tipset_key TEXT NOT NULL,
+  auth_keys JSONB NOT NULL,
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT sp_auth_keys_singleton UNIQUE ( provider_id, keys_height )
+);
+
+-- Per-SP per-route request rate limiting token buckets, shared by all webapi instances
+CREATE UNLOGGED TABLE IF NOT EXISTS spd.rate_limit_buckets (
+  provider_id INTEGER NOT NULL,
+  limit_key TEXT NOT NULL,
+  tokens DOUBLE PRECISION NOT NULL,
+  last_refill TIMESTAMP WITH TIME ZONE NOT NULL,
+  CONSTRAINT rate_limit_bucket_singleton UNIQUE ( provider_id, limit_key )
+);
+-- Refills the bucket and takes cost tokens from it if possible
+-- Returns 0 on success, or the amount of seconds until enough tokens become available
+CREATE OR REPLACE
+  FUNCTION spd.rate_limit_take(sp INTEGER, lkey TEXT, burst DOUBLE PRECISION, per_second DOUBLE PRECISION, cost DOUBLE PRECISION) RETURNS DOUBLE PRECISION
+    LANGUAGE plpgsql
+AS $$
+DECLARE
+  avail DOUBLE PRECISION;
+BEGIN
+  IF per_second IS NULL OR per_second <= 0 THEN
+    RETURN 0;
+  END IF;
+
+  burst := GREATEST( COALESCE( burst, 1 ), 1 );
+  cost := LEAST( cost, burst ); -- a request must be satisfiable eventually
+
+  INSERT INTO spd.rate_limit_buckets AS b ( provider_id, limit_key, tokens, last_refill )
+    VALUES ( sp, lkey, burst, clock_timestamp() )
+  ON CONFLICT ( provider_id, limit_key ) DO UPDATE SET
+    tokens = LEAST( burst, b.tokens + per_second * EXTRACT( EPOCH FROM clock_timestamp() - b.last_refill ) ),
+    last_refill = clock_timestamp()
+  RETURNING tokens INTO avail;
+
+  IF avail < cost THEN
+    RETURN ( cost - avail ) / per_second;
+  END IF;
+
+  UPDATE spd.rate_limit_buckets SET
+    tokens = avail - cost
+  WHERE
+    provider_id = sp
+      AND
+    limit_key = lkey;
+
+  RETURN 0;
+END;
+$$;
+
+-- Every change made through the /admin/ routes, with the state of the affected provider before and after
+CREATE TABLE IF NOT EXISTS spd.admin_audit_log (
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  operator_name TEXT NOT NULL,
+  operator_address TEXT NOT NULL,
+  admin_action TEXT NOT NULL,
+  provider_id INTEGER REFERENCES spd.providers ( provider_id ),
+  tenant_id SMALLINT REFERENCES spd.tenants ( tenant_id ) ON UPDATE CASCADE,
+  requested_change JSONB NOT NULL,
+  state_before JSONB NOT NULL,
+  state_after JSONB NOT NULL,
+  request_dump JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS admin_audit_log_entry_created ON spd.admin_audit_log ( entry_created );
+CREATE INDEX IF NOT EXISTS admin_audit_log_provider ON spd.admin_audit_log ( provider_id, entry_created );
+
+-- Bearer sessions issued to SPs in exchange for a valid FIL-SPID signature
+-- Only a digest of the token is ever stored
+CREATE TABLE IF NOT EXISTS spd.sp_sessions (
+  session_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+  provider_id INTEGER NOT NULL REFERENCES spd.providers ( provider_id ),
+  token_digest BYTEA NOT NULL UNIQUE,
+  session_scope TEXT NOT NULL CONSTRAINT session_valid_scope CHECK ( session_scope IN ( 'read', 'reserve' ) ),
+  auth_role TEXT NOT NULL CONSTRAINT session_valid_auth_role CHECK ( auth_role IN ( 'owner', 'worker', 'control' ) ),
+  session_label TEXT,
+  issuing_request_uuid UUID NOT NULL REFERENCES spd.requests ( request_uuid ),
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  session_expires TIMESTAMP WITH TIME ZONE NOT NULL,
+  session_last_used TIMESTAMP WITH TIME ZONE,
+  session_revoked TIMESTAMP WITH TIME ZONE,
+  CONSTRAINT session_valid_expiration CHECK ( session_expires > entry_created )
+);
+CREATE INDEX IF NOT EXISTS sp_sessions_provider ON spd.sp_sessions ( provider_id, session_expires );
 CREATE OR REPLACE
   FUNCTION spd.init_authed_sp() RETURNS TRIGGER
     LANGUAGE plpgsql
@@ -261,6 +403,7 @@ $$;
 CREATE OR REPLACE TRIGGER trigger_create_related_sp
   BEFORE INSERT ON spd.requests
   FOR EACH ROW
+  WHEN ( NEW.provider_id IS NOT NULL )
   EXECUTE PROCEDURE spd.init_authed_sp()
 ;
 
@@ -333,6 +476,32 @@ CREATE TABLE IF NOT EXISTS spd.invalidated_deals (
   invalidation_meta JSONB NOT NULL DEFAULT '{}'
 );
 
+-- Claims of verified-registry allocations ( direct data onboarding ), the counterpart of
+-- published_deals for tenants not going through the builtin market
+CREATE TABLE IF NOT EXISTS spd.verified_claims (
+  claim_id BIGINT UNIQUE NOT NULL CONSTRAINT claim_valid_id CHECK ( claim_id > 0 ),
+  piece_id BIGINT NOT NULL,
+  piece_cid TEXT NOT NULL,
+  claimed_log2_size BIGINT NOT NULL CONSTRAINT piece_valid_size CHECK ( claimed_log2_size > 0 ),
+  provider_id INTEGER NOT NULL REFERENCES spd.providers ( provider_id ),
+  client_id INTEGER NOT NULL REFERENCES spd.clients ( client_id ),
+  sector_number BIGINT NOT NULL,
+  term_start INTEGER NOT NULL CONSTRAINT claim_valid_term_start CHECK ( term_start > 0 ),
+  term_min INTEGER NOT NULL,
+  term_max INTEGER NOT NULL,
+  status TEXT NOT NULL CONSTRAINT claim_valid_status CHECK ( status IN ( 'active', 'terminated' ) ),
+  verified_claim_meta JSONB NOT NULL DEFAULT '{}',
+  entry_created TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT piece_id_cid_fkey FOREIGN KEY ( piece_id, piece_cid ) REFERENCES spd.pieces ( piece_id, piece_cid ) ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS verified_claims_piece_id_idx ON spd.verified_claims ( piece_id );
+CREATE INDEX IF NOT EXISTS verified_claims_active ON spd.verified_claims ( piece_id, provider_id ) WHERE ( status = 'active' );
+CREATE OR REPLACE TRIGGER trigger_init_claim_relations
+  BEFORE INSERT ON spd.verified_claims
+  FOR EACH ROW
+  EXECUTE PROCEDURE spd.init_deal_relations()
+;
+
 CREATE TABLE IF NOT EXISTS spd.proposals (
   proposal_uuid UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
   piece_id BIGINT NOT NULL,
@@ -364,6 +533,9 @@ CREATE OR REPLACE TRIGGER trigger_proposal_update_ts
   EXECUTE PROCEDURE spd.update_entry_timestamp()
 ;
 CREATE INDEX IF NOT EXISTS proposals_piece_idx ON spd.proposals ( piece_id );
+-- direct data onboarding: set once the allocation is on chain / once the SP claimed it
+ALTER TABLE spd.proposals ADD COLUMN IF NOT EXISTS allocation_id BIGINT UNIQUE;
+ALTER TABLE spd.proposals ADD COLUMN IF NOT EXISTS activated_claim_id BIGINT UNIQUE REFERENCES spd.verified_claims ( claim_id );
 CREATE INDEX IF NOT EXISTS proposals_pending ON spd.proposals ( piece_id, provider_id, client_id ) INCLUDE ( proxied_log2_size ) WHERE ( proposal_failstamp = 0 AND activated_deal_id IS NULL );
 
 -- Used exclusively for the `FilDAG` portion of a `Sources` response and corresponding availability matview
@@ -414,6 +586,10 @@ CREATE OR REPLACE VIEW spd.clients_datacap_available AS
             AND
           pr.activated_deal_id IS NULL
             AND
+          pr.activated_claim_id IS NULL
+            AND
+          pr.allocation_id IS NULL -- datacap of allocations on chain is already gone from activatable_datacap
+            AND
           pr.client_id = c.client_id
         )::BIGINT,
         0
@@ -479,6 +655,23 @@ CREATE OR REPLACE VIEW spd.known_deals_ranked AS (
 
         UNION ALL
 
+        (
+          SELECT
+              NULL AS deal_id,
+              vc.piece_id,
+              vc.provider_id,
+              vc.client_id,
+              ( vc.term_start + vc.term_max ) AS end_epoch,
+              4::"char" AS state, -- a claim is the equivalent of an active deal
+              true AS is_filplus,
+              NULL AS proposal_label
+            FROM spd.verified_claims vc
+          WHERE
+            vc.status = 'active'
+        )
+
+        UNION ALL
+
         (
           SELECT
               NULL AS deal_id,
@@ -486,7 +679,11 @@ CREATE OR REPLACE VIEW spd.known_deals_ranked AS (
               pr.provider_id,
               pr.client_id,
               pr.end_epoch,
-              ( CASE WHEN pr.proposal_delivered IS NOT NULL THEN 2::"char" ELSE 1::"char" END ) AS state, -- proposed / accepted but not yet chain-published
+              ( CASE
+                WHEN pr.allocation_id IS NOT NULL THEN 3::"char" -- allocated on chain, equivalent to published
+                WHEN pr.proposal_delivered IS NOT NULL THEN 2::"char"
+                ELSE 1::"char"
+              END ) AS state, -- proposed / accepted but not yet chain-published
               true AS is_filplus, -- we do not propose non-filplus
               p.proposal_label
             FROM spd.proposals pr
@@ -508,6 +705,8 @@ CREATE OR REPLACE VIEW spd.known_deals_ranked AS (
             pr.proposal_failstamp = 0
               AND
             pr.activated_deal_id IS NULL
+              AND
+            pr.activated_claim_id IS NULL
         )
       ) pub_and_prop, spd.clients c, cutoff
     WHERE
@@ -562,6 +761,20 @@ CREATE MATERIALIZED VIEW IF NOT EXISTS spd.mv_pieces_availability WITH ( toast_t
             FROM spd.datasets_pieces dp
             JOIN spd.tenants_datasets td USING ( dataset_id )
           GROUP BY dp.piece_id
+        ),
+        pieces_with_http AS (
+          SELECT piece_id
+            FROM spd.datasets_pieces_http_sources
+            UNION
+          SELECT dp.piece_id
+            FROM spd.datasets_pieces dp
+            JOIN spd.datasets_http_templates dht USING ( dataset_id )
+            JOIN spd.pieces p USING ( piece_id )
+          WHERE
+            -- a template referencing the payload can not be expanded for label-less pieces
+            dht.url_template NOT LIKE '%{payload\_cid}%'
+              OR
+            p.proposal_label IS NOT NULL
         )
       SELECT
           p.piece_id,
@@ -574,12 +787,13 @@ CREATE MATERIALIZED VIEW IF NOT EXISTS spd.mv_pieces_availability WITH ( toast_t
           ) AS coarse_latest_active_end_epoch,
           p.piece_log2_size,
           ( p.piece_log2_size = 36 ) AS requires_64g_sector,
-          false AS http_available,
+          ( pwh.piece_id IS NOT NULL ) AS http_available,
           p.piece_cid,
           p.proposal_label,
           poi.potential_tenant_ids
         FROM pieces_of_interest poi
         JOIN spd.pieces p USING ( piece_id )
+        LEFT JOIN pieces_with_http pwh USING ( piece_id )
     ) s
 ) WITH NO DATA;
 ALTER MATERIALIZED VIEW spd.mv_pieces_availability ALTER COLUMN piece_cid SET STORAGE MAIN;
@@ -683,6 +897,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
                   AND
                 pr.activated_deal_id IS NULL
                   AND
+                pr.activated_claim_id IS NULL
+                  AND
                 pr.client_id IN ( SELECT client_id FROM spd.clients c WHERE c.tenant_id = t.tenant_id )
             )::BIGINT,
             0::BIGINT
@@ -1478,18 +1694,35 @@ ANALYZE spd.mv_overreplicated_total;
 -- this is *distinct* from mv_replicas_org: it lists deals in any live state on chain
 CREATE MATERIALIZED VIEW IF NOT EXISTS spd.mv_orglocal_presence AS
   SELECT DISTINCT
-      pd.piece_id,
-      p.org_id
-    FROM spd.published_deals pd
-    JOIN spd.providers p USING ( provider_id )
-    LEFT JOIN spd.invalidated_deals id USING ( deal_id )
-  WHERE
-    p.org_id != 0
-      AND
-    pd.status != 'terminated'
-      AND
-    id.deal_id IS NULL
-  ORDER BY pd.piece_id, p.org_id
+      piece_id,
+      org_id
+    FROM (
+      SELECT
+          pd.piece_id,
+          p.org_id
+        FROM spd.published_deals pd
+        JOIN spd.providers p USING ( provider_id )
+        LEFT JOIN spd.invalidated_deals id USING ( deal_id )
+      WHERE
+        p.org_id != 0
+          AND
+        pd.status != 'terminated'
+          AND
+        id.deal_id IS NULL
+
+    UNION ALL
+
+      SELECT
+          vc.piece_id,
+          p.org_id
+        FROM spd.verified_claims vc
+        JOIN spd.providers p USING ( provider_id )
+      WHERE
+        p.org_id != 0
+          AND
+        vc.status = 'active'
+    ) live
+  ORDER BY piece_id, org_id
 ;
 CREATE UNIQUE INDEX IF NOT EXISTS mv_orglocal_presence_key ON spd.mv_orglocal_presence ( piece_id, org_id );
 ANALYZE spd.mv_orglocal_presence;
@@ -1597,14 +1830,16 @@ CREATE OR REPLACE
     arg_limit INTEGER,
     arg_only_tenant_id SMALLINT, -- use 0 for ~any~
     arg_include_sourceless BOOL,
-    arg_only_orglocal BOOL
+    arg_only_orglocal BOOL,
+    arg_after_display_sort BIGINT -- use 0 to start from the top
   ) RETURNS TABLE (
     piece_id BIGINT,
     piece_log2_size SMALLINT,
     has_sources_http BOOL,
     has_sources_fil_active BOOL,
     piece_cid TEXT,
-    tenant_ids SMALLINT[]
+    tenant_ids SMALLINT[],
+    display_sort BIGINT
   )
 LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS \$\$
 
@@ -1639,12 +1874,19 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS \$\$
       pa.http_available AS has_sources_http,
       ( pa.coarse_latest_active_end_epoch IS NOT NULL ) AS has_sources_fil_active,
       pa.piece_cid,
-      claiming_tenants.tenant_ids
+      claiming_tenants.tenant_ids,
+      pa.display_sort
     FROM spd.mv_pieces_availability pa, sp $parts->{$_}{FROM}
 
   WHERE $parts->{$_}{COND}
       AND
 
+    --
+    -- pagination
+    pa.display_sort > arg_after_display_sort
+
+      AND
+
     --
     -- can we seal it ourselves?
     (
@@ -1708,6 +1950,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS \$\$
         pr.proposal_failstamp = 0
           AND
         pr.activated_deal_id IS NULL
+          AND
+        pr.activated_claim_id IS NULL
     )
 
   ORDER BY display_sort
@@ -1729,14 +1973,16 @@ CREATE OR REPLACE
     arg_limit INTEGER,
     arg_only_tenant_id SMALLINT, -- use 0 for ~any~
     arg_include_sourceless BOOL,
-    arg_only_orglocal BOOL
+    arg_only_orglocal BOOL,
+    arg_after_display_sort BIGINT -- use 0 to start from the top
   ) RETURNS TABLE (
     piece_id BIGINT,
     piece_log2_size SMALLINT,
     has_sources_http BOOL,
     has_sources_fil_active BOOL,
     piece_cid TEXT,
-    tenant_ids SMALLINT[]
+    tenant_ids SMALLINT[],
+    display_sort BIGINT
   )
 LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
 
@@ -1771,7 +2017,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
       pa.http_available AS has_sources_http,
       ( pa.coarse_latest_active_end_epoch IS NOT NULL ) AS has_sources_fil_active,
       pa.piece_cid,
-      claiming_tenants.tenant_ids
+      claiming_tenants.tenant_ids,
+      pa.display_sort
     FROM spd.mv_pieces_availability pa, sp , LATERAL (
       SELECT
           ARRAY_AGG( et.tenant_id ) AS tenant_ids
@@ -1860,6 +2107,12 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
 
       AND
 
+    --
+    -- pagination
+    pa.display_sort > arg_after_display_sort
+
+      AND
+
     --
     -- can we seal it ourselves?
     (
@@ -1923,6 +2176,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
         pr.proposal_failstamp = 0
           AND
         pr.activated_deal_id IS NULL
+          AND
+        pr.activated_claim_id IS NULL
     )
 
   ORDER BY display_sort
@@ -1937,14 +2192,16 @@ CREATE OR REPLACE
     arg_limit INTEGER,
     arg_only_tenant_id SMALLINT, -- use 0 for ~any~
     arg_include_sourceless BOOL,
-    arg_only_orglocal BOOL
+    arg_only_orglocal BOOL,
+    arg_after_display_sort BIGINT -- use 0 to start from the top
   ) RETURNS TABLE (
     piece_id BIGINT,
     piece_log2_size SMALLINT,
     has_sources_http BOOL,
     has_sources_fil_active BOOL,
     piece_cid TEXT,
-    tenant_ids SMALLINT[]
+    tenant_ids SMALLINT[],
+    display_sort BIGINT
   )
 LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
 
@@ -2054,7 +2311,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
       pa.http_available AS has_sources_http,
       ( pa.coarse_latest_active_end_epoch IS NOT NULL ) AS has_sources_fil_active,
       pa.piece_cid,
-      claiming_tenants.tenant_ids
+      claiming_tenants.tenant_ids,
+      pa.display_sort
     FROM spd.mv_pieces_availability pa, sp , claiming_tenants
 
   WHERE
@@ -2064,6 +2322,12 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
 
       AND
 
+    --
+    -- pagination
+    pa.display_sort > arg_after_display_sort
+
+      AND
+
     --
     -- can we seal it ourselves?
     (
@@ -2127,6 +2391,8 @@ LANGUAGE sql PARALLEL RESTRICTED STABLE STRICT AS $$
         pr.proposal_failstamp = 0
           AND
         pr.activated_deal_id IS NULL
+          AND
+        pr.activated_claim_id IS NULL
     )
 
   ORDER BY display_sort
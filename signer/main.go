@@ -0,0 +1,182 @@
+package main //nolint:revive
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	filaddr "github.com/filecoin-project/go-address"
+	filabi "github.com/filecoin-project/go-state-types/abi"
+	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/go-toolbox/ufcli"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/keystore"
+	"github.com/ribasushi/spade/internal/signsvc"
+	"golang.org/x/xerrors"
+)
+
+// Reference implementation of a tenant-operated remote signing service, see internal/signsvc
+// Not linked to any spade database or lotus node: all it needs is a keystore
+func main() {
+	cmdName := app.AppName + "-signer"
+	log := logging.Logger(fmt.Sprintf("%s(%d)", cmdName, os.Getpid()))
+	logging.SetLogLevel("*", "INFO") //nolint:errcheck
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Error(cmn.WrErr(err))
+		os.Exit(1)
+	}
+
+	var (
+		maxDurationDays int
+		allowPaid       bool
+		allowUnverified bool
+		srv             *http.Server
+	)
+
+	serve := &ufcli.Command{
+		Usage: "Serve signing requests",
+		Name:  "serve",
+		Flags: []ufcli.Flag{
+			&ufcli.IntFlag{
+				Name:        "policy-max-duration-days",
+				Usage:       "Maximum duration of a signed deal",
+				Value:       540,
+				Destination: &maxDurationDays,
+			},
+			&ufcli.BoolFlag{
+				Name:        "policy-allow-paid",
+				Usage:       "Sign proposals with a non-zero price or client collateral",
+				Destination: &allowPaid,
+			},
+			&ufcli.BoolFlag{
+				Name:        "policy-allow-unverified",
+				Usage:       "Sign proposals for non-Fil+ deals",
+				Destination: &allowUnverified,
+			},
+		},
+		Action: func(cctx *ufcli.Context) error {
+			ks, err := keystore.Open(cctx.String("signer-keystore-dir"), cctx.String("signer-keystore-passphrase"))
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+
+			var tokens []string
+			for _, t := range strings.Split(cctx.String("signer-auth-tokens"), ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					tokens = append(tokens, t)
+				}
+			}
+			if len(tokens) == 0 {
+				return xerrors.New("at least one signer-auth-tokens entry is required")
+			}
+
+			pol := signsvc.Policy{
+				AllowPaid:        allowPaid,
+				AllowUnverified:  allowUnverified,
+				AllowedProviders: make(map[filaddr.Address]struct{}),
+				MaxDuration:      filabi.ChainEpoch(maxDurationDays) * filbuiltin.EpochsInDay,
+			}
+			for _, p := range strings.Split(cctx.String("policy-allowed-providers"), ",") {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				a, err := filaddr.NewFromString(p)
+				if err != nil || a.Protocol() != filaddr.ID {
+					return xerrors.Errorf("allowed provider '%s' is not a valid f0 address", p)
+				}
+				pol.AllowedProviders[a] = struct{}{}
+			}
+
+			held, err := ks.List()
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+			log.Infow("starting",
+				"keys", len(held),
+				"allowedProviders", len(pol.AllowedProviders),
+				"maxDurationDays", maxDurationDays,
+				"allowPaid", allowPaid,
+				"allowUnverified", allowUnverified,
+			)
+
+			srv = &http.Server{
+				Addr:    cctx.String("signer-listen-address"),
+				Handler: (&signsvc.Server{Keystore: ks, Tokens: tokens, Policy: pol, Logger: log}).Handler(),
+			}
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				return cmn.WrErr(err)
+			}
+			return nil
+		},
+	}
+
+	keystoreImport := &ufcli.Command{
+		Usage:     "Import a client key, as produced by `lotus wallet export`, into the keystore",
+		Name:      "keystore-import",
+		ArgsUsage: " < exported-key-file",
+		Action: func(cctx *ufcli.Context) error {
+			ks, err := keystore.Open(cctx.String("signer-keystore-dir"), cctx.String("signer-keystore-passphrase"))
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+
+			exported, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && exported == "" {
+				return xerrors.Errorf("unable to read exported key from STDIN: %w", err)
+			}
+
+			addr, err := ks.ImportExported(exported)
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+
+			log.Infow("imported key", "address", addr.String())
+			fmt.Println(addr)
+			return nil
+		},
+	}
+
+	(&ufcli.UFcli{
+		Logger:   log,
+		TOMLPath: fmt.Sprintf("%s/%s.toml", home, cmdName),
+		AppConfig: ufcli.App{
+			Name:     cmdName,
+			Usage:    "Reference remote signing service for " + app.AppName + " tenants",
+			Commands: []*ufcli.Command{serve, keystoreImport},
+			Flags: []ufcli.Flag{
+				ufcli.ConfStringFlag(&ufcli.StringFlag{
+					Name:  "signer-listen-address",
+					Value: "localhost:8090",
+				}),
+				ufcli.ConfStringFlag(&ufcli.StringFlag{
+					Name: "signer-keystore-dir",
+				}),
+				ufcli.ConfStringFlag(&ufcli.StringFlag{
+					Name:        "signer-keystore-passphrase",
+					DefaultText: "  {{ private, read from config file }}  ",
+				}),
+				ufcli.ConfStringFlag(&ufcli.StringFlag{
+					Name:        "signer-auth-tokens",
+					DefaultText: "  {{ token1,token2 read from config file }}  ",
+				}),
+				ufcli.ConfStringFlag(&ufcli.StringFlag{
+					Name:  "policy-allowed-providers",
+					Usage: "Comma-separated list of providers proposals may be made to, empty allows any",
+				}),
+			},
+		},
+		BeforeShutdown: func() error {
+			if srv != nil {
+				return srv.Shutdown(context.Background())
+			}
+			return nil
+		},
+	}).RunAndExit(context.Background())
+}
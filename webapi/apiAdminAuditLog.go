@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type adminAuditEntry struct {
+	Created         time.Time              `json:"created"           db:"entry_created"`
+	Operator        string                 `json:"operator"          db:"operator_name"`
+	OperatorAddress string                 `json:"operator_address"`
+	Action          string                 `json:"action"            db:"admin_action"`
+	ProviderID      *fil.ActorID           `json:"provider_id,omitempty"`
+	TenantID        *int16                 `json:"tenant_id,omitempty"`
+	Change          map[string]interface{} `json:"change"            db:"requested_change"`
+	StateBefore     map[string]interface{} `json:"state_before"`
+	StateAfter      map[string]interface{} `json:"state_after"`
+}
+
+// recordAdminChange writes an entry to spd.admin_audit_log, within the transaction making the change
+func recordAdminChange(ctx context.Context, c echo.Context, tx pgx.Tx, action string, spID fil.ActorID, tenantID *int16, change interface{}, before, after adminProvider) error {
+	_, ctxMeta := unpackAuthedEchoContext(c)
+
+	reqJ, err := requestDump(c)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	changeJ, err := json.Marshal(change)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	beforeJ, err := json.Marshal(before)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	afterJ, err := json.Marshal(after)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`
+		INSERT INTO spd.admin_audit_log
+			( operator_name, operator_address, admin_action, provider_id, tenant_id, requested_change, state_before, state_after, request_dump )
+		VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9 )
+		`,
+		ctxMeta.authedOperator,
+		ctxMeta.authedOperatorAddress.String(),
+		action,
+		spID,
+		tenantID,
+		changeJ,
+		beforeJ,
+		afterJ,
+		reqJ,
+	)
+	return cmn.WrErr(err)
+}
+
+func apiAdminListAuditLog(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	lim := uint64(adminAuditLogDefaultSize)
+	if c.QueryParams().Has("limit") {
+		var err error
+		if lim, err = parseUIntQueryParam(c, "limit", 1, adminAuditLogMaxSize); err != nil {
+			return retFail(c, apitypes.ErrInvalidRequest, "%s", err.Error())
+		}
+	}
+
+	var spID *fil.ActorID
+	if c.QueryParams().Has("provider") {
+		sp, err := fil.ParseActorString(c.QueryParam("provider"))
+		if err != nil {
+			return retFail(c, apitypes.ErrInvalidRequest, "Provided provider '%s' is not valid", c.QueryParam("provider"))
+		}
+		spID = &sp
+	}
+
+	ret := make([]adminAuditEntry, 0, lim)
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret,
+		`
+		SELECT
+				entry_created,
+				operator_name,
+				operator_address,
+				admin_action,
+				provider_id,
+				tenant_id,
+				requested_change,
+				state_before,
+				state_after
+			FROM spd.admin_audit_log
+		WHERE
+			( $1::INTEGER IS NULL OR provider_id = $1 )
+		ORDER BY entry_created DESC
+		LIMIT $2
+		`,
+		spID,
+		lim,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, ret, "Most recent administrative changes, newest first")
+}
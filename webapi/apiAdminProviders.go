@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type adminProviderLocation struct {
+	OrgID       int16 `json:"org_id"`
+	CityID      int16 `json:"city_id"`
+	CountryID   int16 `json:"country_id"`
+	ContinentID int16 `json:"continent_id"`
+}
+
+type adminTenantProvider struct {
+	TenantID           int16                  `json:"tenant_id"`
+	TenantName         string                 `json:"tenant_name"`
+	TenantProviderMeta map[string]interface{} `json:"tenant_provider_meta"`
+}
+
+type adminProvider struct {
+	ProviderID fil.ActorID `json:"provider_id"`
+	adminProviderLocation
+	ProviderMeta map[string]interface{} `json:"provider_meta"`
+	Tenants      []adminTenantProvider  `json:"tenants"`
+}
+
+// adminProviderUpdate lists the operator-settable properties of an SP
+// Fields left out are not changed, false removes the corresponding flag
+type adminProviderUpdate struct {
+	GloballyInactivated    *bool                  `json:"globally_inactivated,omitempty"`
+	IgnoreChainEligibility *bool                  `json:"ignore_chain_eligibility,omitempty"`
+	Location               *adminProviderLocation `json:"location,omitempty"` // either all zeroes or all positive
+}
+
+// adminTenantProviderUpdate lists the operator-settable properties of an SP within a tenant
+// Fields left out are not changed, false or a negative number remove the corresponding setting
+type adminTenantProviderUpdate struct {
+	Inactivated    *bool  `json:"inactivated,omitempty"`
+	MaxInFlightGiB *int64 `json:"max_in_flight_GiB,omitempty"` // negative reverts to the tenant default
+}
+
+func apiAdminGetProvider(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	spID, err := fil.ParseActorString(c.Param("providerID"))
+	if err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided ProviderID '%s' is not valid", c.Param("providerID"))
+	}
+
+	p, found, err := adminLoadProvider(ctx, ctxMeta.Db[app.DbMain], spID)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	if !found {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provider %s is not known to the system", spID)
+	}
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, p, "Current settings of provider %s", spID)
+}
+
+func apiAdminUpdateProvider(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	spID, err := fil.ParseActorString(c.Param("providerID"))
+	if err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided ProviderID '%s' is not valid", c.Param("providerID"))
+	}
+
+	var upd adminProviderUpdate
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&upd); err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "unable to decode request body: %s", err)
+	}
+	var orgID, cityID, countryID, continentID *int16
+	if l := upd.Location; l != nil {
+		orgID, cityID, countryID, continentID = &l.OrgID, &l.CityID, &l.CountryID, &l.ContinentID
+		allZero := l.OrgID == 0 && l.CityID == 0 && l.CountryID == 0 && l.ContinentID == 0
+		allSet := l.OrgID > 0 && l.CityID > 0 && l.CountryID > 0 && l.ContinentID > 0
+		if !allZero && !allSet {
+			return retFail(c, apitypes.ErrInvalidRequest, "location ids must be either all zero or all positive")
+		}
+	}
+
+	metaPatch := make(map[string]interface{}, 2)
+	setFlag(metaPatch, "globally_inactivated", upd.GloballyInactivated)
+	setFlag(metaPatch, "ignore_chain_eligibility", upd.IgnoreChainEligibility)
+	if len(metaPatch) == 0 && upd.Location == nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "request body does not specify any change")
+	}
+	metaPatchJ, err := json.Marshal(metaPatch)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	var before, after adminProvider
+	var found bool
+	if err := ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+		var err error
+		if before, found, err = adminLoadProvider(ctx, tx, spID); err != nil || !found {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			`
+			UPDATE spd.providers SET
+				provider_meta = JSONB_STRIP_NULLS( provider_meta || $2::JSONB ),
+				org_id = COALESCE( $3, org_id ),
+				city_id = COALESCE( $4, city_id ),
+				country_id = COALESCE( $5, country_id ),
+				continent_id = COALESCE( $6, continent_id )
+			WHERE
+				provider_id = $1
+			`,
+			spID,
+			metaPatchJ,
+			orgID,
+			cityID,
+			countryID,
+			continentID,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if after, _, err = adminLoadProvider(ctx, tx, spID); err != nil {
+			return err
+		}
+
+		return recordAdminChange(ctx, c, tx, "update_provider", spID, nil, upd, before, after)
+	}); err != nil {
+		return cmn.WrErr(err)
+	}
+	if !found {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provider %s is not known to the system", spID)
+	}
+
+	// do not wait for the cached eligibility verdict to expire
+	providerEligibleCache.Del(uint64(spID))
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, after, "Settings of provider %s updated", spID)
+}
+
+func apiAdminUpdateTenantProvider(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	spID, err := fil.ParseActorString(c.Param("providerID"))
+	if err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided ProviderID '%s' is not valid", c.Param("providerID"))
+	}
+	tid, err := strconv.ParseUint(c.Param("tenantID"), 10, 15)
+	if err != nil || tid == 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided TenantID '%s' is not valid", c.Param("tenantID"))
+	}
+	tenantID := int16(tid)
+
+	var upd adminTenantProviderUpdate
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&upd); err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "unable to decode request body: %s", err)
+	}
+
+	metaPatch := make(map[string]interface{}, 2)
+	setFlag(metaPatch, "inactivated", upd.Inactivated)
+	if upd.MaxInFlightGiB != nil {
+		if *upd.MaxInFlightGiB < 0 {
+			metaPatch["max_in_flight_GiB"] = nil
+		} else {
+			metaPatch["max_in_flight_GiB"] = *upd.MaxInFlightGiB
+		}
+	}
+	if len(metaPatch) == 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "request body does not specify any change")
+	}
+	metaPatchJ, err := json.Marshal(metaPatch)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	var before, after adminProvider
+	var found bool
+	if err := ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+		var tenantKnown bool
+		if err := tx.QueryRow(
+			ctx,
+			`SELECT EXISTS ( SELECT 42 FROM spd.tenants WHERE tenant_id = $1 )`,
+			tenantID,
+		).Scan(&tenantKnown); err != nil || !tenantKnown {
+			return cmn.WrErr(err)
+		}
+
+		var err error
+		if before, found, err = adminLoadProvider(ctx, tx, spID); err != nil || !found {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			`
+			INSERT INTO spd.tenants_providers ( tenant_id, provider_id, tenant_provider_meta )
+				VALUES ( $1, $2, JSONB_STRIP_NULLS( $3::JSONB ) )
+			ON CONFLICT ( tenant_id, provider_id ) DO UPDATE SET
+				tenant_provider_meta = JSONB_STRIP_NULLS( spd.tenants_providers.tenant_provider_meta || $3::JSONB )
+			`,
+			tenantID,
+			spID,
+			metaPatchJ,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if after, _, err = adminLoadProvider(ctx, tx, spID); err != nil {
+			return err
+		}
+
+		return recordAdminChange(ctx, c, tx, "update_tenant_provider", spID, &tenantID, upd, before, after)
+	}); err != nil {
+		return cmn.WrErr(err)
+	}
+	if !found {
+		return retFail(c, apitypes.ErrInvalidRequest, "Tenant %d or provider %s is not known to the system", tenantID, spID)
+	}
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, after, "Settings of provider %s within tenant %d updated", spID, tenantID)
+}
+
+type adminQuerier interface {
+	pgxscan.Querier
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// adminLoadProvider returns the operator-visible state of a provider, together with a boolean
+// indicating whether the provider exists at all
+func adminLoadProvider(ctx context.Context, db adminQuerier, spID fil.ActorID) (adminProvider, bool, error) {
+	var p adminProvider
+	if err := db.QueryRow(
+		ctx,
+		`
+		SELECT provider_id, org_id, city_id, country_id, continent_id, provider_meta
+			FROM spd.providers
+		WHERE
+			provider_id = $1
+		`,
+		spID,
+	).Scan(&p.ProviderID, &p.OrgID, &p.CityID, &p.CountryID, &p.ContinentID, &p.ProviderMeta); err == pgx.ErrNoRows {
+		return p, false, nil
+	} else if err != nil {
+		return p, false, cmn.WrErr(err)
+	}
+
+	p.Tenants = make([]adminTenantProvider, 0)
+	if err := pgxscan.Select(
+		ctx,
+		db,
+		&p.Tenants,
+		`
+		SELECT tp.tenant_id, t.tenant_name, tp.tenant_provider_meta
+			FROM spd.tenants_providers tp
+			JOIN spd.tenants t USING ( tenant_id )
+		WHERE
+			tp.provider_id = $1
+		ORDER BY tp.tenant_id
+		`,
+		spID,
+	); err != nil {
+		return p, false, cmn.WrErr(err)
+	}
+
+	return p, true, nil
+}
+
+// setFlag records a boolean JSONB meta flag change: false removes the flag altogether
+func setFlag(patch map[string]interface{}, key string, val *bool) {
+	if val == nil {
+		return
+	}
+	if *val {
+		patch[key] = true
+	} else {
+		patch[key] = nil
+	}
+}
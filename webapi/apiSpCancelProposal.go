@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type spCancelledProposal struct {
+	ProposalID string `json:"proposal_id" db:"proposal_uuid"`
+	PieceCid   string `json:"piece_cid"`
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func apiSpCancelProposal(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	arg := c.Param("proposalIDorPieceCID")
+	var selector string
+	if lcArg := strings.ToLower(arg); uuidRe.MatchString(lcArg) {
+		selector = lcArg
+	} else if pCid, err := parsePieceCID(arg); err == nil {
+		selector = pCid.String()
+	} else {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided argument '%s' is neither a ProposalID nor a valid PieceCID", arg)
+	}
+
+	cancelled := make([]spCancelledProposal, 0, 1)
+	var countPublished int
+	if err := ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+
+		// same lock as for requesting pieces: the freed up quota/replica counts are consistent
+		// for the next reservation attempt
+		if _, err := tx.Exec(ctx, requestPieceLockStatement); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		pending := make([]struct {
+			spCancelledProposal
+			IsPublished bool
+		}, 0, 1)
+		if err := pgxscan.Select(
+			ctx,
+			tx,
+			&pending,
+			`
+			SELECT
+					pr.proposal_uuid,
+					p.piece_cid,
+					(
+						-- an allocation can no longer be withdrawn once its datacap transfer is submitted
+						-- same as a published deal: trackAllocationMessages ignores cancelled proposals
+						pr.allocation_id IS NOT NULL
+							OR
+						pr.proposal_meta ? 'allocation_msg_cid'
+							OR
+						EXISTS (
+							SELECT 42
+								FROM spd.published_deals pd
+							WHERE
+								pd.piece_id = pr.piece_id
+									AND
+								pd.provider_id = pr.provider_id
+									AND
+								pd.client_id = pr.client_id
+									AND
+								pd.status != 'terminated'
+						)
+					) AS is_published
+				FROM spd.proposals pr
+				JOIN spd.pieces p USING ( piece_id )
+			WHERE
+				pr.provider_id = $1
+					AND
+				pr.proposal_failstamp = 0
+					AND
+				pr.activated_deal_id IS NULL
+					AND
+				pr.activated_claim_id IS NULL
+					AND
+				( pr.proposal_uuid::TEXT = $2 OR p.piece_cid = $2 )
+			`,
+			ctxMeta.authedActorID,
+			selector,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		toCancel := make([]string, 0, len(pending))
+		for _, p := range pending {
+			if p.IsPublished {
+				countPublished++
+			} else {
+				toCancel = append(toCancel, p.ProposalID)
+			}
+		}
+		if len(toCancel) == 0 {
+			return nil
+		}
+
+		return pgxscan.Select(
+			ctx,
+			tx,
+			&cancelled,
+			`
+			UPDATE spd.proposals pr SET
+				proposal_failstamp = spd.big_now(),
+				proposal_meta = JSONB_SET(
+					proposal_meta,
+					'{ failure }',
+					TO_JSONB( 'cancelled by provider'::TEXT )
+				)
+			FROM spd.pieces p
+			WHERE
+				pr.piece_id = p.piece_id
+					AND
+				pr.provider_id = $1
+					AND
+				pr.proposal_failstamp = 0
+					AND
+				pr.proposal_uuid = ANY( $2::UUID[] )
+			RETURNING pr.proposal_uuid, p.piece_cid
+			`,
+			ctxMeta.authedActorID,
+			toCancel,
+		)
+	}); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	if len(cancelled) == 0 {
+		if countPublished > 0 {
+			return retFail(
+				c,
+				apitypes.ErrInvalidRequest,
+				"The proposal matching '%s' has already been published on chain ( or submitted for publishing ) and can no longer be cancelled",
+				arg,
+			)
+		}
+		return retFail(c, apitypes.ErrInvalidRequest, "No outstanding proposal matching '%s' found for SP %s", arg, ctxMeta.authedActorID)
+	}
+
+	msg := []string{
+		fmt.Sprintf("Cancelled %d outstanding proposal(s) matching '%s'.", len(cancelled), arg),
+		`Please do not import or publish any previously received deal proposal for the cancelled entries.`,
+	}
+	if countPublished > 0 {
+		msg = append(msg, fmt.Sprintf("%d additional matching proposal(s) were already published on chain, and were left intact.", countPublished))
+	}
+	msg = append(msg,
+		``,
+		`In order to see what proposals you have currently pending, you can invoke:`,
+		" "+curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/pending_proposals"),
+	)
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, cancelled, strings.Join(msg, "\n"))
+}
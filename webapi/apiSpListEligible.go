@@ -1,8 +1,10 @@
 package main //nolint:revive
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
@@ -10,6 +12,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
 )
 
 func apiSpListEligible(c echo.Context) error {
@@ -24,6 +27,25 @@ func apiSpListEligible(c echo.Context) error {
 		}
 	}
 
+	var afterDisplaySort int64
+	if c.QueryParams().Has("continuation-token") {
+		tokRefreshed, ds, err := decodeContinuationToken(c.QueryParam("continuation-token"))
+		if err != nil {
+			return retFail(c, apitypes.ErrInvalidRequest, "provided 'continuation-token' is not valid: %s", err)
+		}
+		if tokRefreshed != ctxMeta.matviewsRefreshed {
+			return retFail(
+				c,
+				apitypes.ErrInvalidRequest,
+				strings.Join([]string{
+					"The provided 'continuation-token' was issued against a list which has since been recomputed.",
+					"Please restart your listing from the beginning, by omitting the 'continuation-token' parameter.",
+				}, "\n"),
+			)
+		}
+		afterDisplaySort = ds
+	}
+
 	tenantID := int16(0) // 0 == any
 	if c.QueryParams().Has("tenant") {
 		tid, err := parseUIntQueryParam(c, "tenant", 1, 1<<15)
@@ -50,27 +72,6 @@ func apiSpListEligible(c echo.Context) error {
 		useQueryFunc = "pieces_eligible_full"
 	}
 
-	orderedPieces := make([]*struct {
-		PieceID       int64
-		PieceLog2Size uint8
-		pieceSources
-		*apitypes.Piece
-	}, 0, lim+1)
-
-	if err := pgxscan.Select(
-		ctx,
-		ctxMeta.Db[app.DbMain],
-		&orderedPieces,
-		fmt.Sprintf("SELECT * FROM spd.%s( $1, $2, $3, $4, $5 )", useQueryFunc),
-		ctxMeta.authedActorID,
-		lim+1, // ask for one extra, to disambiguate "there is more"
-		tenantID,
-		truthyBoolQueryParam(c, "include-sourceless"),
-		orglocalOnly,
-	); err != nil {
-		return cmn.WrErr(err)
-	}
-
 	info := []string{
 		`List of qualifying Piece CIDs together with their availability from various sources.`,
 		``,
@@ -101,9 +102,108 @@ func apiSpListEligible(c echo.Context) error {
 		)
 	}
 
-	// we got more than requested - indicate that this set is large
-	if uint64(len(orderedPieces)) > lim {
-		orderedPieces = orderedPieces[:lim]
+	rows, err := ctxMeta.Db[app.DbMain].Query(
+		ctx,
+		fmt.Sprintf("SELECT * FROM spd.%s( $1, $2, $3, $4, $5, $6 )", useQueryFunc),
+		ctxMeta.authedActorID,
+		lim+1, // ask for one extra, to disambiguate "there is more"
+		tenantID,
+		truthyBoolQueryParam(c, "include-sourceless"),
+		orglocalOnly,
+		afterDisplaySort,
+	)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	defer rows.Close()
+
+	var stream *ndjsonStream
+	var ret apitypes.ResponsePiecesEligible
+	if wantsNDJSON(c) {
+		if stream, err = startNDJSON(c); err != nil {
+			return cmn.WrErr(err)
+		}
+	} else {
+		ret = make(apitypes.ResponsePiecesEligible, 0, lim)
+	}
+	fail := func(err error) error {
+		if stream != nil {
+			return stream.abort(cmn.WrErr(err))
+		}
+		return cmn.WrErr(err)
+	}
+
+	// sources are injected and entries emitted chunk by chunk
+	chunk := make([]*eligiblePiece, 0, streamChunkSize)
+	emitChunk := func() error {
+		srcPtrs := make(piecePointers, len(chunk))
+		for _, p := range chunk {
+			p.PaddedPieceSize = 1 << p.PieceLog2Size
+			p.SampleRequestCmd = curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/request_piece/"+p.PieceCid)
+
+			p.pieceSources.sourcesPointer = &p.Piece.Sources
+			p.pieceSources.pieceCid = p.PieceCid
+			srcPtrs[p.PieceID] = p.pieceSources
+		}
+
+		if err := injectSources(ctx, srcPtrs, restrictToOrgID); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		for _, p := range chunk {
+			if stream == nil {
+				ret = append(ret, p.Piece)
+			} else if err := stream.entry(p.Piece); err != nil {
+				return cmn.WrErr(err)
+			}
+		}
+		if stream != nil {
+			stream.flush()
+		}
+
+		chunk = chunk[:0]
+		return nil
+	}
+
+	rs := pgxscan.NewRowScanner(rows)
+	var emitted uint64
+	var lastDisplaySort int64
+	var truncated bool
+	for rows.Next() {
+		// we got more than requested - indicate that this set is large
+		if emitted == lim {
+			truncated = true
+			break
+		}
+
+		p := new(eligiblePiece)
+		if err := rs.Scan(p); err != nil {
+			return fail(err)
+		}
+		emitted++
+		lastDisplaySort = p.DisplaySort
+
+		chunk = append(chunk, p)
+		if len(chunk) == streamChunkSize {
+			if err := emitChunk(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+	rows.Close()
+	if err := emitChunk(); err != nil {
+		return fail(err)
+	}
+
+	var tok string
+	if truncated {
+		tok = encodeContinuationToken(ctxMeta.matviewsRefreshed, lastDisplaySort)
+
+		nextQ := c.QueryParams()
+		nextQ.Set("continuation-token", tok)
 
 		exLim := lim
 		if exLim < listEligibleDefaultSize {
@@ -115,27 +215,60 @@ func apiSpListEligible(c echo.Context) error {
 				fmt.Sprintf(`NOTE: The complete list of entries has been TRUNCATED to the top %d.`, lim),
 				"Use the 'limit' param in your API call to request more of the (possibly very large) list:",
 				" " + curlAuthedForSP(c, ctxMeta.authedActorID, fmt.Sprintf("%s?limit=%d", c.Request().URL.Path, (2*exLim)/100*100)),
+				"Alternatively fetch the next page by supplying the returned continuation_token:",
+				" " + curlAuthedForSP(c, ctxMeta.authedActorID, c.Request().URL.Path+"?"+nextQ.Encode()),
 				"",
 			},
 			info...,
 		)
 	}
 
-	srcPtrs := make(piecePointers, len(orderedPieces))
-	ret := make(apitypes.ResponsePiecesEligible, len(orderedPieces))
-	for i, p := range orderedPieces {
-		p.PaddedPieceSize = 1 << p.PieceLog2Size
-		p.SampleRequestCmd = curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/request_piece/"+p.PieceCid)
-		ret[i] = p.Piece
-
-		p.pieceSources.sourcesPointer = &ret[i].Sources
-		p.pieceSources.pieceCid = p.PieceCid
-		srcPtrs[p.PieceID] = p.pieceSources
+	if stream != nil {
+		return stream.finish(ndjsonTrailer{
+			ContinuationToken: tok,
+			InfoLines:         msgLines(strings.Join(info, "\n")),
+		})
 	}
 
-	if err := injectSources(ctx, srcPtrs, restrictToOrgID); err != nil {
-		return cmn.WrErr(err)
+	if tok != "" {
+		c.Response().Header().Set(continuationTokenHeader, tok)
 	}
-
 	return retPayloadAnnotated(c, http.StatusOK, 0, ret, strings.Join(info, "\n"))
 }
+
+type eligiblePiece struct {
+	PieceID       int64
+	PieceLog2Size uint8
+	DisplaySort   int64
+	pieceSources
+	*apitypes.Piece
+}
+
+const continuationTokenHeader = "X-SPADE-CONTINUATION-TOKEN"
+
+// a continuation token encodes the matview refresh marker of the listing it belongs to, and
+// the display_sort of the last returned entry: both are opaque to the caller
+func encodeContinuationToken(matviewsRefreshed, lastDisplaySort int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", matviewsRefreshed, lastDisplaySort)))
+}
+
+func decodeContinuationToken(tok string) (matviewsRefreshed, lastDisplaySort int64, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, ds, found := strings.Cut(string(raw), ":")
+	if !found {
+		return 0, 0, xerrors.New("unexpected token format")
+	}
+	if matviewsRefreshed, err = strconv.ParseInt(e, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if lastDisplaySort, err = strconv.ParseInt(ds, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if lastDisplaySort < 1 {
+		return 0, 0, xerrors.New("unexpected token format")
+	}
+	return matviewsRefreshed, lastDisplaySort, nil
+}
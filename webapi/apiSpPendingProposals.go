@@ -16,6 +16,32 @@ import (
 	"github.com/ribasushi/spade/internal/app"
 )
 
+// spDealStatus is the state of a delivered deal as last reported by the SP itself, see poll-deal-status
+type spDealStatus struct {
+	CheckedAt     time.Time `json:"checked_at"`
+	Checkpoint    string    `json:"checkpoint"`
+	SealingStatus string    `json:"sealing_status,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	AwaitingData  bool      `json:"awaiting_offline_data,omitempty"`
+	BytesReceived uint64    `json:"bytes_received,omitempty"`
+	PublishCid    *string   `json:"publish_cid,omitempty"`
+	ChainDealID   uint64    `json:"chain_deal_id,omitempty"`
+}
+
+type spPendingProposal struct {
+	apitypes.DealProposal
+	DealStatus *spDealStatus `json:"deal_status,omitempty"`
+
+	// set instead of a proposal cid for tenants onboarding via verified-registry allocations
+	AllocationID *int64 `json:"allocation_id,omitempty"`
+}
+
+// spPendingProposals is apitypes.ResponsePendingProposals extended with the SP-reported deal states
+type spPendingProposals struct {
+	RecentFailures   []apitypes.ProposalFailure `json:"recent_failures,omitempty"`
+	PendingProposals []spPendingProposal        `json:"pending_proposals"`
+}
+
 func apiSpListPendingProposals(c echo.Context) error {
 	ctx, ctxMeta := unpackAuthedEchoContext(c)
 
@@ -27,15 +53,14 @@ func apiSpListPendingProposals(c echo.Context) error {
 		ProposalFailstamp int64
 		Error             *string
 		ProposalDelivered *time.Time
+		DealStatus        *spDealStatus
+		AllocationID      *int64
 		IsPublished       bool
 		PieceLog2Size     int8
 	}
-	pending := make([]pendingProposals, 0, 4096)
 
-	if err := pgxscan.Select(
+	rows, err := ctxMeta.Db[app.DbMain].Query(
 		ctx,
-		ctxMeta.Db[app.DbMain],
-		&pending,
 		`
 		SELECT
 				pr.proposal_uuid AS proposal_id,
@@ -49,6 +74,8 @@ func apiSpListPendingProposals(c echo.Context) error {
 				pr.proxied_log2_size AS piece_log2_size,
 				pr.proposal_failstamp,
 				pr.proposal_meta->>'failure' AS error,
+				pr.proposal_meta->'deal_status' AS deal_status,
+				pr.allocation_id,
 				( EXISTS (
 					SELECT 42
 						FROM spd.published_deals pd
@@ -62,7 +89,7 @@ func apiSpListPendingProposals(c echo.Context) error {
 						pd.status = 'published'
 				) ) AS is_published,
 				COALESCE( ( pa.coarse_latest_active_end_epoch IS NOT NULL ), false ) AS has_sources_fil_active,
-				false AS has_sources_http
+				COALESCE( pa.http_available, false ) AS has_sources_http
 			FROM spd.proposals pr
 			JOIN spd.pieces p USING ( piece_id )
 			JOIN spd.clients c USING ( client_id )
@@ -74,6 +101,8 @@ func apiSpListPendingProposals(c echo.Context) error {
 				AND
 			pr.activated_deal_id is NULL
 				AND
+			pr.activated_claim_id is NULL
+				AND
 			(
 				pr.proposal_failstamp = 0
 					OR
@@ -89,9 +118,11 @@ func apiSpListPendingProposals(c echo.Context) error {
 		ctxMeta.authedActorID,
 		fil.WallTimeEpoch(time.Now())+filbuiltin.EpochsInHour-28000,
 		showRecentFailuresHours,
-	); err != nil {
+	)
+	if err != nil {
 		return cmn.WrErr(err)
 	}
+	defer rows.Close()
 
 	type dealTuple struct {
 		pieceID  int64
@@ -99,13 +130,65 @@ func apiSpListPendingProposals(c echo.Context) error {
 	}
 
 	var toPropose, toActivate, outstandingBytes int64
-	srcPtrs := make(piecePointers, len(pending))
 	fails := make(map[dealTuple]apitypes.ProposalFailure)
-	ret := apitypes.ResponsePendingProposals{
-		PendingProposals: make([]apitypes.DealProposal, 0, len(pending)),
+	ret := spPendingProposals{
+		PendingProposals: make([]spPendingProposal, 0, 1024),
+	}
+
+	var stream *ndjsonStream
+	if wantsNDJSON(c) {
+		if stream, err = startNDJSON(c); err != nil {
+			return cmn.WrErr(err)
+		}
+	}
+	fail := func(err error) error {
+		if stream != nil {
+			return stream.abort(cmn.WrErr(err))
+		}
+		return cmn.WrErr(err)
+	}
+
+	// sources are injected and entries emitted chunk by chunk
+	type chunkEntry struct {
+		pieceID int64
+		dp      spPendingProposal
+		pieceSources
+	}
+	chunk := make([]chunkEntry, 0, streamChunkSize)
+	emitChunk := func() error {
+		srcPtrs := make(piecePointers, len(chunk))
+		for i := range chunk {
+			chunk[i].pieceSources.sourcesPointer = &chunk[i].dp.Sources
+			chunk[i].pieceSources.pieceCid = chunk[i].dp.PieceCid
+			srcPtrs[chunk[i].pieceID] = chunk[i].pieceSources
+		}
+
+		if err := injectSources(ctx, srcPtrs, 0); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		for _, e := range chunk {
+			if stream == nil {
+				ret.PendingProposals = append(ret.PendingProposals, e.dp)
+			} else if err := stream.entry(e.dp); err != nil {
+				return cmn.WrErr(err)
+			}
+		}
+		if stream != nil {
+			stream.flush()
+		}
+
+		chunk = chunk[:0]
+		return nil
 	}
 
-	for _, p := range pending {
+	rs := pgxscan.NewRowScanner(rows)
+	for rows.Next() {
+		var p pendingProposals
+		if err := rs.Scan(&p); err != nil {
+			return fail(err)
+		}
+
 		outstandingBytes += (1 << p.PieceLog2Size)
 
 		switch {
@@ -134,29 +217,43 @@ func apiSpListPendingProposals(c echo.Context) error {
 			toPropose++
 
 		default:
-			dp := p.DealProposal
+			dp := spPendingProposal{DealProposal: p.DealProposal, DealStatus: p.DealStatus, AllocationID: p.AllocationID}
 			dp.StartTime = fil.MainnetTime(filabi.ChainEpoch(dp.StartEpoch))
 			dp.HoursRemaining = int(time.Until(dp.StartTime).Truncate(time.Hour).Hours())
 			dp.PieceSize = 1 << p.PieceLog2Size
 			dp.TenantClient = p.ClientID.String()
-			// should never be nil but be cautious
-			if dp.ProposalCid != nil {
+			if dp.AllocationID != nil {
+				dp.ImportCmd = fmt.Sprintf("boostd import-direct --client-addr=%s --allocation-id=%d %s %s.car",
+					dp.TenantClient,
+					*dp.AllocationID,
+					dp.PieceCid,
+					apitypes.TrimCidString(dp.PieceCid),
+				)
+			} else if dp.ProposalCid != nil { // should never be nil but be cautious
 				dp.ImportCmd = fmt.Sprintf("lotus-miner storage-deals import-data %s %s.car",
 					*dp.ProposalCid,
 					apitypes.TrimCidString(dp.PieceCid),
 				)
 			}
 
-			ret.PendingProposals = append(ret.PendingProposals, dp)
-
-			p.pieceSources.sourcesPointer = &ret.PendingProposals[len(ret.PendingProposals)-1].Sources
-			p.pieceSources.pieceCid = p.PieceCid
-			srcPtrs[p.PieceID] = p.pieceSources
+			chunk = append(chunk, chunkEntry{pieceID: p.PieceID, dp: dp, pieceSources: p.pieceSources})
+			if len(chunk) == streamChunkSize {
+				if err := emitChunk(); err != nil {
+					return fail(err)
+				}
+			}
 		}
 	}
-
-	if err := injectSources(ctx, srcPtrs, 0); err != nil {
-		return cmn.WrErr(err)
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+	rows.Close()
+	if err := emitChunk(); err != nil {
+		return fail(err)
+	}
+	countDelivered := len(ret.PendingProposals)
+	if stream != nil {
+		countDelivered = stream.entries
 	}
 
 	msg := fmt.Sprintf(
@@ -172,7 +269,7 @@ You can request deal proposals using API endpoints as described in the docs`,
 		ctxMeta.authedActorID,
 		float64(outstandingBytes)/(1<<30),
 		toPropose,
-		len(ret.PendingProposals),
+		countDelivered,
 		toActivate,
 	)
 
@@ -188,6 +285,14 @@ You can request deal proposals using API endpoints as described in the docs`,
 		})
 	}
 
+	if stream != nil {
+		ret.PendingProposals = nil
+		return stream.finish(ndjsonTrailer{
+			InfoLines: msgLines(msg),
+			Summary:   ret,
+		})
+	}
+
 	return retPayloadAnnotated(
 		c,
 		http.StatusOK,
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math/bits"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	filbig "github.com/filecoin-project/go-state-types/big"
 	filbuiltin "github.com/filecoin-project/go-state-types/builtin"
 	filmarket "github.com/filecoin-project/go-state-types/builtin/v9/market"
+	filverifreg "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
 	lotustypes "github.com/filecoin-project/lotus/chain/types"
 	"github.com/georgysavva/scany/pgxscan"
 	lru "github.com/hashicorp/golang-lru/v2"
@@ -20,11 +22,11 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/multiformats/go-multibase"
-	"github.com/multiformats/go-multihash"
 	"github.com/ribasushi/go-toolbox-interplanetary/fil"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/spade/internal/app"
 	"github.com/ribasushi/spade/internal/filtypes"
+	"golang.org/x/xerrors"
 )
 
 var v1UrlEnc = multibase.MustNewEncoder(multibase.Base64url)
@@ -33,19 +35,9 @@ func apiSpRequestPiece(c echo.Context) error {
 	ctx, ctxMeta := unpackAuthedEchoContext(c)
 
 	pCidArg := c.Param("pieceCID")
-	pCid, err := cid.Parse(pCidArg)
+	pCid, err := parsePieceCID(pCidArg)
 	if err != nil {
-		return retFail(c, apitypes.ErrInvalidRequest, "Requested PieceCid '%s' is not valid: %s", pCidArg, err)
-	}
-	if pCid.Prefix().Codec != cid.FilCommitmentUnsealed || pCid.Prefix().MhType != multihash.SHA2_256_TRUNC254_PADDED {
-		return retFail(
-			c,
-			apitypes.ErrInvalidRequest,
-			"Requested PieceCID '%s' does not have expected codec (%x) and multihash (%x)",
-			pCid,
-			cid.FilCommitmentUnsealed,
-			multihash.SHA2_256_TRUNC254_PADDED,
-		)
+		return retFail(c, apitypes.ErrInvalidRequest, "Requested PieceCID '%s' is not valid: %s", pCidArg, err)
 	}
 
 	tenantID := int16(0) // 0 == any
@@ -57,322 +49,592 @@ func apiSpRequestPiece(c echo.Context) error {
 		tenantID = int16(tid)
 	}
 
+	if errCode, msg, err := spProposalsPrecheck(c); err != nil {
+		return cmn.WrErr(err)
+	} else if errCode != 0 {
+		return retFail(c, errCode, "%s", msg)
+	}
+
+	// outbound calls happen before the lock is taken, see consultReservationWebhooks
+	webhookVerdicts, err := consultReservationWebhooks(ctx, ctxMeta, pCid, tenantID)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+
+		_, err = tx.Exec(
+			ctx,
+			requestPieceLockStatement,
+		)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		res, err := requestPieceInTx(ctx, tx, ctxMeta, pCid, tenantID, webhookVerdicts)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		if res.errCode != 0 {
+			if res.resp == nil {
+				return retFail(c, res.errCode, "%s", res.msg)
+			}
+			return retPayloadAnnotated(c, http.StatusForbidden, res.errCode, *res.resp, "%s", res.msg)
+		}
+
+		return retPayloadAnnotated(
+			c,
+			http.StatusOK,
+			0,
+			*res.resp,
+			strings.Join([]string{
+				res.msg,
+				``,
+				`In about 5 minutes check the pending list:`,
+				" " + curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/pending_proposals"),
+			}, "\n"),
+		)
+	})
+}
+
+// spProposalsPrecheck returns a non-zero error code and corresponding message
+// if the authenticated SP can not currently receive any proposals at all
+func spProposalsPrecheck(c echo.Context) (apitypes.APIErrorCode, string, error) {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
 	// check whether the provider has been polled
 	if ctxMeta.spInfoLastPolled == nil ||
 		ctxMeta.spInfoLastPolled.Before(time.Now().Add(-1*app.PolledSPInfoStaleAfterMinutes*time.Minute)) {
-		return retFail(
-			c,
-			apitypes.ErrStorageProviderInfoTooOld,
+		return apitypes.ErrStorageProviderInfoTooOld,
 			"Provider has not been dialed by the polling system recently: please try again in about a minute",
-		)
+			nil
 	}
 
 	// check whether dialable at all
 	if ctxMeta.spInfo.PeerInfo == nil || len(ctxMeta.spInfo.PeerInfo.Protos) == 0 {
-		return retFail(
-			c,
-			apitypes.ErrStorageProviderUndialable,
+		return apitypes.ErrStorageProviderUndialable,
 			strings.Join([]string{
 				"It appears your provider can not be libp2p-dialed over the TCP transport.",
 				"Please invoke the status endpoint for further details:",
 				curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/status"),
 			}, "\n"),
-		)
+			nil
 	}
 
 	// only boost
 	if _, canV120 := ctxMeta.spInfo.PeerInfo.Protos[filtypes.StorageProposalV120]; !canV120 {
-		return retFail(
-			c,
-			apitypes.ErrStorageProviderUnsupported,
-			strings.Join([]string{
-				"It appears your provider does not support %s.",
-				"You must upgrade to Boost v1.5.1 or equivalent to use ♠️",
-			}, "\n"),
-			filtypes.StorageProposalV120,
-		)
+		return errStorageProviderUnsupported,
+			fmt.Sprintf(
+				strings.Join([]string{
+					"It appears your provider does not support %s.",
+					"You must upgrade to Boost v1.5.1 or equivalent to use ♠️",
+				}, "\n"),
+				filtypes.StorageProposalV120,
+			),
+			nil
 	}
 
 	errCode, err := spIneligibleErr(ctx, ctxMeta.authedActorID)
 	if err != nil {
-		return cmn.WrErr(err)
+		return 0, "", cmn.WrErr(err)
 	} else if errCode != 0 {
-		return retFail(c, errCode, ineligibleSpMsg(ctxMeta.authedActorID))
+		return errCode, ineligibleSpMsg(ctxMeta.authedActorID), nil
 	}
 
-	return ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+	return 0, "", nil
+}
 
-		_, err = tx.Exec(
-			ctx,
-			requestPieceLockStatement,
-		)
-		if err != nil {
-			return cmn.WrErr(err)
+type pieceRequestOutcome struct {
+	errCode apitypes.APIErrorCode
+	msg     string
+	resp    *apitypes.ResponseDealRequest // nil when the error is unrelated to replication state
+}
+
+// tenantReservationMeta is the subset of tenant_meta consulted when reserving deals
+type tenantReservationMeta struct {
+	Webhook *reservationWebhook `json:"reservation_webhook"`
+
+	// when non-empty only SPs authenticated with a key of one of these roles may reserve deals
+	ReserveAuthRoles []string `json:"reserve_auth_roles"`
+
+	// one of app.Onboarding*, empty is the same as app.OnboardingMarket
+	Onboarding string `json:"onboarding"`
+}
+
+func (tm tenantReservationMeta) permitsAuthRole(role string) bool {
+	if len(tm.ReserveAuthRoles) == 0 {
+		return true
+	}
+	for _, r := range tm.ReserveAuthRoles {
+		if r == role {
+			return true
 		}
+	}
+	return false
+}
 
-		type tenantEligible struct {
-			apitypes.TenantReplicationState
-			IsExclusive         bool         `db:"exclusive_replication"`
-			TenantClientID      *fil.ActorID `db:"client_id_to_use"`
-			TenantClientAddress *string      `db:"client_address_to_use"`
+func (tm tenantReservationMeta) hasWebhook() bool {
+	return tm.Webhook != nil && tm.Webhook.URL != ""
+}
 
-			ProposalLabel string
-			PieceID       int64
+type tenantEligible struct {
+	apitypes.TenantReplicationState
+	IsExclusive         bool         `db:"exclusive_replication"`
+	TenantClientID      *fil.ActorID `db:"client_id_to_use"`
+	TenantClientAddress *string      `db:"client_address_to_use"`
 
-			PieceSizeBytes int64
+	ProposalLabel string
+	PieceID       int64
 
-			DealDurationDays       int16
-			StartWithinHours       int16
-			RecentlyUsedStartEpoch *int64
+	PieceSizeBytes int64
 
-			TenantMeta []byte
-		}
+	DealDurationDays       int16
+	StartWithinHours       int16
+	RecentlyUsedStartEpoch *int64
 
-		tenantsEligible := make([]tenantEligible, 0, 8)
+	TenantMeta []byte
+}
 
-		if err := pgxscan.Select(
-			ctx,
-			tx,
-			&tenantsEligible,
-			`
-			SELECT
-					*
-				FROM spd.piece_realtime_eligibility( $1, $2 )
-			WHERE
-				proposal_label IS NOT NULL
-					AND
-				( 0 = $3 OR tenant_id = $3)
-			`,
-			ctxMeta.authedActorID,
-			pCid,
-			tenantID,
-		); err != nil {
-			return cmn.WrErr(err)
-		}
+// reservationCandidate is an eligible tenant that would grant a deal according to our own rules
+type reservationCandidate struct {
+	*tenantEligible
+	tenantReservationMeta
+}
 
-		if len(tenantsEligible) == 0 {
-			return retFail(c, apitypes.ErrUnclaimedPieceCID, "Piece %s is not claimed by any selected tenant", pCid)
-		}
+// pieceEvaluation is the result of applying our own replication rules to a piece request
+type pieceEvaluation struct {
+	tenantsEligible []tenantEligible
+	candidates      []reservationCandidate
+	resp            apitypes.ResponseDealRequest
+}
+
+// webhookVerdict is the decision of a tenant reservation webhook, with fail_open already applied
+type webhookVerdict struct {
+	approved bool
+	reason   string
+}
 
-		if tenantsEligible[0].PieceSizeBytes > 1<<ctxMeta.spInfo.SectorLog2Size {
-			return retFail(c, apitypes.ErrOversizedPiece,
+// evaluatePieceRequest applies our own replication rules to a piece request. When no tenant
+// would grant a deal the returned evaluation is nil, and the outcome explains why.
+func evaluatePieceRequest(ctx context.Context, q pgxscan.Querier, ctxMeta metaContext, pCid cid.Cid, tenantID int16) (*pieceEvaluation, pieceRequestOutcome, error) {
+
+	tenantsEligible := make([]tenantEligible, 0, 8)
+
+	if err := pgxscan.Select(
+		ctx,
+		q,
+		&tenantsEligible,
+		`
+		SELECT
+				*
+			FROM spd.piece_realtime_eligibility( $1, $2 )
+		WHERE
+			proposal_label IS NOT NULL
+				AND
+			( 0 = $3 OR tenant_id = $3)
+		`,
+		ctxMeta.authedActorID,
+		pCid,
+		tenantID,
+	); err != nil {
+		return nil, pieceRequestOutcome{}, cmn.WrErr(err)
+	}
+
+	if len(tenantsEligible) == 0 {
+		return nil, pieceRequestOutcome{
+			errCode: apitypes.ErrUnclaimedPieceCID,
+			msg:     fmt.Sprintf("Piece %s is not claimed by any selected tenant", pCid),
+		}, nil
+	}
+
+	if tenantsEligible[0].PieceSizeBytes > 1<<ctxMeta.spInfo.SectorLog2Size {
+		return nil, pieceRequestOutcome{
+			errCode: apitypes.ErrOversizedPiece,
+			msg: fmt.Sprintf(
 				"Piece %s weighing %d GiB is larger than the %d GiB sector size your SP supports",
 				pCid,
 				tenantsEligible[0].PieceSizeBytes>>30,
 				1<<(ctxMeta.spInfo.SectorLog2Size-30),
-			)
-		}
+			),
+		}, nil
+	}
 
-		// count ineligibles, assemble actual return
-		var countNoDataCap, countAlreadyDealt, countOverReplicated, countOverPending int
-		var chosenTenant *tenantEligible
-		resp := apitypes.ResponseDealRequest{
+	// count ineligibles, assemble actual return
+	var countNoDataCap, countAlreadyDealt, countOverReplicated, countOverPending, countRoleRestricted int
+	ev := pieceEvaluation{
+		tenantsEligible: tenantsEligible,
+		candidates:      make([]reservationCandidate, 0, len(tenantsEligible)),
+		resp: apitypes.ResponseDealRequest{
 			ReplicationStates: make([]apitypes.TenantReplicationState, len(tenantsEligible)),
+		},
+	}
+	for i := range tenantsEligible {
+		te := &tenantsEligible[i]
+		if te.TenantClientID != nil {
+			s := te.TenantClientID.String()
+			te.TenantReplicationState.TenantClient = &s
 		}
-		for i, te := range tenantsEligible {
-			if te.TenantClientID != nil {
-				s := te.TenantClientID.String()
-				te.TenantReplicationState.TenantClient = &s
-			}
-			resp.ReplicationStates[i] = te.TenantReplicationState
+		ev.resp.ReplicationStates[i] = te.TenantReplicationState
 
-			var invalidated bool
+		var invalidated bool
 
-			if te.TenantClient == nil {
-				countNoDataCap++
-				invalidated = true
-			}
-			if te.DealAlreadyExists {
-				countAlreadyDealt++
-				invalidated = true
-			}
-			if te.Total >= te.MaxTotal ||
-				te.InOrg >= te.MaxOrg ||
-				te.InCity >= te.MaxCity ||
-				te.InCountry >= te.MaxCountry ||
-				te.InContinent >= te.MaxContinent {
-				countOverReplicated++
-				invalidated = true
-			}
-			if te.SpInFlightBytes+te.PieceSizeBytes > te.MaxInFlightBytes {
-				countOverPending++
-				invalidated = true
-			}
-
-			if !invalidated && chosenTenant == nil {
-				chosenTenant = &te
-			}
+		if te.TenantClient == nil {
+			countNoDataCap++
+			invalidated = true
+		}
+		if te.DealAlreadyExists {
+			countAlreadyDealt++
+			invalidated = true
+		}
+		if te.Total >= te.MaxTotal ||
+			te.InOrg >= te.MaxOrg ||
+			te.InCity >= te.MaxCity ||
+			te.InCountry >= te.MaxCountry ||
+			te.InContinent >= te.MaxContinent {
+			countOverReplicated++
+			invalidated = true
+		}
+		if te.SpInFlightBytes+te.PieceSizeBytes > te.MaxInFlightBytes {
+			countOverPending++
+			invalidated = true
 		}
 
-		// handle "no takers" here, for ease of reading further down
-		// this is slightly convoluted since we can have a "mixed error condition" - handled in the default:
-		if chosenTenant == nil {
-
-			switch len(tenantsEligible) {
-
-			case countAlreadyDealt:
-				return retPayloadAnnotated(c, http.StatusForbidden,
-					apitypes.ErrProviderHasReplica,
-					resp,
-					"Provider already has proposed or active replica for %s according to all selected replication rules", pCid,
-				)
-			case countNoDataCap:
-				return retPayloadAnnotated(c, http.StatusForbidden,
-					apitypes.ErrTenantsOutOfDatacap,
-					resp,
-					"All selected tenants with claim to %s are out of DataCap 🙀", pCid,
-				)
-
-			case countOverReplicated:
-				return retPayloadAnnotated(c, http.StatusForbidden,
-					apitypes.ErrTooManyReplicas,
-					resp,
-					"Piece %s is over-replicated according to all selected replication rules", pCid,
-				)
-
-			case countOverPending:
-				return retPayloadAnnotated(c, http.StatusForbidden,
-					apitypes.ErrProviderAboveMaxInFlight,
-					resp,
-					"Provider has more proposals in-flight than permitted by selected tenant rules",
-				)
-
-			default:
-				return retPayloadAnnotated(c, http.StatusForbidden,
-					apitypes.ErrReplicationRulesViolation,
-					resp,
-					"None of the selected tenants would grant a deal for %s according to their individual rules", pCid,
-				)
-			}
+		var tm tenantReservationMeta
+		if err := json.Unmarshal(te.TenantMeta, &tm); err != nil {
+			return nil, pieceRequestOutcome{}, cmn.WrErr(err)
+		}
+		if !tm.permitsAuthRole(ctxMeta.authRole) {
+			countRoleRestricted++
+			invalidated = true
 		}
 
-		//
-		// Here, at the very end, is where we would make a tightly-timeboxed outbound call
-		// to check for potential external eligibility criteria
-		// Then either return ErrExternalReservationRefused or proceed below.
-		//
-		// We *DO* always check using our own replication rules first, and keep a lock for the duration
-		// ( in order to maintain a uniform "decency floor" among our esteemed SPs ;)
-		//
-
-		// We got that far - let's do it!
-		startEpoch := fil.WallTimeEpoch(time.Now().Add(
-			time.Hour * time.Duration(chosenTenant.StartWithinHours),
-		))
-		if chosenTenant.RecentlyUsedStartEpoch != nil {
-			startEpoch = filabi.ChainEpoch(*chosenTenant.RecentlyUsedStartEpoch)
+		if !invalidated {
+			ev.candidates = append(ev.candidates, reservationCandidate{te, tm})
 		}
+	}
 
-		// this is relatively expensive to do within the txn lock
-		// however we cache it and call it exactly once per day, so we should be fine
-		gbpce, err := providerCollateralEstimateGiB(
-			ctx,
-			// round the epoch down to a day boundary
-			// we *must* work with startEpoch to produce identical retry-deals
-			((startEpoch-
-				app.FilDefaultLookback-
-				(filbuiltin.EpochsInHour*
-					filabi.ChainEpoch(chosenTenant.StartWithinHours)))/
-				2880)*
-				2880,
+	if len(ev.candidates) > 0 {
+		return &ev, pieceRequestOutcome{}, nil
+	}
+
+	// handle "no takers"
+	// this is slightly convoluted since we can have a "mixed error condition" - handled in the default:
+	ret := pieceRequestOutcome{resp: &ev.resp}
+
+	switch len(tenantsEligible) {
+
+	case countAlreadyDealt:
+		ret.errCode = apitypes.ErrProviderHasReplica
+		ret.msg = fmt.Sprintf("Provider already has proposed or active replica for %s according to all selected replication rules", pCid)
+
+	case countNoDataCap:
+		ret.errCode = apitypes.ErrTenantsOutOfDatacap
+		ret.msg = fmt.Sprintf("All selected tenants with claim to %s are out of DataCap 🙀", pCid)
+
+	case countOverReplicated:
+		ret.errCode = apitypes.ErrTooManyReplicas
+		ret.msg = fmt.Sprintf("Piece %s is over-replicated according to all selected replication rules", pCid)
+
+	case countOverPending:
+		ret.errCode = apitypes.ErrProviderAboveMaxInFlight
+		ret.msg = "Provider has more proposals in-flight than permitted by selected tenant rules"
+
+	case countRoleRestricted:
+		ret.errCode = apitypes.ErrUnauthorizedAccess
+		ret.msg = fmt.Sprintf(
+			"All selected tenants with claim to %s require reservations to be authenticated by a different key than the %s key used",
+			pCid,
+			ctxMeta.authRole,
 		)
-		if err != nil {
-			return cmn.WrErr(err)
-		}
 
-		// // FIXME - use the long form client to match what lotus does ( drop when switching away )
-		// cl, err := filaddr.NewFromString(*chosenTenant.TenantClientAddress)
-		// if err != nil {
-		// 	return cmn.WrErr(err)
-		// }
+	default:
+		ret.errCode = apitypes.ErrReplicationRulesViolation
+		ret.msg = fmt.Sprintf("None of the selected tenants would grant a deal for %s according to their individual rules", pCid)
+	}
+
+	return nil, ret, nil
+}
+
+// consultReservationWebhooks makes a tightly-timeboxed outbound call to check for potential
+// external eligibility criteria of the candidate tenants that have them, in the order in which
+// requestPieceInTx would pick them. It must be invoked *before* taking requestPieceLockStatement:
+// the tenants are asked based on a lock-free evaluation, which requestPieceInTx then repeats
+// under the lock before accepting any of the verdicts.
+func consultReservationWebhooks(ctx context.Context, ctxMeta metaContext, pCid cid.Cid, tenantID int16) (map[int16]webhookVerdict, error) {
+	verdicts := make(map[int16]webhookVerdict)
+
+	ev, _, err := evaluatePieceRequest(ctx, ctxMeta.Db[app.DbMain], ctxMeta, pCid, tenantID)
+	if err != nil || ev == nil {
+		return verdicts, err
+	}
 
-		l := chosenTenant.ProposalLabel
-		if lc, err := cid.Parse(l); err == nil && lc.Version() == 1 {
-			l = lc.Encode(v1UrlEnc)
+	for _, cand := range ev.candidates {
+		te, tm := cand.tenantEligible, cand.tenantReservationMeta
+		if !tm.hasWebhook() {
+			// this tenant takes the deal unless things change: no need to ask anyone further down
+			break
 		}
-		encodedLabel, err := filmarket.NewLabelFromString(l)
+
+		approved, reason, err := consultReservationWebhook(ctx, *tm.Webhook, reservationWebhookRequest{
+			Timestamp:    time.Now(),
+			TenantID:     te.TenantID,
+			TenantClient: *te.TenantClient,
+			Provider: reservationWebhookProvider{
+				ProviderID:  ctxMeta.authedActorID.String(),
+				OrgID:       ctxMeta.spOrgID,
+				CityID:      ctxMeta.spCityID,
+				CountryID:   ctxMeta.spCountryID,
+				ContinentID: ctxMeta.spContinentID,
+			},
+			Piece: reservationWebhookPiece{
+				PieceCid:        pCid.String(),
+				PaddedPieceSize: te.PieceSizeBytes,
+				ProposalLabel:   te.ProposalLabel,
+			},
+			ReplicationState: te.TenantReplicationState,
+		})
 		if err != nil {
-			return cmn.WrErr(err)
+			ctxMeta.Logger.Warnw(
+				"tenant reservation webhook failed",
+				"tenant", te.TenantID,
+				"failOpen", tm.Webhook.FailOpen,
+				"error", err,
+			)
+			approved = tm.Webhook.FailOpen
+			reason = "unable to reach the tenant approval service"
+		}
+		if reason == "" {
+			reason = "no reason given"
 		}
 
-		prop := struct {
-			ProposalV0 filmarket.DealProposal `json:"filmarket_proposal"`
-		}{
-			ProposalV0: filmarket.DealProposal{
+		verdicts[te.TenantID] = webhookVerdict{approved: approved, reason: reason}
+		if approved {
+			break
+		}
+	}
+
+	return verdicts, nil
+}
 
-				// Label is a *completely* arbitrary, client-chosen nonce to apply to the deal, can be a UTF8-string or []bytes
-				// For the time being it is the v0/b32v1 cid of the "root" in question, obviously subject to change
-				// Current max-size is https://github.com/filecoin-project/go-state-types/blob/v0.9.9/builtin/v9/market/policy.go#L29-L30
-				Label: encodedLabel,
+// requestPieceInTx evaluates and, if permitted, queues a deal proposal for a single
+// piece. The caller is expected to hold requestPieceLockStatement within tx, and to
+// have obtained webhookVerdicts from consultReservationWebhooks before taking it. A
+// nil webhookVerdicts means the webhooks were not consulted at all.
+func requestPieceInTx(ctx context.Context, tx pgx.Tx, ctxMeta metaContext, pCid cid.Cid, tenantID int16, webhookVerdicts map[int16]webhookVerdict) (pieceRequestOutcome, error) {
 
-				// do not change under any circumstances: even when payments eventually happen, they will happen explicitly out of band
-				// ( a notable exception here would be contract-listener style interactions, but that's way off )
-				StoragePricePerEpoch: filbig.Zero(), // DO NOT CHANGE
+	ev, ret, err := evaluatePieceRequest(ctx, tx, ctxMeta, pCid, tenantID)
+	if err != nil || ev == nil {
+		return ret, err
+	}
+	tenantsEligible, resp := ev.tenantsEligible, ev.resp
+
+	//
+	// We *DO* always check using our own replication rules first, and keep a lock for the duration
+	// ( in order to maintain a uniform "decency floor" among our esteemed SPs ;)
+	// The external criteria were consulted before the lock was taken: we only act on
+	// verdicts of tenants that are still candidates now. The first one to agree gets the deal.
+	//
+	var chosenTenant *tenantEligible
+	var chosenMeta tenantReservationMeta
+	refusals := make([]string, 0, len(ev.candidates))
+	for _, cand := range ev.candidates {
+		te, tm := cand.tenantEligible, cand.tenantReservationMeta
+		if !tm.hasWebhook() {
+			chosenTenant, chosenMeta = te, tm
+			break
+		}
 
-				VerifiedDeal: true,
-				PieceCID:     pCid,
-				PieceSize:    filabi.PaddedPieceSize(chosenTenant.PieceSizeBytes),
+		v, wasAsked := webhookVerdicts[te.TenantID]
+		if !wasAsked {
+			v.reason = "eligibility changed while external approvals were being sought, please retry"
+			if webhookVerdicts == nil {
+				v.reason = "external approval was not sought within the time allotted to this request, please retry"
+			}
+		}
+		if v.approved {
+			chosenTenant, chosenMeta = te, tm
+			break
+		}
+		refusals = append(refusals, fmt.Sprintf("Tenant %d: %s", te.TenantID, v.reason))
+	}
+	if chosenTenant == nil {
+		return pieceRequestOutcome{
+			errCode: apitypes.ErrExternalReservationRefused,
+			msg: fmt.Sprintf(
+				"Reservation of %s refused by external criteria of all otherwise-eligible tenants:\n%s",
+				pCid,
+				strings.Join(refusals, "\n"),
+			),
+			resp: &resp,
+		}, nil
+	}
+
+	// We got that far - let's do it!
+	startEpoch := fil.WallTimeEpoch(time.Now().Add(
+		time.Hour * time.Duration(chosenTenant.StartWithinHours),
+	))
+	if chosenTenant.RecentlyUsedStartEpoch != nil {
+		startEpoch = filabi.ChainEpoch(*chosenTenant.RecentlyUsedStartEpoch)
+	}
 
-				Provider: ctxMeta.authedActorID.AsFilAddr(),
-				Client:   chosenTenant.TenantClientID.AsFilAddr(),
+	var prop any
+	endEpoch := startEpoch + filabi.ChainEpoch(chosenTenant.DealDurationDays)*filbuiltin.EpochsInDay
 
-				StartEpoch: startEpoch,
-				EndEpoch:   startEpoch + filabi.ChainEpoch(chosenTenant.DealDurationDays)*filbuiltin.EpochsInDay,
+	switch chosenMeta.Onboarding {
+	case app.OnboardingDDO:
+		// the allocation must be claimed by the start epoch, and the registry caps how far out that can be
+		expiration := startEpoch
+		if maxExp := fil.WallTimeEpoch(time.Now()) + filverifreg.MaximumVerifiedAllocationExpiration; expiration > maxExp {
+			expiration = maxExp
+		}
+		termMin, termMax := app.AllocationTerms(endEpoch - startEpoch)
 
-				ClientCollateral: filbig.Zero(),
-				ProviderCollateral: filbig.Rsh(
-					filbig.Mul(gbpce, filbig.NewInt(chosenTenant.PieceSizeBytes)),
-					30,
-				),
+		startEpoch = expiration
+		endEpoch = expiration + termMin
+		prop = struct {
+			Allocation filverifreg.AllocationRequest `json:"allocation_request"`
+		}{
+			Allocation: filverifreg.AllocationRequest{
+				Provider:   filabi.ActorID(ctxMeta.authedActorID),
+				Data:       pCid,
+				Size:       filabi.PaddedPieceSize(chosenTenant.PieceSizeBytes),
+				TermMin:    termMin,
+				TermMax:    termMax,
+				Expiration: expiration,
 			},
 		}
 
-		if _, err := tx.Exec(
+	case "", app.OnboardingMarket:
+		var err error
+		if prop, err = marketProposal(
 			ctx,
-			`
-			INSERT INTO spd.proposals
-				( piece_id, provider_id, client_id, start_epoch, end_epoch, proxied_log2_size, proposal_meta )
-			VALUES ( $1, $2, $3, $4, $5, $6, $7 )
-			`,
-			chosenTenant.PieceID,
 			ctxMeta.authedActorID,
 			*chosenTenant.TenantClientID,
-			prop.ProposalV0.StartEpoch,
-			prop.ProposalV0.EndEpoch,
-			bits.TrailingZeros64(uint64(chosenTenant.PieceSizeBytes)),
-			prop,
+			pCid,
+			chosenTenant.PieceSizeBytes,
+			chosenTenant.ProposalLabel,
+			chosenTenant.StartWithinHours,
+			startEpoch,
+			endEpoch,
 		); err != nil {
-			return cmn.WrErr(err)
+			return pieceRequestOutcome{}, cmn.WrErr(err)
 		}
 
-		// we managed - bump the counts where applicable and return stats
-		for i := range tenantsEligible {
-			if tenantsEligible[i].IsExclusive && resp.ReplicationStates[i].TenantID != chosenTenant.TenantID {
-				continue
-			}
+	default:
+		return pieceRequestOutcome{}, xerrors.Errorf("tenant %d has unknown onboarding mode '%s'", chosenTenant.TenantID, chosenMeta.Onboarding)
+	}
 
-			resp.ReplicationStates[i].Total++
-			resp.ReplicationStates[i].InOrg++
-			resp.ReplicationStates[i].InCity++
-			resp.ReplicationStates[i].InCountry++
-			resp.ReplicationStates[i].InContinent++
-			resp.ReplicationStates[i].DealAlreadyExists = true
-			resp.ReplicationStates[i].SpInFlightBytes += chosenTenant.PieceSizeBytes
+	if _, err := tx.Exec(
+		ctx,
+		`
+		INSERT INTO spd.proposals
+			( piece_id, provider_id, client_id, start_epoch, end_epoch, proxied_log2_size, proposal_meta )
+		VALUES ( $1, $2, $3, $4, $5, $6, $7 )
+		`,
+		chosenTenant.PieceID,
+		ctxMeta.authedActorID,
+		*chosenTenant.TenantClientID,
+		startEpoch,
+		endEpoch,
+		bits.TrailingZeros64(uint64(chosenTenant.PieceSizeBytes)),
+		prop,
+	); err != nil {
+		return pieceRequestOutcome{}, cmn.WrErr(err)
+	}
+
+	// we managed - bump the counts where applicable and return stats
+	for i := range tenantsEligible {
+		if tenantsEligible[i].IsExclusive && resp.ReplicationStates[i].TenantID != chosenTenant.TenantID {
+			continue
 		}
 
-		return retPayloadAnnotated(
-			c,
-			http.StatusOK,
-			0,
-			resp,
-			strings.Join([]string{
-				fmt.Sprintf("Deal queued for PieceCID %s", pCid),
-				``,
-				`In about 5 minutes check the pending list:`,
-				" " + curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/pending_proposals"),
-			}, "\n"),
-		)
-	})
+		resp.ReplicationStates[i].Total++
+		resp.ReplicationStates[i].InOrg++
+		resp.ReplicationStates[i].InCity++
+		resp.ReplicationStates[i].InCountry++
+		resp.ReplicationStates[i].InContinent++
+		resp.ReplicationStates[i].DealAlreadyExists = true
+		resp.ReplicationStates[i].SpInFlightBytes += chosenTenant.PieceSizeBytes
+	}
+
+	se := int64(startEpoch)
+	st := fil.MainnetTime(startEpoch)
+	resp.DealStartEpoch = &se
+	resp.DealStartTime = &st
+
+	return pieceRequestOutcome{
+		resp: &resp,
+		msg:  fmt.Sprintf("Deal queued for PieceCID %s", pCid),
+	}, nil
+}
+
+// marketProposal returns the proposal_meta of a builtin market deal
+func marketProposal(ctx context.Context, sp, client fil.ActorID, pCid cid.Cid, pieceSizeBytes int64, label string, startWithinHours int16, startEpoch, endEpoch filabi.ChainEpoch) (any, error) {
+
+	// this is relatively expensive to do within the txn lock
+	// however we cache it and call it exactly once per day, so we should be fine
+	gbpce, err := providerCollateralEstimateGiB(
+		ctx,
+		// round the epoch down to a day boundary
+		// we *must* work with startEpoch to produce identical retry-deals
+		((startEpoch-
+			app.FilDefaultLookback-
+			(filbuiltin.EpochsInHour*
+				filabi.ChainEpoch(startWithinHours)))/
+			2880)*
+			2880,
+	)
+	if err != nil {
+		return nil, cmn.WrErr(err)
+	}
+
+	// // FIXME - use the long form client to match what lotus does ( drop when switching away )
+	// cl, err := filaddr.NewFromString(*chosenTenant.TenantClientAddress)
+	// if err != nil {
+	// 	return cmn.WrErr(err)
+	// }
+
+	l := label
+	if lc, err := cid.Parse(l); err == nil && lc.Version() == 1 {
+		l = lc.Encode(v1UrlEnc)
+	}
+	encodedLabel, err := filmarket.NewLabelFromString(l)
+	if err != nil {
+		return nil, cmn.WrErr(err)
+	}
+
+	return struct {
+		ProposalV0 filmarket.DealProposal `json:"filmarket_proposal"`
+	}{
+		ProposalV0: filmarket.DealProposal{
+
+			// Label is a *completely* arbitrary, client-chosen nonce to apply to the deal, can be a UTF8-string or []bytes
+			// For the time being it is the v0/b32v1 cid of the "root" in question, obviously subject to change
+			// Current max-size is https://github.com/filecoin-project/go-state-types/blob/v0.9.9/builtin/v9/market/policy.go#L29-L30
+			Label: encodedLabel,
+
+			// do not change under any circumstances: even when payments eventually happen, they will happen explicitly out of band
+			// ( a notable exception here would be contract-listener style interactions, but that's way off )
+			StoragePricePerEpoch: filbig.Zero(), // DO NOT CHANGE
+
+			VerifiedDeal: true,
+			PieceCID:     pCid,
+			PieceSize:    filabi.PaddedPieceSize(pieceSizeBytes),
+
+			Provider: sp.AsFilAddr(),
+			Client:   client.AsFilAddr(),
+
+			StartEpoch: startEpoch,
+			EndEpoch:   endEpoch,
+
+			ClientCollateral: filbig.Zero(),
+			ProviderCollateral: filbig.Rsh(
+				filbig.Mul(gbpce, filbig.NewInt(pieceSizeBytes)),
+				30,
+			),
+		},
+	}, nil
 }
 
 var collateralCache, _ = lru.New[filabi.ChainEpoch, filbig.Int](128)
@@ -382,6 +644,7 @@ func providerCollateralEstimateGiB(ctx context.Context, sourceEpoch filabi.Chain
 		return pc, nil
 	}
 
+	// gateways do not serve collateral bounds at arbitrary past epochs
 	lapi := app.GetGlobalCtx(ctx).LotusAPI[app.FilHeavy]
 
 	ts, err := lapi.ChainGetTipSetByHeight(ctx, sourceEpoch, lotustypes.EmptyTSK)
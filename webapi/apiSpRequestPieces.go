@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type spPieceRequestResult struct {
+	PieceCID string `json:"piece_cid"`
+	ErrCode  int    `json:"error_code,omitempty"`
+	ErrSlug  string `json:"error_slug,omitempty"`
+	Message  string `json:"message"`
+	*apitypes.ResponseDealRequest
+}
+
+func apiSpRequestPieces(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	var pcidStrs []string
+	if errStr := decodeBatch(c, &pcidStrs, requestPiecesMaxBatch); errStr != "" {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", errStr)
+	}
+
+	pCids := make([]cid.Cid, 0, len(pcidStrs))
+	problems := make([]string, 0)
+	for i, s := range pcidStrs {
+		pCid, err := parsePieceCID(s)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("entry #%d: PieceCID '%s' is not valid: %s", i, s, err))
+			continue
+		}
+		pCids = append(pCids, pCid)
+	}
+	if len(problems) > 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", strings.Join(problems, "\n"))
+	}
+
+	tenantID := int16(0) // 0 == any
+	if c.QueryParams().Has("tenant") {
+		tid, err := parseUIntQueryParam(c, "tenant", 1, 1<<15)
+		if err != nil {
+			return retFail(c, apitypes.ErrInvalidRequest, err.Error())
+		}
+		tenantID = int16(tid)
+	}
+
+	if errCode, msg, err := spProposalsPrecheck(c); err != nil {
+		return cmn.WrErr(err)
+	} else if errCode != 0 {
+		return retFail(c, errCode, "%s", msg)
+	}
+
+	// outbound calls happen before the lock is taken, see consultReservationWebhooks
+	// pieces not reached within the budget are evaluated with no webhook verdicts at all
+	webhookVerdicts := make([]map[int16]webhookVerdict, len(pCids))
+	webhooksDeadline := time.Now().Add(requestPiecesWebhooksBudget)
+	for i, pCid := range pCids {
+		if time.Now().After(webhooksDeadline) {
+			break
+		}
+		v, err := consultReservationWebhooks(ctx, ctxMeta, pCid, tenantID)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		webhookVerdicts[i] = v
+	}
+
+	ret := make([]spPieceRequestResult, len(pCids))
+	var countQueued int
+
+	// everything happens within a single lock/transaction: in-flight counts
+	// observed by each subsequent piece include the proposals queued before it
+	if err := ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+
+		if _, err := tx.Exec(
+			ctx,
+			requestPieceLockStatement,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		for i, pCid := range pCids {
+			res, err := requestPieceInTx(ctx, tx, ctxMeta, pCid, tenantID, webhookVerdicts[i])
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+
+			ret[i] = spPieceRequestResult{
+				PieceCID:            pCid.String(),
+				Message:             res.msg,
+				ResponseDealRequest: res.resp,
+			}
+			if res.errCode != 0 {
+				ret[i].ErrCode = int(res.errCode)
+				ret[i].ErrSlug = errSlug(res.errCode)
+			} else {
+				countQueued++
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	msg := []string{fmt.Sprintf("Deals queued for %d out of %d requested PieceCIDs", countQueued, len(pCids))}
+	if countQueued > 0 {
+		msg = append(msg,
+			``,
+			`In about 5 minutes check the pending list:`,
+			" "+curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/pending_proposals"),
+		)
+	}
+
+	return retPayloadAnnotated(c, http.StatusOK, 0, ret, strings.Join(msg, "\n"))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type spSession struct {
+	SessionID string     `json:"session_id"`
+	Scope     string     `json:"scope"              db:"session_scope"`
+	AuthRole  string     `json:"auth_role"`
+	Label     *string    `json:"label,omitempty"    db:"session_label"`
+	Created   time.Time  `json:"created"            db:"entry_created"`
+	Expires   time.Time  `json:"expires"            db:"session_expires"`
+	LastUsed  *time.Time `json:"last_used,omitempty" db:"session_last_used"`
+	Revoked   *time.Time `json:"revoked,omitempty"  db:"session_revoked"`
+}
+
+type spSessionIssued struct {
+	spSession
+	Token string `json:"token"`
+}
+
+func apiSpCreateSession(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	ttlHours := uint64(sessionDefaultTTLHours)
+	if c.QueryParams().Has("ttl-hours") {
+		var err error
+		if ttlHours, err = parseUIntQueryParam(c, "ttl-hours", 1, sessionMaxTTLHours); err != nil {
+			return retFail(c, apitypes.ErrInvalidRequest, "%s", err.Error())
+		}
+	}
+
+	scope := sessionScopeRead
+	if c.QueryParams().Has("scope") {
+		scope = c.QueryParam("scope")
+		if scope != sessionScopeRead && scope != sessionScopeReserve {
+			return retFail(c, apitypes.ErrInvalidRequest, "unknown session scope '%s', expected either '%s' or '%s'", scope, sessionScopeRead, sessionScopeReserve)
+		}
+	}
+
+	var label *string
+	if l := c.QueryParam("label"); l != "" {
+		label = &l
+	}
+
+	token, digest, err := newSessionToken()
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	ret := spSessionIssued{Token: token}
+	if err := pgxscan.Get(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret.spSession,
+		`
+		INSERT INTO spd.sp_sessions
+			( provider_id, token_digest, session_scope, session_label, issuing_request_uuid, auth_role, session_expires )
+		VALUES ( $1, $2, $3, $4, $5, $6, NOW() + $7::INTEGER * '1 hour'::INTERVAL )
+		RETURNING session_id, session_scope, auth_role, session_label, entry_created, session_expires, session_last_used, session_revoked
+		`,
+		ctxMeta.authedActorID,
+		digest,
+		scope,
+		label,
+		c.Request().Header.Get("X-SPADE-REQUEST-UUID"),
+		ctxMeta.authRole,
+		ttlHours,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		strings.Join([]string{
+			"Issued session %s with scope '%s', valid until %s",
+			"",
+			"The token is displayed only once and can not be recovered: store it securely.",
+			"Use it as an 'Authorization: %s {{ token }}' header with any /sp/ route, except for issuing further sessions.",
+		}, "\n"),
+		ret.SessionID,
+		ret.Scope,
+		ret.Expires.Format(time.RFC3339),
+		bearerAuthScheme,
+	)
+}
+
+func apiSpListSessions(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	ret := make([]spSession, 0, 8)
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret,
+		`
+		SELECT
+				session_id,
+				session_scope,
+				auth_role,
+				session_label,
+				entry_created,
+				session_expires,
+				session_last_used,
+				session_revoked
+			FROM spd.sp_sessions
+		WHERE
+			provider_id = $1
+				AND
+			-- also show what expired in the past N hours
+			session_expires > NOW() - $2::INTEGER * '1 hour'::INTERVAL
+		ORDER BY entry_created DESC
+		`,
+		ctxMeta.authedActorID,
+		showRecentFailuresHours,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		"List of current and recently expired sessions of SP %s",
+		ctxMeta.authedActorID,
+	)
+}
+
+func apiSpRevokeSession(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	sessionID := strings.ToLower(c.Param("sessionID"))
+	if !uuidRe.MatchString(sessionID) {
+		return retFail(c, apitypes.ErrInvalidRequest, "Provided SessionID '%s' is not valid", c.Param("sessionID"))
+	}
+
+	var ret []spSession
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret,
+		`
+		UPDATE spd.sp_sessions SET
+			session_revoked = COALESCE( session_revoked, NOW() )
+		WHERE
+			provider_id = $1
+				AND
+			session_id = $2
+		RETURNING session_id, session_scope, auth_role, session_label, entry_created, session_expires, session_last_used, session_revoked
+		`,
+		ctxMeta.authedActorID,
+		sessionID,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+	if len(ret) == 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "No session %s found for SP %s", sessionID, ctxMeta.authedActorID)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret[0],
+		"Session %s is revoked",
+		sessionID,
+	)
+}
@@ -1,21 +1,166 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
 	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
 	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/filtypes"
 )
 
+type spStatus struct {
+	ProviderID string `json:"provider_id"`
+	AuthRole   string `json:"auth_role"` // which of the SP keys authenticated this request
+
+	InfoLastPolled *time.Time      `json:"info_last_polled"`
+	InfoIsStale    bool            `json:"info_is_stale"`
+	Info           apitypes.SPInfo `json:"info"`
+
+	CanReceiveProposals bool   `json:"can_receive_proposals"`
+	IneligibleErrCode   int    `json:"ineligible_error_code,omitempty"`
+	IneligibleErrSlug   string `json:"ineligible_error_slug,omitempty"`
+
+	Tenants []spTenantStatus `json:"tenants"`
+}
+
+type spTenantStatus struct {
+	TenantID   int16  `json:"tenant_id"`
+	TenantName string `json:"tenant_name"`
+
+	Inactivated    bool   `json:"inactivated"`
+	MaxInFlightGiB *int64 `json:"max_in_flight_GiB_override,omitempty" db:"max_in_flight_gib"`
+
+	MaxInFlightBytes  int64 `json:"max_in_flight_bytes"`
+	InFlightBytes     int64 `json:"actual_in_flight_bytes"`
+	InFlightProposals int64 `json:"actual_in_flight_proposals"`
+}
+
 func apiSpStatus(c echo.Context) error {
-	_, ctxMeta := unpackAuthedEchoContext(c)
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	ret := spStatus{
+		ProviderID:     ctxMeta.authedActorID.String(),
+		AuthRole:       ctxMeta.authRole,
+		InfoLastPolled: ctxMeta.spInfoLastPolled,
+		Info:           ctxMeta.spInfo,
+		InfoIsStale: ctxMeta.spInfoLastPolled == nil ||
+			ctxMeta.spInfoLastPolled.Before(time.Now().Add(-1*app.PolledSPInfoStaleAfterMinutes*time.Minute)),
+		Tenants: make([]spTenantStatus, 0, 8),
+	}
 
-	return retFail(
-		c,
-		apitypes.ErrSystemTemporarilyDisabled,
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret.Tenants,
 		`
-                                            !!! COMING SOON !!!
+		SELECT
+				t.tenant_id,
+				t.tenant_name,
+				COALESCE( ( tp.tenant_provider_meta->'inactivated' )::BOOL, false ) AS inactivated,
+				( tp.tenant_provider_meta->'max_in_flight_GiB' )::BIGINT AS max_in_flight_gib,
+				COALESCE( (tp.tenant_provider_meta->'max_in_flight_GiB')::BIGINT,  (t.tenant_meta->'max'->'default_in_flight_GiB')::BIGINT, 1024 )::BIGINT << 30 AS max_in_flight_bytes,
+				COALESCE(
+					(
+						SELECT SUM( 1::BIGINT << pr.proxied_log2_size )
+							FROM spd.proposals pr
+						WHERE
+							pr.provider_id = tp.provider_id
+								AND
+							pr.proposal_failstamp = 0
+								AND
+							pr.activated_deal_id IS NULL
+								AND
+							pr.activated_claim_id IS NULL
+								AND
+							pr.client_id IN ( SELECT client_id FROM spd.clients c WHERE c.tenant_id = t.tenant_id )
+					)::BIGINT,
+					0::BIGINT
+				) AS in_flight_bytes,
+				(
+					SELECT COUNT(*)
+						FROM spd.proposals pr
+					WHERE
+						pr.provider_id = tp.provider_id
+							AND
+						pr.proposal_failstamp = 0
+							AND
+						pr.activated_deal_id IS NULL
+							AND
+						pr.activated_claim_id IS NULL
+							AND
+						pr.client_id IN ( SELECT client_id FROM spd.clients c WHERE c.tenant_id = t.tenant_id )
+				) AS in_flight_proposals
+			FROM spd.tenants_providers tp
+			JOIN spd.tenants t USING ( tenant_id )
+		WHERE
+			tp.provider_id = $1
+		ORDER BY t.tenant_id
+		`,
+		ctxMeta.authedActorID,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	errCode, err := spIneligibleErr(ctx, ctxMeta.authedActorID)
+	if err != nil {
+		return cmn.WrErr(err)
+	} else if errCode != 0 {
+		ret.IneligibleErrCode = int(errCode)
+		ret.IneligibleErrSlug = errSlug(errCode)
+	}
+
+	// assemble a list of everything that stands in the way of receiving a proposal
+	// the order mirrors the checks within apiSpRequestPiece
+	problems := make([]string, 0, 8)
+	if ret.InfoIsStale {
+		problems = append(problems, "Provider has not been dialed by the polling system recently: please try again in about a minute")
+	}
+	if ctxMeta.spInfo.PeerInfo == nil || len(ctxMeta.spInfo.PeerInfo.Protos) == 0 {
+		problems = append(problems, "Provider can not be libp2p-dialed over the TCP transport, see info.errors below for details")
+	} else if _, canV120 := ctxMeta.spInfo.PeerInfo.Protos[filtypes.StorageProposalV120]; !canV120 {
+		problems = append(problems, fmt.Sprintf("Provider does not support %s: you must upgrade to Boost v1.5.1 or equivalent", filtypes.StorageProposalV120))
+	}
+	if errCode != 0 {
+		problems = append(problems, fmt.Sprintf("Provider is currently not eligible to use this API ( %s )", errSlug(errCode)))
+	}
+	activeTenants := 0
+	for _, t := range ret.Tenants {
+		if !t.Inactivated {
+			activeTenants++
+		}
+	}
+	if activeTenants == 0 {
+		problems = append(problems, "Provider is not enrolled with any active tenant: register your SP in accordance with each individual tenant")
+	}
+	ret.CanReceiveProposals = len(problems) == 0
+
+	msg := []string{
+		fmt.Sprintf("Current state of Storage Provider %s", ctxMeta.authedActorID),
+		``,
+	}
+	if ret.CanReceiveProposals {
+		msg = append(msg,
+			`Everything appears to be in order: your provider can receive deal proposals.`,
+			`In order to see what you can request, invoke:`,
+			" "+curlAuthedForSP(c, ctxMeta.authedActorID, "/sp/eligible_pieces"),
+		)
+	} else {
+		msg = append(msg, `The following currently prevents your provider from receiving deal proposals:`)
+		for _, p := range problems {
+			msg = append(msg, " - "+p)
+		}
+		msg = append(msg,
+			``,
+			`If the problem persists, or you believe this is a spurious error: please contact the API`,
+			`administrators in #spade over at the Fil Slack https://filecoin.io/slack`,
+		)
+	}
 
-This area will contain various information regarding the system and the current state of Storage Provider %s
-    `,
-		ctxMeta.authedActorID.String(),
-	)
+	return retPayloadAnnotated(c, http.StatusOK, 0, ret, strings.Join(msg, "\n"))
 }
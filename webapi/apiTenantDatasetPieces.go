@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
+)
+
+type tenantPieceSpec struct {
+	PieceCID        string `json:"piece_cid"`
+	PaddedPieceSize int64  `json:"padded_piece_size"`
+	PayloadCID      string `json:"payload_cid,omitempty"` // optional, used as the deal proposal label
+
+	// optional, when present replaces all HTTP(S) sources of the piece within this dataset
+	HTTPURLs *[]string `json:"http_urls,omitempty"`
+}
+
+type tenantPiecesAdded struct {
+	PiecesSubmitted   int `json:"pieces_submitted"`
+	PiecesNewToSystem int `json:"pieces_new_to_system"`
+	PiecesAdded       int `json:"pieces_added_to_dataset"`
+	PiecesHTTPUpdated int `json:"pieces_with_updated_http_urls"`
+}
+
+type tenantPiecesRemoved struct {
+	PiecesSubmitted int      `json:"pieces_submitted"`
+	PiecesRemoved   int      `json:"pieces_removed_from_dataset"`
+	PiecesNotFound  []string `json:"pieces_not_in_dataset"`
+}
+
+var errTenantBatchRejected = xerrors.New("batch rejected")
+
+func apiTenantAddPieces(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	dsID, err := tenantDatasetID(ctx, c)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	if dsID == 0 {
+		return retUnknownDataset(c)
+	}
+
+	var specs []tenantPieceSpec
+	if errStr := decodeBatch(c, &specs, tenantPiecesMaxBatch); errStr != "" {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", errStr)
+	}
+
+	type validPiece struct {
+		pieceCID  string
+		log2Size  int
+		label     *string
+		httpURLs  *[]string
+		submitIdx int
+	}
+	pieces := make([]validPiece, 0, len(specs))
+	seen := make(map[string]struct{}, len(specs))
+	problems := make([]string, 0)
+	for i, s := range specs {
+		pCid, err := parsePieceCID(s.PieceCID)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("entry #%d: PieceCID '%s' is not valid: %s", i, s.PieceCID, err))
+			continue
+		}
+		if s.PaddedPieceSize < 128 || bits.OnesCount64(uint64(s.PaddedPieceSize)) != 1 {
+			problems = append(problems, fmt.Sprintf("entry #%d: padded_piece_size %d is not a power of 2 of at least 128 bytes", i, s.PaddedPieceSize))
+			continue
+		}
+		if _, dup := seen[pCid.String()]; dup {
+			problems = append(problems, fmt.Sprintf("entry #%d: PieceCID %s is listed more than once", i, pCid))
+			continue
+		}
+		seen[pCid.String()] = struct{}{}
+
+		vp := validPiece{
+			pieceCID:  pCid.String(),
+			log2Size:  bits.TrailingZeros64(uint64(s.PaddedPieceSize)),
+			submitIdx: i,
+		}
+		if s.PayloadCID != "" {
+			lCid, err := cid.Parse(s.PayloadCID)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("entry #%d: PayloadCID '%s' is not valid: %s", i, s.PayloadCID, err))
+				continue
+			}
+			l := lCid.String()
+			vp.label = &l
+		}
+		if s.HTTPURLs != nil {
+			if len(*s.HTTPURLs) > httpSourcesMaxPerPiece {
+				problems = append(problems, fmt.Sprintf("entry #%d: %d http_urls specified, more than the maximum of %d", i, len(*s.HTTPURLs), httpSourcesMaxPerPiece))
+				continue
+			}
+			var invalid bool
+			for _, u := range *s.HTTPURLs {
+				if err := validateHTTPSourceURL(u); err != nil {
+					problems = append(problems, fmt.Sprintf("entry #%d: http_url '%s' is not valid: %s", i, u, err))
+					invalid = true
+				}
+			}
+			if invalid {
+				continue
+			}
+			vp.httpURLs = s.HTTPURLs
+		}
+		pieces = append(pieces, vp)
+	}
+	if len(problems) > 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", strings.Join(problems, "\n"))
+	}
+
+	ret := tenantPiecesAdded{PiecesSubmitted: len(specs)}
+	err = ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+
+		if _, err := tx.Exec(ctx, tenantPiecesLockStatement); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		for _, p := range pieces {
+			var pieceID int64
+			var isNew bool
+			var knownLog2Size int
+			if err := tx.QueryRow(
+				ctx,
+				`
+				WITH
+					ins AS (
+						INSERT INTO spd.pieces ( piece_cid, piece_log2_size, proposal_label )
+							VALUES ( $1, $2, $3 )
+						ON CONFLICT ( piece_cid ) DO NOTHING
+						RETURNING piece_id, piece_log2_size
+					)
+				SELECT piece_id, true, piece_log2_size FROM ins
+					UNION ALL
+				SELECT piece_id, false, piece_log2_size FROM spd.pieces WHERE piece_cid = $1
+				`,
+				p.pieceCID,
+				p.log2Size,
+				p.label,
+			).Scan(&pieceID, &isNew, &knownLog2Size); err != nil {
+				return cmn.WrErr(err)
+			}
+
+			if knownLog2Size != p.log2Size {
+				problems = append(problems, fmt.Sprintf(
+					"entry #%d: PieceCID %s is already known with a padded_piece_size of %d, not %d",
+					p.submitIdx, p.pieceCID, int64(1)<<knownLog2Size, int64(1)<<p.log2Size,
+				))
+				continue
+			}
+			if isNew {
+				ret.PiecesNewToSystem++
+			}
+
+			t, err := tx.Exec(
+				ctx,
+				`
+				INSERT INTO spd.datasets_pieces ( piece_id, dataset_id )
+					VALUES ( $1, $2 )
+				ON CONFLICT DO NOTHING
+				`,
+				pieceID,
+				dsID,
+			)
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+			ret.PiecesAdded += int(t.RowsAffected())
+
+			if p.httpURLs != nil {
+				if _, err := tx.Exec(
+					ctx,
+					`
+					DELETE FROM spd.datasets_pieces_http_sources
+					WHERE
+						piece_id = $1
+							AND
+						dataset_id = $2
+					`,
+					pieceID,
+					dsID,
+				); err != nil {
+					return cmn.WrErr(err)
+				}
+				if _, err := tx.Exec(
+					ctx,
+					`
+					INSERT INTO spd.datasets_pieces_http_sources ( piece_id, dataset_id, source_url )
+						SELECT $1, $2, UNNEST( $3::TEXT[] )
+					ON CONFLICT DO NOTHING
+					`,
+					pieceID,
+					dsID,
+					*p.httpURLs,
+				); err != nil {
+					return cmn.WrErr(err)
+				}
+				ret.PiecesHTTPUpdated++
+			}
+		}
+
+		if len(problems) > 0 {
+			return errTenantBatchRejected // do not commit any part of a batch with errors
+		}
+		return nil
+	})
+	if err == errTenantBatchRejected {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", strings.Join(problems, "\n"))
+	} else if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		strings.Join([]string{
+			"Added %d new pieces to dataset '%s'.",
+			"Newly added pieces become eligible for replication after the next periodic availability refresh ( within several minutes ).",
+			"Updated HTTP sources of %d pieces: changes become visible to SPs after the same refresh.",
+		}, "\n"),
+		ret.PiecesAdded,
+		c.Param("datasetSlug"),
+		ret.PiecesHTTPUpdated,
+	)
+}
+
+func apiTenantRemovePieces(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	dsID, err := tenantDatasetID(ctx, c)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	if dsID == 0 {
+		return retUnknownDataset(c)
+	}
+
+	var pcidStrs []string
+	if errStr := decodeBatch(c, &pcidStrs, tenantPiecesMaxBatch); errStr != "" {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", errStr)
+	}
+
+	pcids := make([]string, 0, len(pcidStrs))
+	problems := make([]string, 0)
+	for i, s := range pcidStrs {
+		pCid, err := parsePieceCID(s)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("entry #%d: PieceCID '%s' is not valid: %s", i, s, err))
+			continue
+		}
+		pcids = append(pcids, pCid.String())
+	}
+	if len(problems) > 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", strings.Join(problems, "\n"))
+	}
+
+	ret := tenantPiecesRemoved{
+		PiecesSubmitted: len(pcidStrs),
+		PiecesNotFound:  make([]string, 0),
+	}
+	err = ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+
+		if _, err := tx.Exec(ctx, tenantPiecesLockStatement); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		// pieces themselves are never removed: they may be referenced by deals/proposals
+		rows, err := tx.Query(
+			ctx,
+			`
+			DELETE FROM spd.datasets_pieces dp
+				USING spd.pieces p
+			WHERE
+				dp.piece_id = p.piece_id
+					AND
+				dp.dataset_id = $1
+					AND
+				p.piece_cid = ANY( $2 )
+			RETURNING p.piece_cid
+			`,
+			dsID,
+			pcids,
+		)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		removed := make(map[string]struct{}, len(pcids))
+		for rows.Next() {
+			var pc string
+			if err := rows.Scan(&pc); err != nil {
+				rows.Close()
+				return cmn.WrErr(err)
+			}
+			removed[pc] = struct{}{}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		ret.PiecesRemoved = len(removed)
+		for _, pc := range pcids {
+			if _, found := removed[pc]; !found {
+				ret.PiecesNotFound = append(ret.PiecesNotFound, pc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		strings.Join([]string{
+			"Removed %d pieces from dataset '%s'.",
+			"Outstanding deal proposals for removed pieces are not affected.",
+			"Any HTTP sources registered for removed pieces within this dataset are removed as well.",
+		}, "\n"),
+		ret.PiecesRemoved,
+		c.Param("datasetSlug"),
+	)
+}
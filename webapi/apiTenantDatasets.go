@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type tenantDataset struct {
+	DatasetID   int16  `json:"dataset_id"`
+	DatasetSlug string `json:"dataset_slug"`
+	PieceCount  int64  `json:"piece_count"`
+	TotalBytes  int64  `json:"total_bytes"`
+
+	HTTPTemplates []string `json:"http_url_templates"`
+}
+
+func apiTenantListDatasets(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	ret := make([]tenantDataset, 0, 32)
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret,
+		`
+		SELECT
+				d.dataset_id,
+				d.dataset_slug,
+				COUNT( p.piece_id ) AS piece_count,
+				COALESCE( SUM( 1::BIGINT << p.piece_log2_size ), 0 )::BIGINT AS total_bytes,
+				ARRAY(
+					SELECT dht.url_template
+						FROM spd.datasets_http_templates dht
+					WHERE dht.dataset_id = d.dataset_id
+					ORDER BY dht.url_template
+				) AS http_templates
+			FROM spd.tenants_datasets td
+			JOIN spd.datasets d USING ( dataset_id )
+			LEFT JOIN spd.datasets_pieces dp USING ( dataset_id )
+			LEFT JOIN spd.pieces p USING ( piece_id )
+		WHERE
+			td.tenant_id = $1
+		GROUP BY d.dataset_id, d.dataset_slug
+		ORDER BY d.dataset_slug
+		`,
+		ctxMeta.authedTenantID,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		"List of datasets associated with tenant %d",
+		ctxMeta.authedTenantID,
+	)
+}
+
+// tenantDatasetID returns the DatasetID of the :datasetSlug route parameter,
+// or 0 if the dataset does not exist or is not associated with the current tenant
+func tenantDatasetID(ctx context.Context, c echo.Context) (int16, error) {
+	_, ctxMeta := unpackAuthedEchoContext(c)
+
+	var dsID int16
+	err := ctxMeta.Db[app.DbMain].QueryRow(
+		ctx,
+		`
+		SELECT d.dataset_id
+			FROM spd.datasets d
+			JOIN spd.tenants_datasets td USING ( dataset_id )
+		WHERE
+			td.tenant_id = $1
+				AND
+			d.dataset_slug = $2
+		`,
+		ctxMeta.authedTenantID,
+		c.Param("datasetSlug"),
+	).Scan(&dsID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, cmn.WrErr(err)
+	}
+	return dsID, nil
+}
+
+func retUnknownDataset(c echo.Context) error {
+	_, ctxMeta := unpackAuthedEchoContext(c)
+	return retFail(
+		c,
+		apitypes.ErrInvalidRequest,
+		"Dataset '%s' does not exist or is not associated with tenant %d",
+		c.Param("datasetSlug"),
+		ctxMeta.authedTenantID,
+	)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+const (
+	tmplPlaceholderPieceCID   = "{piece_cid}"
+	tmplPlaceholderPayloadCID = "{payload_cid}"
+)
+
+func apiTenantSetHTTPTemplates(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	dsID, err := tenantDatasetID(ctx, c)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	if dsID == 0 {
+		return retUnknownDataset(c)
+	}
+
+	// not using decodeBatch(): an empty list is valid, and clears all templates
+	var tmpls []string
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&tmpls); err != nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "unable to decode request body: %s", err)
+	}
+	if tmpls == nil {
+		return retFail(c, apitypes.ErrInvalidRequest, "request body must be a JSON array of URL templates")
+	}
+	if len(tmpls) > httpTemplatesMaxPerDataset {
+		return retFail(c, apitypes.ErrInvalidRequest, "request body contains %d entries, more than the maximum of %d", len(tmpls), httpTemplatesMaxPerDataset)
+	}
+
+	problems := make([]string, 0)
+	for i, t := range tmpls {
+		if !strings.Contains(t, tmplPlaceholderPieceCID) && !strings.Contains(t, tmplPlaceholderPayloadCID) {
+			problems = append(problems, fmt.Sprintf("entry #%d: template '%s' contains neither of the %s or %s placeholders", i, t, tmplPlaceholderPieceCID, tmplPlaceholderPayloadCID))
+			continue
+		}
+		// validate the result of a sample expansion
+		sample := strings.NewReplacer(
+			tmplPlaceholderPieceCID, "baga6ea4seaqpiece",
+			tmplPlaceholderPayloadCID, "bafyreipayload",
+		).Replace(t)
+		if err := validateHTTPSourceURL(sample); err != nil {
+			problems = append(problems, fmt.Sprintf("entry #%d: template '%s' is not valid: %s", i, t, err))
+		}
+	}
+	if len(problems) > 0 {
+		return retFail(c, apitypes.ErrInvalidRequest, "%s", strings.Join(problems, "\n"))
+	}
+
+	if err := ctxMeta.Db[app.DbMain].BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(
+			ctx,
+			`DELETE FROM spd.datasets_http_templates WHERE dataset_id = $1`,
+			dsID,
+		); err != nil {
+			return cmn.WrErr(err)
+		}
+		_, err := tx.Exec(
+			ctx,
+			`
+			INSERT INTO spd.datasets_http_templates ( dataset_id, url_template )
+				SELECT $1, UNNEST( $2::TEXT[] )
+			ON CONFLICT DO NOTHING
+			`,
+			dsID,
+			tmpls,
+		)
+		return cmn.WrErr(err)
+	}); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		tmpls,
+		strings.Join([]string{
+			"Dataset '%s' now has %d HTTP URL templates.",
+			"Changes become visible to SPs after the next periodic availability refresh ( within several minutes ).",
+		}, "\n"),
+		c.Param("datasetSlug"),
+		len(tmpls),
+	)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+type tenantReplication struct {
+	DatasetSlug string `json:"dataset_slug"`
+	PieceCount  int64  `json:"piece_count"`
+	TotalBytes  int64  `json:"total_bytes"`
+
+	InFlightProposals int64 `json:"in_flight_proposals"`
+	InFlightBytes     int64 `json:"in_flight_bytes"`
+	ActiveDeals       int64 `json:"active_deals"`
+	ActiveBytes       int64 `json:"active_bytes"`
+
+	PiecesByReplicaCount []tenantReplicaBucket `json:"pieces_by_replica_count"`
+}
+
+type tenantReplicaBucket struct {
+	Replicas   int   `json:"replicas"`
+	PieceCount int64 `json:"piece_count"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+func apiTenantDatasetReplication(c echo.Context) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	dsID, err := tenantDatasetID(ctx, c)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	if dsID == 0 {
+		return retUnknownDataset(c)
+	}
+
+	ret := tenantReplication{
+		DatasetSlug:          c.Param("datasetSlug"),
+		PiecesByReplicaCount: make([]tenantReplicaBucket, 0, 16),
+	}
+
+	// replica counts are the ones used by the replication rules, and thus
+	// are only as fresh as the last materialized view refresh
+	if err := pgxscan.Select(
+		ctx,
+		ctxMeta.Db[app.DbMain],
+		&ret.PiecesByReplicaCount,
+		`
+		SELECT
+				COALESCE( r.replicas_any, 0 )::INTEGER AS replicas,
+				COUNT(*) AS piece_count,
+				SUM( 1::BIGINT << p.piece_log2_size )::BIGINT AS total_bytes
+			FROM spd.datasets_pieces dp
+			JOIN spd.pieces p USING ( piece_id )
+			LEFT JOIN spd.mv_replicas_continent r
+				ON
+					r.piece_id = dp.piece_id
+						AND
+					r.continent_id IS NULL
+						AND
+					r.claimant_id = $2
+		WHERE
+			dp.dataset_id = $1
+		GROUP BY 1
+		ORDER BY 1
+		`,
+		dsID,
+		ctxMeta.authedTenantID,
+	); err != nil {
+		return cmn.WrErr(err)
+	}
+	for _, b := range ret.PiecesByReplicaCount {
+		ret.PieceCount += b.PieceCount
+		ret.TotalBytes += b.TotalBytes
+	}
+
+	// the live part
+	if err := ctxMeta.Db[app.DbMain].QueryRow(
+		ctx,
+		`
+		WITH
+			tenant_clients AS (
+				SELECT client_id FROM spd.clients WHERE tenant_id = $2
+			),
+			dataset_pieces AS (
+				SELECT piece_id FROM spd.datasets_pieces WHERE dataset_id = $1
+			)
+		SELECT
+			(
+				SELECT COUNT(*)
+					FROM spd.proposals pr
+				WHERE
+					pr.proposal_failstamp = 0
+						AND
+					pr.activated_deal_id IS NULL
+						AND
+					pr.activated_claim_id IS NULL
+						AND
+					pr.piece_id IN ( SELECT piece_id FROM dataset_pieces )
+						AND
+					pr.client_id IN ( SELECT client_id FROM tenant_clients )
+			),
+			COALESCE(
+				(
+					SELECT SUM( 1::BIGINT << pr.proxied_log2_size )
+						FROM spd.proposals pr
+					WHERE
+						pr.proposal_failstamp = 0
+							AND
+						pr.activated_deal_id IS NULL
+							AND
+						pr.activated_claim_id IS NULL
+							AND
+						pr.piece_id IN ( SELECT piece_id FROM dataset_pieces )
+							AND
+						pr.client_id IN ( SELECT client_id FROM tenant_clients )
+				)::BIGINT,
+				0::BIGINT
+			),
+			(
+				SELECT COUNT(*)
+					FROM spd.published_deals pd
+				WHERE
+					pd.status = 'active'
+						AND
+					pd.piece_id IN ( SELECT piece_id FROM dataset_pieces )
+						AND
+					pd.client_id IN ( SELECT client_id FROM tenant_clients )
+			),
+			COALESCE(
+				(
+					SELECT SUM( 1::BIGINT << pd.claimed_log2_size )
+						FROM spd.published_deals pd
+					WHERE
+						pd.status = 'active'
+							AND
+						pd.piece_id IN ( SELECT piece_id FROM dataset_pieces )
+							AND
+						pd.client_id IN ( SELECT client_id FROM tenant_clients )
+				)::BIGINT,
+				0::BIGINT
+			)
+		`,
+		dsID,
+		ctxMeta.authedTenantID,
+	).Scan(&ret.InFlightProposals, &ret.InFlightBytes, &ret.ActiveDeals, &ret.ActiveBytes); err != nil {
+		return cmn.WrErr(err)
+	}
+
+	return retPayloadAnnotated(
+		c,
+		http.StatusOK,
+		0,
+		ret,
+		strings.Join([]string{
+			"Replication progress of dataset '%s' as seen by tenant %d.",
+			"pieces_by_replica_count reflects the replica counts used for replication rules, and is refreshed periodically.",
+			"The in-flight and active deal figures are near-real-time and only include deals made by the tenant's own clients.",
+		}, "\n"),
+		ret.DatasetSlug,
+		ctxMeta.authedTenantID,
+	)
+}
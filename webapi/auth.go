@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
@@ -18,18 +23,26 @@ import (
 	filcrypto "github.com/filecoin-project/go-state-types/crypto"
 	lotustypes "github.com/filecoin-project/lotus/chain/types"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jackc/pgx/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/ribasushi/go-toolbox-interplanetary/fil"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/sigverify"
+	"golang.org/x/xerrors"
 )
 
 const (
-	sigGraceEpochs = 3
-	authScheme     = `FIL-SPID-V0`
+	sigGraceEpochs     = 3
+	blsSigLen          = 96
+	authScheme         = `FIL-SPID-V0`
+	authSchemeV1       = `FIL-SPID-V1`
+	tenantAuthScheme   = `FIL-TENANT-V0`
+	tenantAuthSchemeV1 = `FIL-TENANT-V1`
 )
 
 type rawHdr struct {
+	scheme string
 	epoch  string
 	addr   string
 	sigB64 string
@@ -41,15 +54,33 @@ type sigChallenge struct {
 	epoch   int64
 	arg     []byte
 	hdr     rawHdr
+
+	signerRole string // which of the signerResolver candidates produced the signature
 }
 
 type verifySigResult struct {
 	invalidSigErrstr string
+	signerRole       string
 }
 
+// Roles a key can have in relation to an SP, as listed in its MinerInfo
+const (
+	authRoleOwner   = "owner"
+	authRoleWorker  = "worker"
+	authRoleControl = "control"
+)
+
+type signerCandidate struct {
+	addr filaddr.Address
+	role string // empty for non-SP signers
+}
+
+// signerResolver returns the key addresses any of which may have signed a challenge
+type signerResolver func(ctx context.Context, challenge sigChallenge) ([]signerCandidate, error)
+
 var (
 	spAuthRe = regexp.MustCompile(
-		`^` + authScheme + `\s+` +
+		`^(` + authScheme + `|` + authSchemeV1 + `)\s+` +
 			// fil epoch
 			`([0-9]+)` + `\s*;\s*` +
 			// spID
@@ -62,110 +93,343 @@ var (
 			`(?:\s*\;\s*([^; ]+))?` +
 			`\s*$`,
 	)
+	tenantAuthRe = regexp.MustCompile(
+		`^(` + tenantAuthScheme + `|` + tenantAuthSchemeV1 + `)\s+` +
+			// fil epoch
+			`([0-9]+)` + `\s*;\s*` +
+			// client robust address, secp256k1 or BLS
+			`([ft][13][a-z2-7]+)` + `\s*;\s*` +
+			// signature
+			`([^; ]+)` +
+			// optional signed argument
+			`(?:\s*\;\s*([^; ]+))?` +
+			`\s*$`,
+	)
 	challengeCache, _ = lru.New[rawHdr, verifySigResult](sigGraceEpochs * 128)
 	beaconCache, _    = lru.New[int64, *lotustypes.BeaconEntry](sigGraceEpochs * 4)
 )
 
-func spidAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
+// parseChallenge validates the Authorization header against the given scheme
+// and signature-checks it, returning a non-empty string describing the first
+// encountered problem if any
+func parseChallenge(c echo.Context, defaultScheme string, re *regexp.Regexp, resolveSigner signerResolver) (sigChallenge, string, error) {
+	ctx := c.Request().Context()
 
-		ctx := c.Request().Context()
+	var challenge sigChallenge
+	challenge.authHdr = c.Request().Header.Get(echo.HeaderAuthorization)
+	res := re.FindStringSubmatch(challenge.authHdr)
 
-		var challenge sigChallenge
-		challenge.authHdr = c.Request().Header.Get(echo.HeaderAuthorization)
-		res := spAuthRe.FindStringSubmatch(challenge.authHdr)
+	if len(res) == 6 {
+		challenge.hdr.scheme, challenge.hdr.epoch, challenge.hdr.addr, challenge.hdr.sigB64, challenge.hdr.arg = res[1], res[2], res[3], res[4], res[5]
+	} else {
+		return challenge, fmt.Sprintf("invalid/unexpected %s Authorization header '%s'", defaultScheme, challenge.authHdr), nil
+	}
+	scheme := challenge.hdr.scheme
 
-		if len(res) == 5 {
-			challenge.hdr.epoch, challenge.hdr.addr, challenge.hdr.sigB64, challenge.hdr.arg = res[1], res[2], res[3], res[4]
-		} else {
-			return retAuthFail(c, "invalid/unexpected %s Authorization header '%s'", authScheme, challenge.authHdr)
-		}
+	var err error
+	challenge.addr, err = filaddr.NewFromString(challenge.hdr.addr)
+	if err != nil {
+		return challenge, fmt.Sprintf("unexpected %s auth address '%s'", scheme, challenge.hdr.addr), nil
+	}
+
+	challenge.epoch, err = strconv.ParseInt(challenge.hdr.epoch, 10, 32)
+	if err != nil {
+		return challenge, fmt.Sprintf("unexpected %s auth epoch '%s'", scheme, challenge.hdr.epoch), nil
+	}
 
-		var err error
-		challenge.addr, err = filaddr.NewFromString(challenge.hdr.addr)
+	curFilEpoch := int64(fil.WallTimeEpoch(time.Now()))
+	if curFilEpoch < challenge.epoch {
+		return challenge, fmt.Sprintf("%s auth epoch '%d' is in the future", scheme, challenge.epoch), nil
+	}
+	if curFilEpoch-challenge.epoch > sigGraceEpochs {
+		return challenge, fmt.Sprintf("%s auth epoch '%d' is too far in the past", scheme, challenge.epoch), nil
+	}
+
+	challenge.arg, err = base64.StdEncoding.DecodeString(challenge.hdr.arg)
+	if err != nil {
+		return challenge, fmt.Sprintf("unable to decode optional argument: %s", err.Error()), nil
+	}
+
+	var vsr verifySigResult
+	if maybeResult, known := challengeCache.Get(challenge.hdr); known {
+		vsr = maybeResult
+	} else {
+		vsr, err = verifySig(ctx, challenge, resolveSigner)
 		if err != nil {
-			return retAuthFail(c, "unexpected %s auth address '%s'", authScheme, challenge.hdr.addr)
+			return challenge, "", cmn.WrErr(err)
 		}
+		challengeCache.Add(challenge.hdr, vsr)
+	}
+	challenge.signerRole = vsr.signerRole
+	if vsr.invalidSigErrstr != "" {
+		return challenge, vsr.invalidSigErrstr, nil
+	}
 
-		challenge.epoch, err = strconv.ParseInt(challenge.hdr.epoch, 10, 32)
-		if err != nil {
-			return retAuthFail(c, "unexpected %s auth epoch '%s'", authScheme, challenge.hdr.epoch)
+	// only checked once the signature holds: the request body is not read on behalf of just anyone
+	if scheme == authSchemeV1 || scheme == tenantAuthSchemeV1 || scheme == adminAuthScheme {
+		if errStr := checkRequestBinding(c, scheme, challenge.arg); errStr != "" {
+			return challenge, errStr, nil
 		}
+	}
+
+	return challenge, "", nil
+}
+
+// checkRequestBinding ensures the signed argument of a V1 challenge commits to the request being
+// made: it must read "METHOD REQUEST-URI", followed for POST requests by a space and the hex-encoded
+// SHA-256 of the request body, optionally followed by a space and an opaque nonce. The nonce allows
+// several otherwise identical single-use requests within the same epoch. The REQUEST-URI is either
+// verbatim as sent, or with the query parameters sorted by name.
+func checkRequestBinding(c echo.Context, scheme string, arg []byte) string {
+	req := c.Request()
+
+	canonicalURI := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		canonicalURI += "?" + req.URL.Query().Encode()
+	}
+	expected := req.Method + " " + canonicalURI
+	fields := 2
 
-		curFilEpoch := int64(fil.WallTimeEpoch(time.Now()))
-		if curFilEpoch < challenge.epoch {
-			return retAuthFail(c, "%s auth epoch '%d' is in the future", authScheme, challenge.epoch)
+	var bodyDigest string
+	if req.Method == http.MethodPost {
+		// the body is buffered in full: it has to be digested before the handler sees any of it
+		body, err := io.ReadAll(io.LimitReader(req.Body, signedBodyMaxBytes+1))
+		if err != nil {
+			return fmt.Sprintf("unable to read request body: %s", err)
 		}
-		if curFilEpoch-challenge.epoch > sigGraceEpochs {
-			return retAuthFail(c, "%s auth epoch '%d' is too far in the past", authScheme, challenge.epoch)
+		if len(body) > signedBodyMaxBytes {
+			return fmt.Sprintf("request body is larger than the maximum of %d bytes", signedBodyMaxBytes)
 		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
 
-		challenge.arg, err = base64.StdEncoding.DecodeString(challenge.hdr.arg)
-		if err != nil {
-			return retAuthFail(c, "unable to decode optional argument: %s", err.Error())
+		d := sha256.Sum256(body)
+		bodyDigest = hex.EncodeToString(d[:])
+		expected += " " + bodyDigest
+		fields++
+	}
+
+	parts := strings.SplitN(string(arg), " ", fields+1)
+	if len(parts) < fields ||
+		parts[0] != req.Method ||
+		(parts[1] != req.RequestURI && parts[1] != canonicalURI) ||
+		(bodyDigest != "" && !strings.EqualFold(parts[2], bodyDigest)) ||
+		(len(parts) > fields && (parts[fields] == "" || strings.ContainsAny(parts[fields], " \t\r\n"))) {
+		return fmt.Sprintf(
+			"%s signed argument '%s' does not match the request, expected '%s' optionally followed by a space and a nonce",
+			scheme,
+			arg,
+			expected,
+		)
+	}
+	return ""
+}
+
+// recordAuthUse notes the use of a challenge in the store shared by all webapi instances,
+// returning how many times it has been used before. The signature itself is not part of
+// the key: it is deterministic for a given signer and argument, and this way signature
+// malleability can not be used to sidestep the check.
+func recordAuthUse(ctx context.Context, challenge sigChallenge) (int, error) {
+	digest := sha256.Sum256([]byte(fmt.Sprintf(
+		"%s;%d;%s;%s",
+		challenge.hdr.scheme,
+		challenge.epoch,
+		challenge.addr.String(),
+		challenge.hdr.arg,
+	)))
+
+	var priorUses int
+	if err := app.GetGlobalCtx(ctx).Db[app.DbMain].QueryRow(
+		ctx,
+		`
+		WITH
+			-- anything this old can not pass the epoch check anymore
+			cleanup AS (
+				DELETE FROM spd.auth_uses
+				WHERE auth_epoch < $2::INTEGER - $3::INTEGER
+			)
+		INSERT INTO spd.auth_uses ( auth_digest, auth_epoch )
+			VALUES ( $1, $2 )
+		ON CONFLICT ( auth_digest ) DO UPDATE SET
+			use_count = spd.auth_uses.use_count + 1
+		RETURNING use_count - 1
+		`,
+		digest[:],
+		challenge.epoch,
+		2*sigGraceEpochs,
+	).Scan(&priorUses); err != nil {
+		return 0, cmn.WrErr(err)
+	}
+	return priorUses, nil
+}
+
+// singleUseAuth guards state-changing routes: it rejects any Authorization header that
+// has already been used before, on any route of any webapi instance. Bearer tokens are
+// reusable by design, and are instead required to carry the sessionScopeReserve scope.
+func singleUseAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, ctxMeta := unpackAuthedEchoContext(c)
+		if ctxMeta.session != nil {
+			if ctxMeta.session.scope != sessionScopeReserve {
+				return retAuthFail(
+					c,
+					bearerAuthScheme,
+					"Session %s has the '%s' scope, which does not permit state-changing requests",
+					ctxMeta.session.sessionID,
+					ctxMeta.session.scope,
+				)
+			}
+		} else if ctxMeta.authPriorUses > 0 {
+			scheme := authSchemeV1
+			if ctxMeta.authedTenantID != 0 {
+				scheme = tenantAuthSchemeV1
+			} else if ctxMeta.authedOperator != "" {
+				scheme = adminAuthScheme
+			}
+			return retAuthFail(
+				c,
+				scheme,
+				"This Authorization header has already been used %d time(s): state-changing requests require a never-before-seen header.\n"+
+					"Use the %s scheme, adding a nonce to the signed argument when making several identical requests within the same epoch.",
+				ctxMeta.authPriorUses,
+				scheme,
+			)
+		}
+		return next(c)
+	}
+}
+
+// singleUseAuthLegacyV0 is singleUseAuth for routes that predate it: there FIL-SPID-V0 headers
+// remain reusable within their epoch as they always were, so that existing scripts requesting
+// several pieces per epoch keep working. Such responses carry a Deprecation header: the
+// exemption goes away once V0 is retired.
+func singleUseAuthLegacyV0(next echo.HandlerFunc) echo.HandlerFunc {
+	strict := singleUseAuth(next)
+	return func(c echo.Context) error {
+		_, ctxMeta := unpackAuthedEchoContext(c)
+		if ctxMeta.authScheme == authScheme {
+			c.Response().Header().Set("Deprecation", "true")
+			return next(c)
 		}
+		return strict(c)
+	}
+}
 
-		var vsr verifySigResult
-		if maybeResult, known := challengeCache.Get(challenge.hdr); known {
-			vsr = maybeResult
+// requestDump returns the JSON representation of a request, as stored in spd.requests
+func requestDump(c echo.Context) ([]byte, error) {
+	reqCopy := c.Request().Clone(c.Request().Context())
+	// do not need to store any IPs anywhere in the DB
+	for _, strip := range []string{
+		"X-Real-Ip", "X-Forwarded-For", "Cf-Connecting-Ip",
+	} {
+		delete(reqCopy.Header, strip)
+	}
+	// bearer tokens are secrets in their own right: the session is recorded separately
+	if _, isBearer := bearerToken(c); isBearer {
+		reqCopy.Header.Set(echo.HeaderAuthorization, bearerAuthScheme+" [redacted]")
+	}
+	reqJ, err := json.Marshal(
+		struct {
+			Method  string
+			Host    string
+			URL     *url.URL
+			Headers http.Header
+		}{
+			Method:  reqCopy.Method,
+			Host:    reqCopy.Host,
+			URL:     reqCopy.URL,
+			Headers: reqCopy.Header,
+		},
+	)
+	if err != nil {
+		return nil, cmn.WrErr(err)
+	}
+	return reqJ, nil
+}
+
+func spidAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		ctx := c.Request().Context()
+
+		var spID fil.ActorID
+		var authArg []byte
+		var usedScheme string
+		var priorUses int
+		var authRole string
+		var session *spSessionAuth
+		var challenge sigChallenge
+
+		if token, isBearer := bearerToken(c); isBearer {
+			var invalidErrstr string
+			var err error
+			session, invalidErrstr, err = lookupSession(ctx, token)
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+			if invalidErrstr != "" {
+				return retAuthFail(c, bearerAuthScheme, "%s", invalidErrstr)
+			}
+			spID = session.providerID
+			authRole = session.role
 		} else {
-			vsr, err = verifySig(ctx, challenge)
+			var invalidErrstr string
+			var err error
+			challenge, invalidErrstr, err = parseChallenge(c, authScheme, spAuthRe, spKeys)
 			if err != nil {
 				return cmn.WrErr(err)
 			}
-			challengeCache.Add(challenge.hdr, vsr)
-		}
+			if invalidErrstr != "" {
+				return retAuthFail(c, authScheme, "%s", invalidErrstr)
+			}
+
+			// if challenge.addr.String() == "f01" {
+			// 	challenge.addr, _ = filaddr.NewFromString("f02")
+			// }
 
-		if vsr.invalidSigErrstr != "" {
-			return retAuthFail(c, vsr.invalidSigErrstr)
+			spID = fil.MustParseActorString(challenge.addr.String())
+			authArg = challenge.arg
+			usedScheme = challenge.hdr.scheme
+			authRole = challenge.signerRole
 		}
 
-		// set only on request object for logging, not part of response
-		c.Request().Header.Set("X-SPADE-LOGGED-SP", challenge.addr.String())
+		// before anything about the request is recorded: an SP over its limit costs only the bucket update
+		if isLimited, err := spRateLimited(c, spID); err != nil {
+			return cmn.WrErr(err)
+		} else if isLimited {
+			return nil
+		}
 
-		// if challenge.addr.String() == "f01" {
-		// 	challenge.addr, _ = filaddr.NewFromString("f02")
-		// }
+		if session == nil {
+			var err error
+			priorUses, err = recordAuthUse(ctx, challenge)
+			if err != nil {
+				return cmn.WrErr(err)
+			}
+		}
 
-		spID := fil.MustParseActorString(challenge.addr.String())
+		// set only on request object for logging, not part of response
+		c.Request().Header.Set("X-SPADE-LOGGED-SP", spID.String())
+		c.Request().Header.Set("X-SPADE-LOGGED-ROLE", authRole)
 
-		reqCopy := c.Request().Clone(ctx)
-		// do not need to store any IPs anywhere in the DB
-		for _, strip := range []string{
-			"X-Real-Ip", "X-Forwarded-For", "Cf-Connecting-Ip",
-		} {
-			delete(reqCopy.Header, strip)
-		}
-		reqJ, err := json.Marshal(
-			struct {
-				Method  string
-				Host    string
-				URL     *url.URL
-				Headers http.Header
-			}{
-				Method:  reqCopy.Method,
-				Host:    reqCopy.Host,
-				URL:     reqCopy.URL,
-				Headers: reqCopy.Header,
-			},
-		)
+		reqJ, err := requestDump(c)
 		if err != nil {
 			return cmn.WrErr(err)
 		}
 
 		var requestUUID string
-		var stateEpoch int64
+		var stateEpoch, matviewsRefreshed int64
 		var spDetails []int16
 		var spInfo apitypes.SPInfo
 		var spInfoLastPoll *time.Time
 		if err := app.GetGlobalCtx(ctx).Db[app.DbMain].QueryRow(
 			ctx,
 			`
-			INSERT INTO spd.requests ( provider_id, request_dump )
-				VALUES ( $1, $2 )
+			INSERT INTO spd.requests ( provider_id, request_dump, session_id, auth_role )
+				VALUES ( $1, $2, $3, $4 )
 			RETURNING
 				request_uuid,
 				( SELECT ( metadata->'market_state'->'epoch' )::INTEGER FROM spd.global ),
+				( SELECT COALESCE( ( metadata->'matviews_refreshed' )::BIGINT, 0 ) FROM spd.global ),
 				(
 					SELECT
 						ARRAY[
@@ -190,27 +454,136 @@ func spidAuth(next echo.HandlerFunc) echo.HandlerFunc {
 			`,
 			spID,
 			reqJ,
-		).Scan(&requestUUID, &stateEpoch, &spDetails, &spInfo, &spInfoLastPoll); err != nil {
+			session.id(),
+			authRole,
+		).Scan(&requestUUID, &stateEpoch, &matviewsRefreshed, &spDetails, &spInfo, &spInfoLastPoll); err != nil {
 			return cmn.WrErr(err)
 		}
 
-		c.Response().Header().Set("X-SPADE-FIL-SPID", challenge.addr.String())
+		c.Response().Header().Set("X-SPADE-FIL-SPID", spID.String())
 
 		// set on both request (for logging ) and response object
 		c.Request().Header.Set("X-SPADE-REQUEST-UUID", requestUUID)
 		c.Response().Header().Set("X-SPADE-REQUEST-UUID", requestUUID)
 
 		c.Set("♠️", metaContext{
-			GlobalContext:    app.GetGlobalCtx(ctx),
-			stateEpoch:       stateEpoch,
-			authedActorID:    spID,
-			authArg:          challenge.arg,
-			spOrgID:          spDetails[0],
-			spCityID:         spDetails[1],
-			spCountryID:      spDetails[2],
-			spContinentID:    spDetails[3],
-			spInfo:           spInfo,
-			spInfoLastPolled: spInfoLastPoll,
+			GlobalContext:     app.GetGlobalCtx(ctx),
+			stateEpoch:        stateEpoch,
+			matviewsRefreshed: matviewsRefreshed,
+			authedActorID:     spID,
+			authArg:           authArg,
+			authScheme:        usedScheme,
+			authPriorUses:     priorUses,
+			session:           session,
+			authRole:          authRole,
+			spOrgID:           spDetails[0],
+			spCityID:          spDetails[1],
+			spCountryID:       spDetails[2],
+			spContinentID:     spDetails[3],
+			spInfo:            spInfo,
+			spInfoLastPolled:  spInfoLastPoll,
+		})
+
+		return next(c)
+	}
+}
+
+func tenantAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		ctx := c.Request().Context()
+		db := app.GetGlobalCtx(ctx).Db[app.DbMain]
+
+		// the signer is the address itself: check it belongs to a tenant before
+		// asking lotus for anything
+		if res := tenantAuthRe.FindStringSubmatch(c.Request().Header.Get(echo.HeaderAuthorization)); len(res) == 6 {
+			var isKnown bool
+			if err := db.QueryRow(
+				ctx,
+				`SELECT EXISTS ( SELECT 42 FROM spd.clients WHERE tenant_id IS NOT NULL AND client_address = $1 )`,
+				res[3],
+			).Scan(&isKnown); err != nil {
+				return cmn.WrErr(err)
+			}
+			if !isKnown {
+				return retAuthFail(c, tenantAuthScheme, "address '%s' is not associated with any tenant", res[3])
+			}
+		}
+
+		challenge, invalidErrstr, err := parseChallenge(
+			c, tenantAuthScheme, tenantAuthRe,
+			func(_ context.Context, ch sigChallenge) ([]signerCandidate, error) {
+				return []signerCandidate{{addr: ch.addr}}, nil
+			},
+		)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		if invalidErrstr != "" {
+			return retAuthFail(c, tenantAuthScheme, "%s", invalidErrstr)
+		}
+
+		priorUses, err := recordAuthUse(ctx, challenge)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		reqJ, err := requestDump(c)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		var requestUUID string
+		var stateEpoch int64
+		var tenantID int16
+		var clientID fil.ActorID
+		if err := db.QueryRow(
+			ctx,
+			`
+			WITH
+				client AS (
+					SELECT tenant_id, client_id
+						FROM spd.clients
+					WHERE
+						tenant_id IS NOT NULL
+							AND
+						client_address = $1
+				),
+				req AS (
+					INSERT INTO spd.requests ( tenant_id, request_dump )
+						SELECT tenant_id, $2 FROM client
+					RETURNING request_uuid
+				)
+			SELECT
+					req.request_uuid,
+					( SELECT ( metadata->'market_state'->'epoch' )::INTEGER FROM spd.global ),
+					client.tenant_id,
+					client.client_id
+				FROM req, client
+			`,
+			challenge.addr.String(),
+			reqJ,
+		).Scan(&requestUUID, &stateEpoch, &tenantID, &clientID); err != nil {
+			return cmn.WrErr(err)
+		}
+
+		// set only on request object for logging, not part of response
+		c.Request().Header.Set("X-SPADE-LOGGED-TENANT", strconv.Itoa(int(tenantID)))
+
+		c.Response().Header().Set("X-SPADE-FIL-CLIENT", challenge.addr.String())
+
+		// set on both request (for logging ) and response object
+		c.Request().Header.Set("X-SPADE-REQUEST-UUID", requestUUID)
+		c.Response().Header().Set("X-SPADE-REQUEST-UUID", requestUUID)
+
+		c.Set("♠️", metaContext{
+			GlobalContext:  app.GetGlobalCtx(ctx),
+			stateEpoch:     stateEpoch,
+			authArg:        challenge.arg,
+			authScheme:     challenge.hdr.scheme,
+			authPriorUses:  priorUses,
+			authedTenantID: tenantID,
+			authedClientID: clientID,
 		})
 
 		return next(c)
@@ -219,15 +592,28 @@ func spidAuth(next echo.HandlerFunc) echo.HandlerFunc {
 
 type metaContext struct {
 	app.GlobalContext
-	authedActorID    fil.ActorID
-	stateEpoch       int64
-	spInfo           apitypes.SPInfo
-	spInfoLastPolled *time.Time
-	spOrgID          int16
-	spCityID         int16
-	spCountryID      int16
-	spContinentID    int16
-	authArg          []byte
+	authedActorID     fil.ActorID
+	stateEpoch        int64
+	matviewsRefreshed int64 // spd.big_now() of the last matview refresh, keys eligible_pieces continuation tokens
+	spInfo            apitypes.SPInfo
+	spInfoLastPolled  *time.Time
+	spOrgID           int16
+	spCityID          int16
+	spCountryID       int16
+	spContinentID     int16
+	authArg           []byte
+	authScheme        string         // the scheme of the Authorization header, empty when authenticated by a bearer token
+	authPriorUses     int            // how many times the Authorization header was seen before this request
+	session           *spSessionAuth // only set when authenticated by a bearer token
+	authRole          string         // authRoleWorker, authRoleOwner or authRoleControl
+
+	// only set by tenantAuth
+	authedTenantID int16
+	authedClientID fil.ActorID
+
+	// only set by adminAuth
+	authedOperator        string
+	authedOperatorAddress filaddr.Address
 }
 
 func unpackAuthedEchoContext(c echo.Context) (context.Context, metaContext) {
@@ -235,58 +621,200 @@ func unpackAuthedEchoContext(c echo.Context) (context.Context, metaContext) {
 	return c.Request().Context(), meta
 }
 
-func verifySig(ctx context.Context, challenge sigChallenge) (verifySigResult, error) {
+type spKeysCacheKey struct {
+	spID       fil.ActorID
+	keysHeight filabi.ChainEpoch
+}
+
+// storedAuthKey is the spd.sp_auth_keys.auth_keys representation of a signerCandidate
+type storedAuthKey struct {
+	Addr string `json:"addr"`
+	Role string `json:"role"`
+}
+
+var spKeysCache, _ = lru.New[spKeysCacheKey, []signerCandidate](4096)
+
+// spKeys resolves the worker, owner and control keys of the SP as of a finalized tipset preceding
+// the challenge epoch. The tipset height is rounded down to spKeysHeightGranularity, so that a
+// given SP's keys are resolved against lotus only once per period, and are shared by all webapi
+// instances via spd.sp_auth_keys. The tradeoff is that a key rotation takes up to an extra period
+// to be recognized.
+func spKeys(ctx context.Context, challenge sigChallenge) ([]signerCandidate, error) {
+	spID := fil.MustParseActorString(challenge.addr.String())
+	keysHeight := filabi.ChainEpoch(challenge.epoch) - filprovider.ChainFinality
+	keysHeight -= keysHeight % spKeysHeightGranularity
+
+	ck := spKeysCacheKey{spID: spID, keysHeight: keysHeight}
+	if cands, didFind := spKeysCache.Get(ck); didFind {
+		return cands, nil
+	}
+
+	db := app.GetGlobalCtx(ctx).Db[app.DbMain]
+
+	var stored []storedAuthKey
+	err := db.QueryRow(
+		ctx,
+		`
+		SELECT auth_keys
+			FROM spd.sp_auth_keys
+		WHERE
+			provider_id = $1
+				AND
+			keys_height = $2
+		`,
+		spID,
+		keysHeight,
+	).Scan(&stored)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, cmn.WrErr(err)
+	}
+
+	if err == nil {
+		cands := make([]signerCandidate, 0, len(stored))
+		for _, sk := range stored {
+			a, err := filaddr.NewFromString(sk.Addr)
+			if err != nil {
+				return nil, cmn.WrErr(err)
+			}
+			cands = append(cands, signerCandidate{addr: a, role: sk.Role})
+		}
+		spKeysCache.Add(ck, cands)
+		return cands, nil
+	}
+
+	keysTs, cands, err := resolveSpKeys(ctx, challenge.addr, keysHeight)
+	if err != nil {
+		return nil, cmn.WrErr(err)
+	}
+
+	stored = make([]storedAuthKey, len(cands))
+	for i, c := range cands {
+		stored[i] = storedAuthKey{Addr: c.addr.String(), Role: c.role}
+	}
+	storedJ, err := json.Marshal(stored)
+	if err != nil {
+		return nil, cmn.WrErr(err)
+	}
+	if _, err := db.Exec(
+		ctx,
+		`
+		WITH
+			prune AS (
+				DELETE FROM spd.sp_auth_keys
+				WHERE
+					provider_id = $1
+						AND
+					keys_height < $2 - $5::INTEGER
+			)
+		INSERT INTO spd.sp_auth_keys ( provider_id, keys_height, keys_tipset_key, auth_keys )
+			VALUES ( $1, $2, $3, $4 )
+		ON CONFLICT DO NOTHING
+		`,
+		spID,
+		keysHeight,
+		keysTs.Key().String(),
+		storedJ,
+		spKeysHeightGranularity,
+	); err != nil {
+		return nil, cmn.WrErr(err)
+	}
+
+	spKeysCache.Add(ck, cands)
+	return cands, nil
+}
+
+// resolveSpKeys asks lotus for the key addresses of an SP as of the tipset at the given height
+func resolveSpKeys(ctx context.Context, sp filaddr.Address, keysHeight filabi.ChainEpoch) (*lotustypes.TipSet, []signerCandidate, error) {
+	lAPI := app.GetGlobalCtx(ctx).LotusAPI[app.FilLite]
+
+	keysTs, err := lAPI.ChainGetTipSetByHeight(ctx, keysHeight, lotustypes.EmptyTSK)
+	if err != nil {
+		return nil, nil, cmn.WrErr(err)
+	}
+	mi, err := lAPI.StateMinerInfo(ctx, sp, keysTs.Key())
+	if err != nil {
+		return nil, nil, cmn.WrErr(err)
+	}
+
+	// the worker goes first: by far the most common signer
+	roles := []signerCandidate{{addr: mi.Worker, role: authRoleWorker}, {addr: mi.Owner, role: authRoleOwner}}
+	for _, ca := range mi.ControlAddresses {
+		roles = append(roles, signerCandidate{addr: ca, role: authRoleControl})
+	}
+
+	cands := make([]signerCandidate, 0, len(roles))
+	for _, r := range roles {
+		keyAddr, err := lAPI.StateAccountKey(ctx, r.addr, keysTs.Key())
+		if err != nil {
+			// the owner in particular is frequently a multisig, which has no key of its own
+			if r.role == authRoleWorker {
+				return nil, nil, cmn.WrErr(err)
+			}
+			continue
+		}
+		cands = append(cands, signerCandidate{addr: keyAddr, role: r.role})
+	}
+	return keysTs, cands, nil
+}
+
+// sigTypeForAddr returns the signature type produced by a key address
+func sigTypeForAddr(a filaddr.Address) (filcrypto.SigType, error) {
+	switch a.Protocol() {
+	case filaddr.BLS:
+		return filcrypto.SigTypeBLS, nil
+	case filaddr.SECP256K1:
+		return filcrypto.SigTypeSecp256k1, nil
+	default:
+		return filcrypto.SigTypeUnknown, xerrors.Errorf("address %s is not a key address", a)
+	}
+}
+
+func verifySig(ctx context.Context, challenge sigChallenge, resolveSigner signerResolver) (verifySigResult, error) {
 
 	sig, err := base64.StdEncoding.DecodeString(challenge.hdr.sigB64)
 	if err != nil {
 		return verifySigResult{
-			invalidSigErrstr: fmt.Sprintf("unexpected %s auth signature encoding '%s'", authScheme, challenge.hdr.sigB64),
+			invalidSigErrstr: fmt.Sprintf("unexpected %s auth signature encoding '%s'", challenge.hdr.scheme, challenge.hdr.sigB64),
 		}, nil
 	}
 
-	apis := app.GetGlobalCtx(ctx).LotusAPI
-	hAPI := apis[app.FilHeavy]
-	lAPI := apis[app.FilLite]
-
 	be, didFind := beaconCache.Get(challenge.epoch)
 	if !didFind {
-		be, err = hAPI.StateGetBeaconEntry(ctx, filabi.ChainEpoch(challenge.epoch))
+		be, err = app.GetGlobalCtx(ctx).LotusAPI[app.FilLite].StateGetBeaconEntry(ctx, filabi.ChainEpoch(challenge.epoch))
 		if err != nil {
 			return verifySigResult{}, cmn.WrErr(err)
 		}
 		beaconCache.Add(challenge.epoch, be)
 	}
 
-	miFinTs, err := lAPI.ChainGetTipSetByHeight(ctx, filabi.ChainEpoch(challenge.epoch)-filprovider.ChainFinality, lotustypes.EmptyTSK)
-	if err != nil {
-		return verifySigResult{}, cmn.WrErr(err)
-	}
-	mi, err := lAPI.StateMinerInfo(ctx, challenge.addr, miFinTs.Key())
-	if err != nil {
-		return verifySigResult{}, cmn.WrErr(err)
-	}
-	workerAddr, err := lAPI.StateAccountKey(ctx, mi.Worker, miFinTs.Key())
+	cands, err := resolveSigner(ctx, challenge)
 	if err != nil {
 		return verifySigResult{}, cmn.WrErr(err)
 	}
 
-	sigMatch, err := hAPI.WalletVerify(
-		ctx,
-		workerAddr,
-		append(append([]byte{0x20, 0x20, 0x20}, be.Data...), challenge.arg...),
-		&filcrypto.Signature{
-			Type: filcrypto.SigTypeBLS, // worker keys are always BLS
-			Data: []byte(sig),
-		},
-	)
-	if err != nil {
-		return verifySigResult{}, cmn.WrErr(err)
-	}
+	// the same key may hold several roles: the first listed wins
+	seen := make(map[filaddr.Address]struct{}, len(cands))
+	for _, cand := range cands {
+		if _, dup := seen[cand.addr]; dup {
+			continue
+		}
+		seen[cand.addr] = struct{}{}
 
-	if !sigMatch {
-		return verifySigResult{
-			invalidSigErrstr: fmt.Sprintf("%s signature validation failed for auth header '%s'", authScheme, challenge.authHdr),
-		}, nil
+		sigType, err := sigTypeForAddr(cand.addr)
+		if err != nil {
+			return verifySigResult{}, cmn.WrErr(err)
+		}
+		// do not bother with keys that could not have produced this signature
+		if (sigType == filcrypto.SigTypeBLS) != (len(sig) == blsSigLen) {
+			continue
+		}
+
+		if sigverify.KeySig(cand.addr, sig, append(append([]byte{0x20, 0x20, 0x20}, be.Data...), challenge.arg...)) {
+			return verifySigResult{signerRole: cand.role}, nil
+		}
 	}
-	return verifySigResult{}, nil
+
+	return verifySigResult{
+		invalidSigErrstr: fmt.Sprintf("%s signature validation failed for auth header '%s'", challenge.hdr.scheme, challenge.authHdr),
+	}, nil
 }
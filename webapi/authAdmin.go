@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	filaddr "github.com/filecoin-project/go-address"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
+)
+
+// only a request-bound variant exists: every admin request is signed for individually
+const adminAuthScheme = `FIL-ADMIN-V1`
+
+var adminAuthRe = regexp.MustCompile(
+	`^(` + adminAuthScheme + `)\s+` +
+		// fil epoch
+		`([0-9]+)` + `\s*;\s*` +
+		// operator key address, secp256k1 or BLS
+		`([ft][13][a-z2-7]+)` + `\s*;\s*` +
+		// signature
+		`([^; ]+)` +
+		// signed argument, mandatory
+		`\s*\;\s*([^; ]+)` +
+		`\s*$`,
+)
+
+// adminKeys maps operator key addresses to operator names, as listed in webapi-admin-keys
+var adminKeys map[filaddr.Address]string
+
+// parseAdminKeys parses a comma-separated list of name:address pairs
+func parseAdminKeys(s string) (map[filaddr.Address]string, error) {
+	keys := make(map[filaddr.Address]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, addrStr, found := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, xerrors.Errorf("admin key entry '%s' is not in the form name:address", entry)
+		}
+		addr, err := filaddr.NewFromString(strings.TrimSpace(addrStr))
+		if err != nil {
+			return nil, xerrors.Errorf("admin key entry '%s' has an invalid address: %w", entry, err)
+		}
+		if _, err := sigTypeForAddr(addr); err != nil {
+			return nil, xerrors.Errorf("admin key entry '%s': %w", entry, err)
+		}
+		if prev, dup := keys[addr]; dup {
+			return nil, xerrors.Errorf("admin key %s is listed both for '%s' and '%s'", addr, prev, name)
+		}
+		keys[addr] = name
+	}
+	return keys, nil
+}
+
+func adminAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+
+		ctx := c.Request().Context()
+
+		// same as for tenants: check the signer is an operator before asking lotus for anything
+		if res := adminAuthRe.FindStringSubmatch(c.Request().Header.Get(echo.HeaderAuthorization)); len(res) == 6 {
+			addr, err := filaddr.NewFromString(res[3])
+			if err != nil {
+				return retAuthFail(c, adminAuthScheme, "unexpected %s auth address '%s'", adminAuthScheme, res[3])
+			}
+			if _, isOperator := adminKeys[addr]; !isOperator {
+				return retAuthFail(c, adminAuthScheme, "address '%s' is not an operator key", res[3])
+			}
+		}
+
+		challenge, invalidErrstr, err := parseChallenge(
+			c, adminAuthScheme, adminAuthRe,
+			func(_ context.Context, ch sigChallenge) ([]signerCandidate, error) {
+				return []signerCandidate{{addr: ch.addr}}, nil
+			},
+		)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+		if invalidErrstr != "" {
+			return retAuthFail(c, adminAuthScheme, "%s", invalidErrstr)
+		}
+
+		priorUses, err := recordAuthUse(ctx, challenge)
+		if err != nil {
+			return cmn.WrErr(err)
+		}
+
+		operator := adminKeys[challenge.addr]
+
+		// set only on request object for logging, not part of response
+		c.Request().Header.Set("X-SPADE-LOGGED-OPERATOR", operator)
+
+		c.Set("♠️", metaContext{
+			GlobalContext:         app.GetGlobalCtx(ctx),
+			authArg:               challenge.arg,
+			authScheme:            challenge.hdr.scheme,
+			authPriorUses:         priorUses,
+			authedOperator:        operator,
+			authedOperatorAddress: challenge.addr,
+		})
+
+		return next(c)
+	}
+}
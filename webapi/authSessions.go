@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+const (
+	bearerAuthScheme = `Bearer`
+	sessionTokenPref = `spd1_`
+
+	sessionScopeRead    = "read"    // all routes not changing state
+	sessionScopeReserve = "reserve" // read + all state-changing routes, except for issuing further sessions
+)
+
+var bearerAuthRe = regexp.MustCompile(
+	`^` + bearerAuthScheme + `\s+` +
+		`(` + sessionTokenPref + `[A-Za-z0-9_\-]{43})` +
+		`\s*$`,
+)
+
+type spSessionAuth struct {
+	sessionID  string
+	providerID fil.ActorID
+	scope      string
+	role       string // the role of the key that signed for the session
+}
+
+// id returns the SessionID or nil, for direct use as an SQL argument
+func (s *spSessionAuth) id() *string {
+	if s == nil {
+		return nil
+	}
+	return &s.sessionID
+}
+
+// bearerToken returns the session token from the Authorization header, if one is present
+func bearerToken(c echo.Context) (string, bool) {
+	res := bearerAuthRe.FindStringSubmatch(c.Request().Header.Get(echo.HeaderAuthorization))
+	if len(res) != 2 {
+		return "", false
+	}
+	return res[1], true
+}
+
+func sessionTokenDigest(token string) []byte {
+	d := sha256.Sum256([]byte(token))
+	return d[:]
+}
+
+// newSessionToken returns a fresh random token and its digest, as stored in spd.sp_sessions
+func newSessionToken() (string, []byte, error) {
+	rnd := make([]byte, 32)
+	if _, err := rand.Read(rnd); err != nil {
+		return "", nil, cmn.WrErr(err)
+	}
+	token := sessionTokenPref + base64.RawURLEncoding.EncodeToString(rnd)
+	return token, sessionTokenDigest(token), nil
+}
+
+// lookupSession resolves a bearer token to a live session, returning a non-empty string
+// describing the problem if the token is not usable
+func lookupSession(ctx context.Context, token string) (*spSessionAuth, string, error) {
+	s := new(spSessionAuth)
+	var isLive bool
+	err := app.GetGlobalCtx(ctx).Db[app.DbMain].QueryRow(
+		ctx,
+		`
+		WITH
+			sess AS (
+				SELECT
+						session_id,
+						provider_id,
+						session_scope,
+						auth_role,
+						( session_revoked IS NULL AND session_expires > NOW() ) AS is_live
+					FROM spd.sp_sessions
+				WHERE
+					token_digest = $1
+			),
+			touch AS (
+				UPDATE spd.sp_sessions SET
+					session_last_used = NOW()
+				WHERE
+					session_id = ( SELECT session_id FROM sess WHERE is_live )
+			)
+		SELECT session_id, provider_id, session_scope, auth_role, is_live
+			FROM sess
+		`,
+		sessionTokenDigest(token),
+	).Scan(&s.sessionID, &s.providerID, &s.scope, &s.role, &isLive)
+	if err == pgx.ErrNoRows {
+		return nil, "unknown bearer token", nil
+	} else if err != nil {
+		return nil, "", cmn.WrErr(err)
+	}
+	if !isLive {
+		return nil, "session " + s.sessionID + " has expired or has been revoked", nil
+	}
+	return s, "", nil
+}
+
+// signedOnlyAuth guards routes that can not be accessed with a bearer token
+func signedOnlyAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, ctxMeta := unpackAuthedEchoContext(c)
+		if ctxMeta.session != nil {
+			return retAuthFail(c, authSchemeV1, "This route requires a %s signature, bearer tokens are not accepted", authSchemeV1)
+		}
+		return next(c)
+	}
+}
+
+// revokeSessionAuth is singleUseAuth, except that a bearer token of any scope is accepted
+// for revoking the very session it belongs to: a leaked token can always be disabled by
+// whoever holds it, and replaying such a request changes nothing
+func revokeSessionAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	strict := singleUseAuth(next)
+	return func(c echo.Context) error {
+		_, ctxMeta := unpackAuthedEchoContext(c)
+		if ctxMeta.session != nil && strings.EqualFold(ctxMeta.session.sessionID, c.Param("sessionID")) {
+			return next(c)
+		}
+		return strict(c)
+	}
+}
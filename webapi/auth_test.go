@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	filcrypto "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/spade/internal/app"
+	"github.com/ribasushi/spade/internal/fakechain"
+)
+
+func TestVerifySig(t *testing.T) {
+	fc := fakechain.New()
+	ctx := app.WithGlobalCtx(context.Background(), app.GlobalContext{
+		LotusAPI: app.FilAPIs{app.FilLite: fc},
+	})
+
+	const epoch = 2_500_000
+	arg := []byte("GET /sp/status")
+	be, err := fc.StateGetBeaconEntry(ctx, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, st := range []filcrypto.SigType{filcrypto.SigTypeSecp256k1, filcrypto.SigTypeBLS} {
+		signer, err := fc.NewWalletKey(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bystander, err := fc.NewWalletKey(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sig, err := fc.WalletSign(ctx, signer, append(append([]byte{0x20, 0x20, 0x20}, be.Data...), arg...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resolver := func(context.Context, sigChallenge) ([]signerCandidate, error) {
+			return []signerCandidate{
+				{addr: bystander, role: authRoleWorker},
+				{addr: signer, role: authRoleControl},
+			}, nil
+		}
+		challenge := func(a []byte) sigChallenge {
+			return sigChallenge{
+				epoch: epoch,
+				arg:   a,
+				hdr:   rawHdr{scheme: authSchemeV1, sigB64: base64.StdEncoding.EncodeToString(sig.Data)},
+			}
+		}
+
+		res, err := verifySig(ctx, challenge(arg), resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.invalidSigErrstr != "" || res.signerRole != authRoleControl {
+			t.Errorf("sigtype %d: expected a valid signature by the control key, got %#v", st, res)
+		}
+
+		res, err = verifySig(ctx, challenge([]byte("GET /sp/eligible_pieces")), resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.invalidSigErrstr == "" {
+			t.Errorf("sigtype %d: signature unexpectedly valid over a different argument", st)
+		}
+	}
+}
+
+func TestCheckRequestBinding(t *testing.T) {
+	const body = `["baga6ea4seaqao7s73y24kcutaosvacpdjgfe5pw76ooefnyqw4ynr3d2y6x2mpq"]`
+	digest := sha256.Sum256([]byte(body))
+	bodyHex := hex.EncodeToString(digest[:])
+
+	for _, tc := range []struct {
+		method, uri, body, arg string
+		valid                  bool
+	}{
+		{http.MethodGet, "/sp/request_piece/x?tenant=2", "", "GET /sp/request_piece/x?tenant=2", true},
+		{http.MethodGet, "/sp/request_piece/x?tenant=2", "", "GET /sp/request_piece/x?tenant=2 n1", true},
+		{http.MethodGet, "/sp/request_piece/x?tenant=2", "", "GET /sp/request_piece/y?tenant=2", false},
+		{http.MethodGet, "/sp/request_piece/x?tenant=2", "", "POST /sp/request_piece/x?tenant=2", false},
+		{http.MethodPost, "/sp/request_pieces", body, "POST /sp/request_pieces " + bodyHex, true},
+		{http.MethodPost, "/sp/request_pieces", body, "POST /sp/request_pieces " + bodyHex + " n1", true},
+		{http.MethodPost, "/sp/request_pieces", body, "POST /sp/request_pieces", false},
+		{http.MethodPost, "/sp/request_pieces", body, "POST /sp/request_pieces n1", false},
+		{http.MethodPost, "/sp/request_pieces", body + " ", "POST /sp/request_pieces " + bodyHex, false},
+	} {
+		req := httptest.NewRequest(tc.method, tc.uri, strings.NewReader(tc.body))
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		errStr := checkRequestBinding(c, authSchemeV1, []byte(tc.arg))
+		if tc.valid && errStr != "" {
+			t.Errorf("%s %s with argument '%s' unexpectedly rejected: %s", tc.method, tc.uri, tc.arg, errStr)
+		} else if !tc.valid && errStr == "" {
+			t.Errorf("%s %s with argument '%s' unexpectedly accepted", tc.method, tc.uri, tc.arg)
+		}
+
+		// the handler must still see the entire body
+		if b, err := io.ReadAll(c.Request().Body); err != nil || string(b) != tc.body {
+			t.Errorf("%s %s: body not preserved: %q %v", tc.method, tc.uri, b, err)
+		}
+	}
+}
@@ -1,10 +1,38 @@
 package main
 
+import "time"
+
 const (
 	listEligibleDefaultSize = 500
 	listEligibleMaxSize     = 2 << 20
 
 	showRecentFailuresHours = 24
 
+	requestPiecesMaxBatch = 256
+
+	// POST bodies are digested as part of V1 auth, and thus read into memory in full
+	// same as client_max_body_size in misc/nginx/spade_api.conf
+	signedBodyMaxBytes = 4 << 20
+
+	tenantPiecesMaxBatch = 8192
+
+	httpSourcesMaxPerPiece     = 16
+	httpTemplatesMaxPerDataset = 16
+	httpSourceMaxURLLength     = 2048
+
+	adminAuditLogDefaultSize = 100
+	adminAuditLogMaxSize     = 5000
+
+	sessionDefaultTTLHours = 24
+	sessionMaxTTLHours     = 7 * 24
+
+	// SP keys are resolved as of a finalized tipset, rounded down to this many epochs (1h)
+	spKeysHeightGranularity = 120
+
+	// the calls are made before taking requestPieceLockStatement, but still hold up the SP
+	reservationWebhookMaxTimeout = 1500 * time.Millisecond
+	requestPiecesWebhooksBudget  = 20 * time.Second
+
 	requestPieceLockStatement = `SELECT PG_ADVISORY_XACT_LOCK( 1234567890111 )`
+	tenantPiecesLockStatement = `SELECT PG_ADVISORY_XACT_LOCK( 1234567890112 )`
 )
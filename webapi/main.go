@@ -14,6 +14,7 @@ import (
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/go-toolbox/ufcli"
 	"github.com/ribasushi/spade/internal/app"
+	"golang.org/x/xerrors"
 )
 
 func setup() *echo.Echo {
@@ -76,6 +77,16 @@ func main() {
 		AppConfig: ufcli.App{
 			Name: cmdName,
 			Action: func(cctx *ufcli.Context) error {
+				// miner eligibility and collateral bounds are read from a full node
+				// no token is needed for that: the webapi never signs anything
+				if app.GetGlobalCtx(cctx.Context).LotusAPI[app.FilHeavy] == nil {
+					return xerrors.New("the webapi requires lotus-api-heavy to be set")
+				}
+
+				var err error
+				if adminKeys, err = parseAdminKeys(cctx.String("webapi-admin-keys")); err != nil {
+					return cmn.WrErr(err)
+				}
 				e = setup()
 				e.Server.BaseContext = func(net.Listener) context.Context { return cctx.Context }
 				return e.Start(cctx.String("webapi-listen-address"))
@@ -86,8 +97,12 @@ func main() {
 						Name:  "webapi-listen-address",
 						Value: "localhost:8080",
 					}),
+					ufcli.ConfStringFlag(&ufcli.StringFlag{
+						Name:        "webapi-admin-keys",
+						DefaultText: "  {{ operator1:f1...,operator2:f3... read from config file }}  ",
+					}),
 				},
-				app.CommonFlags...,
+				append(app.CommonFlags, app.HeavyFlags...)...,
 			),
 		},
 		GlobalInit: app.GlobalInit,
@@ -107,6 +122,9 @@ var logCfg = fmt.Sprintf("{%s}\n", strings.Join([]string{
 	`"status":${status}`,
 	`"took":"${latency_human}"`,
 	`"sp":"${header:X-SPADE-LOGGED-SP}"`,
+	`"tenant":"${header:X-SPADE-LOGGED-TENANT}"`,
+	`"auth_role":"${header:X-SPADE-LOGGED-ROLE}"`,
+	`"operator":"${header:X-SPADE-LOGGED-OPERATOR}"`,
 	`"bytes_in":${bytes_in}`,
 	`"bytes_out":${bytes_out}`,
 	`"op":"${method} ${host}${uri}"`,
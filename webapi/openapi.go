@@ -0,0 +1,593 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/labstack/echo/v4"
+)
+
+const openAPIPath = "/openapi.json"
+
+type apiParam struct {
+	name        string
+	in          string // "query" or "path"
+	schema      map[string]interface{}
+	description string
+}
+
+type apiRoute struct {
+	method      string
+	path        string // echo-style, i.e. /foo/:bar
+	summary     string
+	params      []apiParam
+	errCodes    []apitypes.APIErrorCode
+	payloadType reflect.Type
+	bodyType    reflect.Type // JSON request body, if any
+	streamable  bool         // supports Accept: application/x-ndjson
+	scheme      string       // Authorization scheme, authScheme if unspecified
+	singleUse   bool         // wrapped in singleUseAuth
+	legacyV0    bool         // wrapped in singleUseAuthLegacyV0 instead
+	signedOnly  bool         // wrapped in signedOnlyAuth
+}
+
+var (
+	paramTenant = apiParam{
+		name: "tenant", in: "query",
+		schema: map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 1 << 15},
+	}
+	paramDatasetSlug = apiParam{
+		name: "datasetSlug", in: "path",
+		schema:      map[string]interface{}{"type": "string", "pattern": `^[a-z0-9\-]+$`},
+		description: "The slug of a dataset associated with the authenticated tenant",
+	}
+	paramProviderID = apiParam{
+		name: "providerID", in: "path",
+		schema:      map[string]interface{}{"type": "string", "pattern": `^f0[0-9]+$`},
+		description: "The ID address of a storage provider, e.g. f01234",
+	}
+	paramPieceCID = apiParam{
+		name: "pieceCID", in: "path",
+		schema:      map[string]interface{}{"type": "string"},
+		description: "The v1 PieceCID (commP) to request a deal proposal for",
+	}
+)
+
+// apiRoutes is the machine-readable counterpart of registerRoutes()
+// The two are kept in sync by TestOpenAPIMatchesRoutes
+var apiRoutes = []apiRoute{
+	{
+		method:      http.MethodGet,
+		path:        "/sp/status",
+		summary:     "Human and machine readable information about the system and the currently-authenticated SP",
+		payloadType: reflect.TypeOf(spStatus{}),
+	},
+	{
+		method:  http.MethodGet,
+		path:    "/sp/eligible_pieces",
+		summary: "Near-real-time listing of PieceCIDs the authenticated SP is eligible to receive a deal for",
+		params: []apiParam{
+			{
+				name: "limit", in: "query",
+				schema:      map[string]interface{}{"type": "integer", "minimum": 1, "maximum": listEligibleMaxSize, "default": listEligibleDefaultSize},
+				description: "How many results to return at most",
+			},
+			withDescription(paramTenant, "Restrict the list to only pieces claimed by this numeric TenantID. No restriction if unspecified."),
+			{
+				name: "include-sourceless", in: "query",
+				schema:      map[string]interface{}{"type": "boolean"},
+				description: "When true the result includes eligible pieces without any known sources. Such pieces are omitted by default.",
+			},
+			{
+				name: "orglocal-only", in: "query",
+				schema:      map[string]interface{}{"type": "boolean"},
+				description: "When true restrict result only to pieces with active fil-network deals within your own Org.",
+			},
+			{
+				name: "continuation-token", in: "query",
+				schema: map[string]interface{}{"type": "string"},
+				description: "The continuation_token returned by a previous truncated listing, in order to fetch the next page. " +
+					"A token becomes invalid once the underlying list is recomputed, which happens periodically and independently of response_state_epoch.",
+			},
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf(apitypes.ResponsePiecesEligible{}),
+		streamable:  true,
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/sp/pending_proposals",
+		summary:     "Current outstanding reservations, recent errors and various statistics",
+		payloadType: reflect.TypeOf(spPendingProposals{}),
+		streamable:  true,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/sp/request_piece/:pieceCID",
+		legacyV0: true,
+		summary:  "Request a deal proposal (and thus reservation) for a specific PieceCID",
+		params: []apiParam{
+			paramPieceCID,
+			withDescription(paramTenant, "Restrict the deal proposal to a specific TenantID. The call will fail if the deal can not be granted by the specified tenant even if it would be allowed by a different tenant with interest in the same piece."),
+		},
+		errCodes: []apitypes.APIErrorCode{
+			apitypes.ErrInvalidRequest,
+			apitypes.ErrStorageProviderInfoTooOld,
+			apitypes.ErrStorageProviderUndialable,
+			errStorageProviderUnsupported,
+			apitypes.ErrStorageProviderSuspended,
+			apitypes.ErrStorageProviderIneligibleToMine,
+			apitypes.ErrUnclaimedPieceCID,
+			apitypes.ErrOversizedPiece,
+			apitypes.ErrProviderHasReplica,
+			apitypes.ErrTenantsOutOfDatacap,
+			apitypes.ErrTooManyReplicas,
+			apitypes.ErrProviderAboveMaxInFlight,
+			apitypes.ErrReplicationRulesViolation,
+			apitypes.ErrExternalReservationRefused,
+			apitypes.ErrUnauthorizedAccess,
+		},
+		payloadType: reflect.TypeOf(apitypes.ResponseDealRequest{}),
+	},
+	{
+		method:    http.MethodGet,
+		path:      "/sp/cancel_proposal/:proposalIDorPieceCID",
+		singleUse: true,
+		summary:   "Cancel a pending reservation which has not yet been published on chain, freeing up the in-flight quota and replica counts",
+		params: []apiParam{
+			{
+				name: "proposalIDorPieceCID", in: "path",
+				schema:      map[string]interface{}{"type": "string"},
+				description: "Either the ProposalID of a pending proposal, or the PieceCID for which all pending proposals should be cancelled",
+			},
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf([]spCancelledProposal{}),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/sp/request_pieces",
+		singleUse: true,
+		summary:   "Request deal proposals for a batch of PieceCIDs within a single transaction. Per-piece outcomes carry the same error codes as /sp/request_piece",
+		params: []apiParam{
+			withDescription(paramTenant, "Restrict the deal proposals to a specific TenantID, same as for /sp/request_piece"),
+		},
+		errCodes: []apitypes.APIErrorCode{
+			apitypes.ErrInvalidRequest,
+			apitypes.ErrStorageProviderInfoTooOld,
+			apitypes.ErrStorageProviderUndialable,
+			errStorageProviderUnsupported,
+			apitypes.ErrStorageProviderSuspended,
+			apitypes.ErrStorageProviderIneligibleToMine,
+		},
+		bodyType:    reflect.TypeOf([]string{}),
+		payloadType: reflect.TypeOf([]spPieceRequestResult{}),
+	},
+	{
+		method:     http.MethodPost,
+		path:       "/sp/sessions",
+		singleUse:  true,
+		signedOnly: true,
+		summary:    "Exchange a signature for a bearer session token. The token is displayed exactly once.",
+		params: []apiParam{
+			{
+				name: "scope", in: "query",
+				schema:      map[string]interface{}{"type": "string", "enum": []string{sessionScopeRead, sessionScopeReserve}, "default": sessionScopeRead},
+				description: "read permits only routes not changing state, reserve permits all routes",
+			},
+			{
+				name: "ttl-hours", in: "query",
+				schema:      map[string]interface{}{"type": "integer", "minimum": 1, "maximum": sessionMaxTTLHours, "default": sessionDefaultTTLHours},
+				description: "How long the session remains valid",
+			},
+			{
+				name: "label", in: "query",
+				schema:      map[string]interface{}{"type": "string"},
+				description: "Free-form description of the session, displayed when listing",
+			},
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf(spSessionIssued{}),
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/sp/sessions",
+		summary:     "List current and recently expired sessions",
+		payloadType: reflect.TypeOf([]spSession{}),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/sp/sessions/:sessionID/revoke",
+		singleUse: true,
+		summary:   "Revoke a session, also accepted with a bearer token of any scope belonging to the session being revoked",
+		params: []apiParam{
+			{
+				name: "sessionID", in: "path",
+				schema: map[string]interface{}{"type": "string", "format": "uuid"},
+			},
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf(spSession{}),
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/tenant/datasets",
+		summary:     "List of datasets associated with the authenticated tenant",
+		scheme:      tenantAuthScheme,
+		payloadType: reflect.TypeOf([]tenantDataset{}),
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/tenant/datasets/:datasetSlug/add_pieces",
+		singleUse:   true,
+		summary:     "Add a batch of pieces to a dataset. Either the entire batch is accepted or nothing is changed.",
+		scheme:      tenantAuthScheme,
+		params:      []apiParam{paramDatasetSlug},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		bodyType:    reflect.TypeOf([]tenantPieceSpec{}),
+		payloadType: reflect.TypeOf(tenantPiecesAdded{}),
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/tenant/datasets/:datasetSlug/remove_pieces",
+		singleUse:   true,
+		summary:     "Remove a batch of PieceCIDs from a dataset",
+		scheme:      tenantAuthScheme,
+		params:      []apiParam{paramDatasetSlug},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		bodyType:    reflect.TypeOf([]string{}),
+		payloadType: reflect.TypeOf(tenantPiecesRemoved{}),
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/tenant/datasets/:datasetSlug/set_http_templates",
+		singleUse:   true,
+		summary:     "Replace the HTTP(S) URL templates of a dataset. Recognized placeholders are {piece_cid} and {payload_cid}.",
+		scheme:      tenantAuthScheme,
+		params:      []apiParam{paramDatasetSlug},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		bodyType:    reflect.TypeOf([]string{}),
+		payloadType: reflect.TypeOf([]string{}),
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/tenant/datasets/:datasetSlug/replication",
+		summary:     "Replication progress of a dataset",
+		scheme:      tenantAuthScheme,
+		params:      []apiParam{paramDatasetSlug},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf(tenantReplication{}),
+	},
+	{
+		method:      http.MethodGet,
+		path:        "/admin/providers/:providerID",
+		summary:     "Operator-settable state of a provider",
+		scheme:      adminAuthScheme,
+		params:      []apiParam{paramProviderID},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf(adminProvider{}),
+	},
+	{
+		method:      http.MethodPost,
+		path:        "/admin/providers/:providerID/update",
+		singleUse:   true,
+		summary:     "Change the global settings of a provider",
+		scheme:      adminAuthScheme,
+		params:      []apiParam{paramProviderID},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		bodyType:    reflect.TypeOf(adminProviderUpdate{}),
+		payloadType: reflect.TypeOf(adminProvider{}),
+	},
+	{
+		method:    http.MethodPost,
+		path:      "/admin/tenants/:tenantID/providers/:providerID/update",
+		singleUse: true,
+		summary:   "Change the settings of a provider within a tenant",
+		scheme:    adminAuthScheme,
+		params: []apiParam{
+			{
+				name: "tenantID", in: "path",
+				schema: map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 1 << 15},
+			},
+			paramProviderID,
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		bodyType:    reflect.TypeOf(adminTenantProviderUpdate{}),
+		payloadType: reflect.TypeOf(adminProvider{}),
+	},
+	{
+		method:  http.MethodGet,
+		path:    "/admin/audit_log",
+		summary: "Most recent administrative changes, newest first",
+		scheme:  adminAuthScheme,
+		params: []apiParam{
+			{
+				name: "limit", in: "query",
+				schema: map[string]interface{}{"type": "integer", "minimum": 1, "maximum": adminAuditLogMaxSize, "default": adminAuditLogDefaultSize},
+			},
+			withDescription(apiParam{name: "provider", in: "query", schema: paramProviderID.schema}, "Restrict the list to changes affecting this SP"),
+		},
+		errCodes:    []apitypes.APIErrorCode{apitypes.ErrInvalidRequest},
+		payloadType: reflect.TypeOf([]adminAuditEntry{}),
+	},
+}
+
+func withDescription(p apiParam, d string) apiParam {
+	p.description = d
+	return p
+}
+
+var (
+	openAPIDoc     map[string]interface{}
+	openAPIDocOnce sync.Once
+)
+
+func apiOpenAPISpec(c echo.Context) error {
+	openAPIDocOnce.Do(func() { openAPIDoc = genOpenAPIDoc() })
+	return c.JSONPretty(http.StatusOK, openAPIDoc, "  ")
+}
+
+var echoPathParam = regexp.MustCompile(`:([^/]+)`)
+
+func genOpenAPIDoc() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	envelopeRef := schemaOf(reflect.TypeOf(responseEnvelope{}), schemas)
+
+	paths := make(map[string]interface{}, len(apiRoutes))
+	for _, r := range apiRoutes {
+		params := make([]interface{}, 0, len(r.params))
+		for _, p := range r.params {
+			po := map[string]interface{}{
+				"name":     p.name,
+				"in":       p.in,
+				"required": p.in == "path",
+				"schema":   p.schema,
+			}
+			if p.description != "" {
+				po["description"] = p.description
+			}
+			params = append(params, po)
+		}
+
+		respWith := func(desc string, payload map[string]interface{}, codes []apitypes.APIErrorCode) map[string]interface{} {
+			props := map[string]interface{}{"response": payload}
+			if len(codes) > 0 {
+				ints := make([]interface{}, len(codes))
+				slugs := make([]interface{}, len(codes))
+				for i, ec := range codes {
+					ints[i] = int(ec)
+					slugs[i] = errSlug(ec)
+				}
+				props["error_code"] = map[string]interface{}{"type": "integer", "enum": ints}
+				props["error_slug"] = map[string]interface{}{"type": "string", "enum": slugs}
+			}
+			return map[string]interface{}{
+				"description": desc,
+				"content": map[string]interface{}{
+					echo.MIMEApplicationJSON: map[string]interface{}{
+						"schema": map[string]interface{}{
+							"allOf": []interface{}{
+								envelopeRef,
+								map[string]interface{}{"type": "object", "properties": props},
+							},
+						},
+					},
+				},
+			}
+		}
+
+		scheme := r.scheme
+		if scheme == "" {
+			scheme = authScheme
+		}
+
+		authDesc := "Missing or invalid " + scheme + " Authorization header"
+		if r.singleUse {
+			authDesc += ", or a header that has already been used before: this route changes state and requires a fresh, preferably V1, header"
+		} else if r.legacyV0 {
+			authDesc += ", or a V1 header that has already been used before: this route changes state and requires a fresh V1 header. " +
+				"Reusing a " + authScheme + " header within its epoch remains possible, but is deprecated: such responses carry a `Deprecation: true` header"
+		}
+		responses := map[string]interface{}{
+			"200": respWith("Success", schemaOf(r.payloadType, schemas), nil),
+			"401": respWith(authDesc, map[string]interface{}{"nullable": true}, []apitypes.APIErrorCode{apitypes.ErrUnauthorizedAccess}),
+		}
+		if r.streamable {
+			responses["200"].(map[string]interface{})["content"].(map[string]interface{})[mimeNDJSON] = map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "string",
+					"description": "Newline-delimited JSON: a {\"header\":{...}} line, followed by one line per list entry, " +
+						"followed by a {\"trailer\":{...}} line carrying response_entries, info/error lines and any non-list part of the response",
+				},
+			}
+		}
+		if scheme == authScheme {
+			responses["429"] = respWith(
+				"Per-SP request rate limit exceeded, retry after the number of seconds in the Retry-After header",
+				map[string]interface{}{"nullable": true},
+				[]apitypes.APIErrorCode{errRateLimited},
+			)
+		}
+		if len(r.errCodes) > 0 {
+			// a subset of the errors carry a payload, e.g. ResponseDealRequest
+			responses["403"] = respWith("Request refused, consult error_code / error_lines", map[string]interface{}{}, r.errCodes)
+		}
+
+		security := []interface{}{map[string]interface{}{scheme: []interface{}{}}}
+		if scheme == authScheme && !r.signedOnly {
+			security = append(security, map[string]interface{}{bearerAuthScheme: []interface{}{}})
+		}
+
+		op := map[string]interface{}{
+			"summary":     r.summary,
+			"operationId": strings.ToLower(r.method) + strings.ReplaceAll(echoPathParam.ReplaceAllString(r.path, "by_$1"), "/", "_"),
+			"security":    security,
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if r.bodyType != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					echo.MIMEApplicationJSON: map[string]interface{}{"schema": schemaOf(r.bodyType, schemas)},
+				},
+			}
+		}
+
+		oaPath := echoPathParam.ReplaceAllString(r.path, "{$1}")
+		if _, exists := paths[oaPath]; !exists {
+			paths[oaPath] = make(map[string]interface{}, 1)
+		}
+		paths[oaPath].(map[string]interface{})[strings.ToLower(r.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "♠️ (Spade) Storage Provider and Tenant API",
+			"version": "v0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				authScheme: map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": echo.HeaderAuthorization,
+					"description": authScheme + " {{ current fil epoch }};{{ SP ID e.g. f01234 }};{{ base64 signature }}[;{{ optional base64 signed argument }}] " +
+						"where the signature is made by the SP worker key over 0x202020 || beacon( epoch ) || argument. " +
+						"Reference implementation: https://raw.githubusercontent.com/ribasushi/bash-fil-spid-v0/5f41eec1a/fil-spid.bash . " +
+						"The " + authSchemeV1 + " variant has an identical format, but the argument is mandatory and must read " +
+						"'{{ METHOD }} {{ request URI }}[ {{ hex SHA-256 of the request body, POST only }}][ {{ nonce }}]', binding the signature to a single request.",
+				},
+				adminAuthScheme: map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": echo.HeaderAuthorization,
+					"description": adminAuthScheme + " {{ current fil epoch }};{{ operator key address }};{{ base64 signature }};{{ base64 signed argument }} " +
+						"where the signature is made by an operator key listed in the webapi configuration, and the argument binds " +
+						"the signature to a single request in the same way as " + authSchemeV1 + ".",
+				},
+				bearerAuthScheme: map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Session token issued by POST /sp/sessions",
+				},
+				tenantAuthScheme: map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": echo.HeaderAuthorization,
+					"description": tenantAuthScheme + " {{ current fil epoch }};{{ tenant client address e.g. f1... or f3... }};{{ base64 signature }}[;{{ optional base64 signed argument }}] " +
+						"where the signature is made by the client key over 0x202020 || beacon( epoch ) || argument. " +
+						"The " + tenantAuthSchemeV1 + " variant binds the signature to a single request in the same way as " + authSchemeV1 + ".",
+				},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns a JSON-schema compatible description of t, named structs
+// are stored in defs and returned as a $ref
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), defs)
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+	default:
+		panic(fmt.Sprintf("unable to describe type %s", t))
+	}
+
+	if t.Name() != "" {
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, seen := defs[t.Name()]; seen {
+			return ref
+		}
+		defs[t.Name()] = nil // placeholder: guards against recursive types
+	}
+
+	props := make(map[string]interface{}, t.NumField())
+	required := make([]string, 0, t.NumField())
+	var addFields func(reflect.Type)
+	addFields = func(st reflect.Type) {
+		for i := 0; i < st.NumField(); i++ {
+			f := st.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				if f.Type.Kind() == reflect.Struct {
+					addFields(f.Type)
+					continue
+				} else if f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct {
+					addFields(f.Type.Elem())
+					continue
+				}
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if _, shadowed := props[name]; shadowed {
+				continue
+			}
+			props[name] = schemaOf(f.Type, defs)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	if t.Name() == "" {
+		return s
+	}
+	defs[t.Name()] = s
+	return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+}
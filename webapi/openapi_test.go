@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	// routes echo adds on its own for group-middleware and catch-all handling
+	notFoundName := runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+
+	registered := make(map[string]struct{})
+	for _, r := range setup().Routes() {
+		if strings.HasSuffix(r.Path, "*") || r.Name == notFoundName || r.Path == openAPIPath {
+			continue
+		}
+		registered[r.Method+" "+r.Path] = struct{}{}
+	}
+
+	documented := make(map[string]struct{}, len(apiRoutes))
+	for _, r := range apiRoutes {
+		k := r.method + " " + r.path
+		if _, dup := documented[k]; dup {
+			t.Errorf("route %s documented more than once", k)
+		}
+		documented[k] = struct{}{}
+		if _, found := registered[k]; !found {
+			t.Errorf("route %s is documented but not registered", k)
+		}
+		for _, ec := range r.errCodes {
+			if strings.HasPrefix(errSlug(ec), "APIErrorCode(") {
+				t.Errorf("route %s lists unknown error code %d", k, ec)
+			}
+		}
+	}
+	for k := range registered {
+		if _, found := documented[k]; !found {
+			t.Errorf("route %s is registered but not documented", k)
+		}
+	}
+
+	doc := genOpenAPIDoc()
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("unable to serialize OpenAPI document: %s", err)
+	}
+	if got, exp := len(doc["paths"].(map[string]interface{})), len(apiRoutes); got > exp {
+		t.Errorf("OpenAPI document contains %d paths, more than the %d documented routes", got, exp)
+	}
+}
@@ -3,8 +3,23 @@ package main
 import "github.com/labstack/echo/v4"
 
 // This lists in one place all recognized routes & parameters
-// FIXME - we should make an openapi or something for this...
+// (!) when modifying make sure it aligns with the apiRoutes description in openapi.go
+//
+// Routes changing state are wrapped in singleUseAuth: they only accept an Authorization header
+// that has never been seen before. Such requests should use the V1 auth schemes, which bind the
+// signature to the method, path and query of the request, and for POST to the request body.
+//
+// The one exception is /sp/request_piece, which predates single-use headers: it is wrapped in
+// singleUseAuthLegacyV0 instead, which keeps accepting a FIL-SPID-V0 header repeatedly within its
+// epoch. This is deprecated, responses to such requests carry a `Deprecation: true` header.
 func registerRoutes(e *echo.Echo) {
+
+	//
+	// /openapi.json produces an OpenAPI 3 description of all routes below. No authentication required.
+	//
+	e.GET(openAPIPath, apiOpenAPISpec)
+
+	// All /sp/ routes are subject to per-SP, per-route request rate limits, see rateLimitDefaults
 	spRoutes := e.Group("/sp", spidAuth)
 
 	//
@@ -19,6 +34,8 @@ func registerRoutes(e *echo.Echo) {
 	// The list is dynamic and offers a near-real-time view specific to the authenticated SP answering:
 	// "What can I reserve/request right this moment"
 	//
+	// Supports streaming of the result via `Accept: application/x-ndjson`
+	//
 	// Recognized parameters:
 	//
 	// - limit = <integer>
@@ -34,10 +51,16 @@ func registerRoutes(e *echo.Echo) {
 	// - orglocal-only = <boolean>
 	//   When true restrict result only to pieces with active fil-network deals within your own Org.
 	//
+	// - continuation-token = <string>
+	//   The continuation_token returned by a previous truncated listing, in order to fetch the next page.
+	//   A token becomes invalid once the underlying list is recomputed, which happens periodically and
+	//   independently of response_state_epoch.
+	//
 	spRoutes.GET("/eligible_pieces", apiSpListEligible)
 
 	//
 	// /pending_proposals produces a list of current outstanding reservations, recent errors and various statistics.
+	// Supports streaming of the result via `Accept: application/x-ndjson`
 	//
 	// Recognized parameters: none
 	//
@@ -60,5 +83,151 @@ func registerRoutes(e *echo.Echo) {
 	//   Restrict the deal proposal to a specific TenantID. The call will fail if the deal can not be granted by
 	//   the specified tenant even if it would be allowed by a different tenant with interest in the same piece.
 	//
-	spRoutes.GET("/request_piece/:pieceCID", apiSpRequestPiece)
+	spRoutes.GET("/request_piece/:pieceCID", apiSpRequestPiece, singleUseAuthLegacyV0)
+
+	//
+	// /cancel_proposal/:proposalIDorPieceCID releases a pending reservation, identified either by its
+	// ProposalID or by the PieceCID it is for. Proposals that have already been published on chain
+	// can not be cancelled. On success the in-flight quota and replica counts are immediately freed up.
+	//
+	// Recognized parameters: none
+	//
+	spRoutes.GET("/cancel_proposal/:proposalIDorPieceCID", apiSpCancelProposal, singleUseAuth)
+
+	//
+	// /request_pieces is the batch form of /request_piece. The body is a JSON array of up to
+	// requestPiecesMaxBatch PieceCIDs, all of which are evaluated in order within a single transaction.
+	// The result lists the outcome of each individual PieceCID, with the same error codes and replication
+	// states as /request_piece. In-flight limits are applied cumulatively across the entire batch.
+	//
+	// Recognized parameters:
+	//
+	// - tenant = <integer>
+	//   Restrict the deal proposals to a specific TenantID, same as for /request_piece
+	//
+	spRoutes.POST("/request_pieces", apiSpRequestPieces, singleUseAuth)
+
+	//
+	// /sessions exchanges a valid signature for a bearer token, usable as an `Authorization: Bearer ...`
+	// header with any /sp/ route, except for this one. The token is displayed exactly once.
+	//
+	// Recognized parameters:
+	//
+	// - scope = read | reserve
+	//   read ( the default ) permits only routes not changing state, reserve permits all routes
+	//
+	// - ttl-hours = <integer>
+	//   How long the session remains valid, defaults to 24, at most 168
+	//
+	// - label = <string>
+	//   Free-form description of the session, displayed when listing
+	//
+	spRoutes.POST("/sessions", apiSpCreateSession, signedOnlyAuth, singleUseAuth)
+
+	//
+	// /sessions lists current and recently expired sessions of the authenticated SP
+	//
+	// Recognized parameters: none
+	//
+	spRoutes.GET("/sessions", apiSpListSessions)
+
+	//
+	// /sessions/:sessionID/revoke immediately invalidates a session. Same as any other state-changing
+	// route it requires a never-before-seen signed header or a reserve-scoped bearer token, with one
+	// exception: the bearer token of the very session being revoked is accepted regardless of scope.
+	//
+	// Recognized parameters: none
+	//
+	spRoutes.POST("/sessions/:sessionID/revoke", apiSpRevokeSession, revokeSessionAuth)
+
+	tenantRoutes := e.Group("/tenant", tenantAuth)
+
+	//
+	// /datasets produces a list of datasets associated with the currently-authenticated tenant
+	//
+	// Recognized parameters: none
+	//
+	tenantRoutes.GET("/datasets", apiTenantListDatasets)
+
+	//
+	// /datasets/:datasetSlug/add_pieces adds a batch of pieces to a dataset. The body is a JSON array
+	// of { "piece_cid": ..., "padded_piece_size": ..., "payload_cid": ..., "http_urls": [...] } objects,
+	// payload_cid and http_urls being optional. When present http_urls replaces the list of HTTP(S)
+	// locations of the piece within this dataset. Either the entire batch is accepted or nothing is changed.
+	//
+	// Recognized parameters: none
+	//
+	tenantRoutes.POST("/datasets/:datasetSlug/add_pieces", apiTenantAddPieces, singleUseAuth)
+
+	//
+	// /datasets/:datasetSlug/remove_pieces removes a batch of pieces from a dataset. The body is a JSON
+	// array of PieceCID strings. Pieces not part of the dataset are listed in the response.
+	//
+	// Recognized parameters: none
+	//
+	tenantRoutes.POST("/datasets/:datasetSlug/remove_pieces", apiTenantRemovePieces, singleUseAuth)
+
+	//
+	// /datasets/:datasetSlug/set_http_templates replaces the list of URL templates applying to every
+	// piece of a dataset. The body is a JSON array of strings, each containing at least one of the
+	// {piece_cid} or {payload_cid} placeholders. An empty array removes all templates.
+	//
+	// Recognized parameters: none
+	//
+	tenantRoutes.POST("/datasets/:datasetSlug/set_http_templates", apiTenantSetHTTPTemplates, singleUseAuth)
+
+	//
+	// /datasets/:datasetSlug/replication produces replication progress information for a dataset
+	//
+	// Recognized parameters: none
+	//
+	tenantRoutes.GET("/datasets/:datasetSlug/replication", apiTenantDatasetReplication)
+
+	//
+	// Administrative routes are accessible only with the FIL-ADMIN-V1 scheme, signed by one of the
+	// operator keys listed in the webapi-admin-keys configuration entry. Every change is recorded in
+	// spd.admin_audit_log, alongside the name of the operator and the state before and after.
+	//
+	adminRoutes := e.Group("/admin", adminAuth)
+
+	//
+	// /providers/:providerID produces the operator-settable state of a provider, across all tenants
+	//
+	// Recognized parameters: none
+	//
+	adminRoutes.GET("/providers/:providerID", apiAdminGetProvider)
+
+	//
+	// /providers/:providerID/update changes the global settings of a provider. The body is a JSON
+	// object with any of:
+	//   { "globally_inactivated": bool, "ignore_chain_eligibility": bool, "location": { "org_id": ..., "city_id": ..., "country_id": ..., "continent_id": ... } }
+	// Omitted fields are left as-is, false removes a flag.
+	//
+	// Recognized parameters: none
+	//
+	adminRoutes.POST("/providers/:providerID/update", apiAdminUpdateProvider, singleUseAuth)
+
+	//
+	// /tenants/:tenantID/providers/:providerID/update changes the settings of a provider within a
+	// single tenant. The body is a JSON object with any of:
+	//   { "inactivated": bool, "max_in_flight_GiB": integer }
+	// Omitted fields are left as-is, false removes a flag, a negative max_in_flight_GiB reverts to the tenant default.
+	//
+	// Recognized parameters: none
+	//
+	adminRoutes.POST("/tenants/:tenantID/providers/:providerID/update", apiAdminUpdateTenantProvider, singleUseAuth)
+
+	//
+	// /audit_log lists the most recent administrative changes, newest first
+	//
+	// Recognized parameters:
+	//
+	// - limit = <integer>
+	//   How many entries to return at most
+	//   default=adminAuditLogDefaultSize
+	//
+	// - provider = <string>
+	//   Restrict the list to changes affecting this SP, e.g. f01234
+	//
+	adminRoutes.GET("/audit_log", apiAdminListAuditLog)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -12,14 +13,33 @@ import (
 
 	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
 	"github.com/dgraph-io/ristretto"
+	"github.com/ipfs/go-cid"
 	"github.com/jackc/pgx/v4"
 	"github.com/labstack/echo/v4"
+	"github.com/multiformats/go-multihash"
 	"github.com/ribasushi/go-toolbox-interplanetary/fil"
 	"github.com/ribasushi/go-toolbox/cmn"
 	"github.com/ribasushi/spade/internal/app"
 	"golang.org/x/xerrors"
 )
 
+// Error codes which are not (yet) part of apitypes, hence the errSlug() special cases
+const (
+	errStorageProviderUnsupported apitypes.APIErrorCode = 4043
+	errRateLimited                apitypes.APIErrorCode = 4429 // always returned with HTTP 429 and a Retry-After header
+)
+
+func errSlug(ec apitypes.APIErrorCode) string {
+	switch ec {
+	case errStorageProviderUnsupported:
+		return "ErrStorageProviderUnsupported"
+	case errRateLimited:
+		return "ErrRateLimited"
+	default:
+		return ec.String()
+	}
+}
+
 func truthyBoolQueryParam(c echo.Context, pname string) bool {
 	if !c.QueryParams().Has(pname) {
 		return false
@@ -43,32 +63,105 @@ func parseUIntQueryParam(c echo.Context, pname string, min, max uint64) (uint64,
 	return val, nil
 }
 
-func retPayloadAnnotated(c echo.Context, httpCode int, errCode apitypes.APIErrorCode, payload apitypes.ResponsePayload, fmsg string, args ...interface{}) error {
-	ctx, ctxMeta := unpackAuthedEchoContext(c)
+// decodeBatch reads a JSON array of at most maxEntries from the request body into dst,
+// returning a non-empty string describing the problem if the body is not acceptable
+func decodeBatch[T any](c echo.Context, dst *[]T, maxEntries int) string {
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return fmt.Sprintf("unable to decode request body: %s", err)
+	}
+	if len(*dst) == 0 {
+		return "request body must be a non-empty JSON array"
+	}
+	if len(*dst) > maxEntries {
+		return fmt.Sprintf("request body contains %d entries, more than the maximum of %d", len(*dst), maxEntries)
+	}
+	return ""
+}
 
-	msg := fmt.Sprintf(fmsg, args...)
+// parsePieceCID parses a string, returning an error if it is not a v1 PieceCID (commP)
+func parsePieceCID(s string) (cid.Cid, error) {
+	pCid, err := cid.Parse(s)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if pCid.Prefix().Codec != cid.FilCommitmentUnsealed || pCid.Prefix().MhType != multihash.SHA2_256_TRUNC254_PADDED {
+		return cid.Undef, xerrors.Errorf(
+			"does not have expected codec (%x) and multihash (%x)",
+			cid.FilCommitmentUnsealed,
+			multihash.SHA2_256_TRUNC254_PADDED,
+		)
+	}
+	return pCid, nil
+}
 
-	var lines []string
-	if msg != "" {
-		lines = strings.Split(msg, "\n")
-		longest := 0
-		for _, l := range lines {
-			encLen := len([]rune(l)) + strings.Count(l, `"`)
-			if encLen > longest {
-				longest = encLen
-			}
-		}
-		for i, l := range lines {
-			lines[i] = fmt.Sprintf(" %*s", -longest-1+strings.Count(l, `"`), l)
+// validateHTTPSourceURL returns an error if a tenant-supplied URL is not something SPs can be pointed at
+func validateHTTPSourceURL(s string) error {
+	if len(s) > httpSourceMaxURLLength {
+		return xerrors.Errorf("longer than the maximum of %d characters", httpSourceMaxURLLength)
+	}
+	if strings.ContainsAny(s, "'\"` \t\r\n") {
+		return xerrors.New("must not contain quotes or whitespace")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return xerrors.Errorf("unsupported scheme '%s', only http and https are accepted", u.Scheme)
+	}
+	if u.Host == "" {
+		return xerrors.New("no host specified")
+	}
+	if u.User != nil {
+		return xerrors.New("must not contain credentials")
+	}
+	return nil
+}
+
+// responseEnvelope is an apitypes.ResponseEnvelope able to carry payloads
+// that are specific to this webapi, and are not (yet) part of apitypes
+type responseEnvelope struct {
+	apitypes.ResponseEnvelope
+	ContinuationToken string      `json:"continuation_token,omitempty"`
+	Response          interface{} `json:"response"`
+}
+
+// msgLines splits a message into equal-width lines, for a nicer display within a JSON envelope
+func msgLines(msg string) []string {
+	if msg == "" {
+		return nil
+	}
+	lines := strings.Split(msg, "\n")
+	longest := 0
+	for _, l := range lines {
+		encLen := len([]rune(l)) + strings.Count(l, `"`)
+		if encLen > longest {
+			longest = encLen
 		}
 	}
+	for i, l := range lines {
+		lines[i] = fmt.Sprintf(" %*s", -longest-1+strings.Count(l, `"`), l)
+	}
+	return lines
+}
 
-	r := apitypes.ResponseEnvelope{
-		RequestID:          c.Request().Header.Get("X-SPADE-REQUEST-UUID"),
-		ResponseStateEpoch: int64(ctxMeta.stateEpoch),
-		ResponseTime:       time.Now(),
-		ResponseCode:       httpCode,
-		Response:           payload,
+func retPayloadAnnotated(c echo.Context, httpCode int, errCode apitypes.APIErrorCode, payload interface{}, fmsg string, args ...interface{}) error {
+	ctx, ctxMeta := unpackAuthedEchoContext(c)
+
+	msg := fmt.Sprintf(fmsg, args...)
+	lines := msgLines(msg)
+
+	r := responseEnvelope{
+		ResponseEnvelope: apitypes.ResponseEnvelope{
+			RequestID:          c.Request().Header.Get("X-SPADE-REQUEST-UUID"),
+			ResponseStateEpoch: int64(ctxMeta.stateEpoch),
+			ResponseTime:       time.Now(),
+			ResponseCode:       httpCode,
+		},
+		Response:          payload,
+		ContinuationToken: c.Response().Header().Get(continuationTokenHeader),
 	}
 
 	pv := reflect.ValueOf(payload)
@@ -85,7 +178,7 @@ func retPayloadAnnotated(c echo.Context, httpCode int, errCode apitypes.APIError
 		r.InfoLines = lines
 	} else {
 		r.ErrCode = int(errCode)
-		r.ErrSlug = errCode.String()
+		r.ErrSlug = errSlug(errCode)
 		r.ErrLines = lines
 
 		if r.RequestID != "" && (msg != "" || errCode != 0) {
@@ -108,7 +201,7 @@ func retPayloadAnnotated(c echo.Context, httpCode int, errCode apitypes.APIError
 				`,
 				msg,
 				int(errCode),
-				errCode.String(),
+				errSlug(errCode),
 				jPayload,
 				r.RequestID,
 			); err != nil {
@@ -117,8 +210,23 @@ func retPayloadAnnotated(c echo.Context, httpCode int, errCode apitypes.APIError
 		}
 	}
 
-	// FIXME - only prettify on curl and similar
-	return c.JSONPretty(httpCode, r, "  ")
+	if isInteractiveClient(c) {
+		return c.JSONPretty(httpCode, r, "  ")
+	}
+	return c.JSON(httpCode, r)
+}
+
+var interactiveUAs = []string{"curl/", "wget/", "httpie/", "mozilla/"}
+
+// isInteractiveClient returns true if the response is likely to be read by a human
+func isInteractiveClient(c echo.Context) bool {
+	ua := strings.ToLower(c.Request().UserAgent())
+	for _, pref := range interactiveUAs {
+		if strings.HasPrefix(ua, pref) {
+			return true
+		}
+	}
+	return false
 }
 
 func curlAuthedForSP(c echo.Context, spID fil.ActorID, path string) string {
@@ -146,8 +254,8 @@ func retFail(c echo.Context, errCode apitypes.APIErrorCode, fMsg string, args ..
 	)
 }
 
-func retAuthFail(c echo.Context, f string, args ...interface{}) error {
-	c.Response().Header().Set(echo.HeaderWWWAuthenticate, authScheme)
+func retAuthFail(c echo.Context, scheme string, f string, args ...interface{}) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, scheme)
 	return retPayloadAnnotated(
 		c,
 		http.StatusUnauthorized,
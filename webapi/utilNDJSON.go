@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox/cmn"
+)
+
+const (
+	mimeNDJSON = "application/x-ndjson"
+
+	// how many rows to accumulate before injecting sources and emitting them
+	streamChunkSize = 2048
+)
+
+// wantsNDJSON returns true when the client explicitly asked for a line-delimited response
+func wantsNDJSON(c echo.Context) bool {
+	for _, a := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		if mt, _, _ := strings.Cut(a, ";"); strings.TrimSpace(mt) == mimeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// An NDJSON response consists of:
+//   - a single {"header":{...}} line with the same metadata as a regular envelope
+//   - zero or more lines, each representing one entry of the regular response list
+//   - a single {"trailer":{...}} line with the entry count, info/error lines and any
+//     non-list part of the regular response
+type ndjsonHeader struct {
+	RequestID          string    `json:"request_id,omitempty"`
+	ResponseTime       time.Time `json:"response_timestamp"`
+	ResponseStateEpoch int64     `json:"response_state_epoch,omitempty"`
+	ResponseCode       int       `json:"response_code"`
+}
+type ndjsonTrailer struct {
+	ResponseEntries   int         `json:"response_entries"`
+	ContinuationToken string      `json:"continuation_token,omitempty"`
+	ErrLines          []string    `json:"error_lines,omitempty"`
+	InfoLines         []string    `json:"info_lines,omitempty"`
+	Summary           interface{} `json:"summary,omitempty"`
+}
+
+type ndjsonStream struct {
+	c       echo.Context
+	enc     *json.Encoder
+	entries int
+}
+
+// startNDJSON commits a 200 response and emits the header line: any error past
+// this point can only be communicated via the trailer
+func startNDJSON(c echo.Context) (*ndjsonStream, error) {
+	_, ctxMeta := unpackAuthedEchoContext(c)
+
+	c.Response().Header().Set(echo.HeaderContentType, mimeNDJSON)
+	c.Response().Header().Set("X-Accel-Buffering", "no") // do not let nginx hold on to the stream
+	c.Response().WriteHeader(http.StatusOK)
+
+	s := &ndjsonStream{
+		c:   c,
+		enc: json.NewEncoder(c.Response()),
+	}
+	if err := s.enc.Encode(struct {
+		Header ndjsonHeader `json:"header"`
+	}{
+		ndjsonHeader{
+			RequestID:          c.Request().Header.Get("X-SPADE-REQUEST-UUID"),
+			ResponseTime:       time.Now(),
+			ResponseStateEpoch: ctxMeta.stateEpoch,
+			ResponseCode:       http.StatusOK,
+		},
+	}); err != nil {
+		return nil, cmn.WrErr(err)
+	}
+	return s, nil
+}
+
+func (s *ndjsonStream) entry(e interface{}) error {
+	s.entries++
+	return s.enc.Encode(e)
+}
+
+func (s *ndjsonStream) flush() { s.c.Response().Flush() }
+
+func (s *ndjsonStream) finish(t ndjsonTrailer) error {
+	t.ResponseEntries = s.entries
+	if err := s.enc.Encode(struct {
+		Trailer ndjsonTrailer `json:"trailer"`
+	}{t}); err != nil {
+		return cmn.WrErr(err)
+	}
+	s.flush()
+	return nil
+}
+
+// abort emits a trailer indicating the stream is incomplete, and returns the
+// original error for logging. Echo will not attempt to write anything further
+// since the response is already committed.
+func (s *ndjsonStream) abort(err error) error {
+	s.finish(ndjsonTrailer{ //nolint:errcheck
+		ErrLines: msgLines("The response stream was interrupted by an internal error and is incomplete.\nPlease retry your request."),
+	})
+	return err
+}
@@ -32,17 +32,25 @@ func injectSources(ctx context.Context, toFill piecePointers, onlyOrg int16) err
 
 	pieceLocks := make(map[int64]*sync.Mutex, 128<<10)
 	filSrcIDs := make([]int64, 0, len(toFill))
+	httpSrcIDs := make([]int64, 0, len(toFill))
 	for pieceID, p := range toFill {
 		var useLock sync.Mutex
 		if p.HasSourcesFilActive {
 			filSrcIDs = append(filSrcIDs, pieceID)
 			pieceLocks[pieceID] = &useLock
 		}
+		if p.HasSourcesHTTP {
+			httpSrcIDs = append(httpSrcIDs, pieceID)
+			pieceLocks[pieceID] = &useLock
+		}
 	}
 
 	if len(filSrcIDs) > 0 {
 		eg.Go(func() error { return injectActiveFilDAG(ctx, filSrcIDs, toFill, onlyOrg, pieceLocks) })
 	}
+	if len(httpSrcIDs) > 0 {
+		eg.Go(func() error { return injectHTTP(ctx, httpSrcIDs, toFill, pieceLocks) })
+	}
 
 	if err := eg.Wait(); err != nil {
 		return cmn.WrErr(err)
@@ -114,3 +122,79 @@ func injectActiveFilDAG(ctx context.Context, ids []int64, ptrs piecePointers, on
 
 	return cmn.WrErr(rows.Err())
 }
+
+// httpSource represents a CAR file retrievable over plain HTTP(S), as registered by a tenant.
+// It is not part of the apitypes package (yet), hence it is defined here.
+type httpSource struct {
+	SourceType string `json:"source_type"`
+
+	// http specific
+	URL          string `json:"url"`
+	SampleGetCmd string `json:"sample_get_cmd"`
+}
+
+func (s *httpSource) SrcType() string { return s.SourceType }
+
+var _ apitypes.DataSource = &httpSource{}
+
+func injectHTTP(ctx context.Context, ids []int64, ptrs piecePointers, pieceLocks map[int64]*sync.Mutex) error {
+
+	// onlyOrg does not apply: an HTTP source is not tied to any SP
+	rows, err := app.GetGlobalCtx(ctx).Db[app.DbMain].Query(
+		ctx,
+		`
+		SELECT
+				piece_id,
+				source_url
+			FROM spd.datasets_pieces_http_sources
+		WHERE
+			piece_id = ANY ( $1 )
+			UNION
+		SELECT
+				dp.piece_id,
+				REPLACE(
+					REPLACE( dht.url_template, '{piece_cid}', p.piece_cid ),
+					'{payload_cid}', COALESCE( p.proposal_label, '' )
+				) AS source_url
+			FROM spd.datasets_pieces dp
+			JOIN spd.datasets_http_templates dht USING ( dataset_id )
+			JOIN spd.pieces p USING ( piece_id )
+		WHERE
+			dp.piece_id = ANY ( $1 )
+				AND
+			(
+				dht.url_template NOT LIKE '%{payload\_cid}%'
+					OR
+				p.proposal_label IS NOT NULL
+			)
+		ORDER BY
+			piece_id,
+			source_url
+		`,
+		ids,
+	)
+	if err != nil {
+		return cmn.WrErr(err)
+	}
+	defer rows.Close()
+
+	var pieceID int64
+	for rows.Next() {
+		var srcEntry httpSource
+		if err := rows.Scan(&pieceID, &srcEntry.URL); err != nil {
+			return cmn.WrErr(err)
+		}
+		p := ptrs[pieceID]
+		srcEntry.SourceType = "HTTP"
+		srcEntry.SampleGetCmd = fmt.Sprintf(
+			"curl -sLo $(pwd)/%s.car '%s'",
+			apitypes.TrimCidString(p.pieceCid),
+			srcEntry.URL,
+		)
+		pieceLocks[pieceID].Lock()
+		*p.sourcesPointer = append(*p.sourcesPointer, &srcEntry)
+		pieceLocks[pieceID].Unlock()
+	}
+
+	return cmn.WrErr(rows.Err())
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/ribasushi/go-toolbox-interplanetary/fil"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"github.com/ribasushi/spade/internal/app"
+)
+
+// rateLimit describes a token bucket: up to Burst requests at once, refilled at PerMinute
+type rateLimit struct {
+	Burst     float64 `json:"burst"`
+	PerMinute float64 `json:"per_minute"` // 0 disables the limit
+}
+
+// rateLimitDefaults are keyed by route path as registered in registerRoutes(), with "*" applying to
+// every route. Each SP has a separate bucket for each route.
+//
+// Any of the fields can be overridden, in increasing order of precedence, by:
+// - spd.global.metadata->'rate_limits'
+// - spd.providers.provider_meta->'rate_limits'
+// using the same structure, e.g.
+//
+//	{ "*": { "per_minute": 120 }, "/sp/eligible_pieces": { "burst": 2, "per_minute": 1 } }
+var rateLimitDefaults = map[string]rateLimit{
+	"*":                     {Burst: 60, PerMinute: 60},
+	"/sp/eligible_pieces":   {Burst: 10, PerMinute: 6},
+	"/sp/pending_proposals": {Burst: 20, PerMinute: 12},
+}
+
+var rateLimitDefaultsJSON = func() []byte {
+	j, err := json.Marshal(rateLimitDefaults)
+	if err != nil {
+		panic(err)
+	}
+	return j
+}()
+
+// rateLimitCosts lists routes where a single request may consume more than one token
+var rateLimitCosts = map[string]func(echo.Context) float64{
+	// large listings are proportionally more expensive
+	"/sp/eligible_pieces": func(c echo.Context) float64 {
+		lim, err := strconv.ParseUint(c.QueryParam("limit"), 10, 64)
+		if err != nil || lim <= listEligibleDefaultSize {
+			return 1
+		}
+		return math.Ceil(float64(lim) / listEligibleDefaultSize)
+	},
+}
+
+// spRateLimited applies the token-bucket limits of spID for the current route, responding with
+// errRateLimited, HTTP 429 and a Retry-After header when exceeded. The buckets are kept in the
+// database, so that the limits hold across all webapi instances. Invoked by spidAuth as soon
+// as the SP is authenticated.
+func spRateLimited(c echo.Context, spID fil.ActorID) (bool, error) {
+	ctx := c.Request().Context()
+
+	route := c.Path()
+	cost := float64(1)
+	if costFn, found := rateLimitCosts[route]; found {
+		cost = costFn(c)
+	}
+
+	var retryAfterSecs float64
+	if err := app.GetGlobalCtx(ctx).Db[app.DbMain].QueryRow(
+		ctx,
+		`
+		WITH
+			lim AS (
+				SELECT
+						COALESCE( $3::JSONB->'*', '{}' )
+							|| COALESCE( $3::JSONB->$2, '{}' )
+							|| COALESCE( g.metadata->'rate_limits'->'*', '{}' )
+							|| COALESCE( g.metadata->'rate_limits'->$2, '{}' )
+							|| COALESCE( p.provider_meta->'rate_limits'->'*', '{}' )
+							|| COALESCE( p.provider_meta->'rate_limits'->$2, '{}' )
+						AS l
+					FROM spd.global g
+					LEFT JOIN spd.providers p
+						ON p.provider_id = $1
+			)
+		SELECT spd.rate_limit_take(
+				$1,
+				$2,
+				( l->>'burst' )::DOUBLE PRECISION,
+				( l->>'per_minute' )::DOUBLE PRECISION / 60,
+				$4::DOUBLE PRECISION
+			)
+			FROM lim
+		`,
+		spID,
+		route,
+		rateLimitDefaultsJSON,
+		cost,
+	).Scan(&retryAfterSecs); err != nil {
+		return false, cmn.WrErr(err)
+	}
+
+	if retryAfterSecs > 0 {
+		retryAfter := int(math.Ceil(retryAfterSecs))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return true, retPayloadAnnotated(
+			c,
+			http.StatusTooManyRequests,
+			errRateLimited,
+			nil,
+			"Request rate limit for %s exceeded by SP %s, retry in %d seconds",
+			route,
+			spID,
+			retryAfter,
+		)
+	}
+
+	return false, nil
+}
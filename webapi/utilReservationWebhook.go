@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	apitypes "github.com/data-preservation-programs/go-spade-apitypes"
+	"github.com/ribasushi/go-toolbox/cmn"
+	"golang.org/x/xerrors"
+)
+
+// reservationWebhook is the optional tenant_meta->'reservation_webhook' configuration of a tenant
+//
+//	{
+//	  "url": "https://tenant.example.com/spade/approve",
+//	  "hmac_secret": "...",
+//	  "timeout_msecs": 800,
+//	  "fail_open": false
+//	}
+type reservationWebhook struct {
+	URL          string `json:"url"`
+	HMACSecret   string `json:"hmac_secret"`
+	TimeoutMsecs int64  `json:"timeout_msecs"`
+	FailOpen     bool   `json:"fail_open"` // whether to grant the reservation when the webhook is unreachable or misbehaves
+}
+
+type reservationWebhookProvider struct {
+	ProviderID  string `json:"provider_id"`
+	OrgID       int16  `json:"org_id"`
+	CityID      int16  `json:"city_id"`
+	CountryID   int16  `json:"country_id"`
+	ContinentID int16  `json:"continent_id"`
+}
+
+type reservationWebhookPiece struct {
+	PieceCid        string `json:"piece_cid"`
+	PaddedPieceSize int64  `json:"padded_piece_size"`
+	ProposalLabel   string `json:"proposal_label"`
+}
+
+// reservationWebhookRequest is the body POSTed to the tenant webhook. The body is signed with
+// HMAC-SHA256 using the tenant's hmac_secret, and the hex-encoded result is sent in the
+// X-SPADE-SIGNATURE header as "sha256=...". Tenants are expected to reject requests with a
+// timestamp too far in the past.
+type reservationWebhookRequest struct {
+	Timestamp        time.Time                       `json:"timestamp"`
+	TenantID         int16                           `json:"tenant_id"`
+	TenantClient     string                          `json:"tenant_client"`
+	Provider         reservationWebhookProvider      `json:"provider"`
+	Piece            reservationWebhookPiece         `json:"piece"`
+	ReplicationState apitypes.TenantReplicationState `json:"replication_state"`
+}
+
+// reservationWebhookResponse is the only acceptable response, with HTTP code 200.
+// Anything else is treated as a webhook failure, subject to the fail_open policy.
+type reservationWebhookResponse struct {
+	Approve *bool  `json:"approve"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+var webhookClient = &http.Client{
+	// never follow redirects: the tenant must configure the final URL
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// consultReservationWebhook asks the tenant whether it agrees with the reservation Spade is about
+// to make. A non-nil error indicates the webhook could not render a decision: the caller is
+// expected to apply the fail_open policy in that case.
+func consultReservationWebhook(ctx context.Context, wh reservationWebhook, req reservationWebhookRequest) (approved bool, reason string, err error) {
+	timeout := time.Duration(wh.TimeoutMsecs) * time.Millisecond
+	if timeout <= 0 || timeout > reservationWebhookMaxTimeout {
+		timeout = reservationWebhookMaxTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, "", cmn.WrErr(err)
+	}
+	mac := hmac.New(sha256.New, []byte(wh.HMACSecret))
+	mac.Write(body) //nolint:errcheck
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, "", cmn.WrErr(err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("X-SPADE-SIGNATURE", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(hreq)
+	if err != nil {
+		return false, "", cmn.WrErr(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return false, "", xerrors.Errorf("webhook %s returned unexpected HTTP code %d", wh.URL, resp.StatusCode)
+	}
+
+	var whResp reservationWebhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<10)).Decode(&whResp); err != nil {
+		return false, "", xerrors.Errorf("webhook %s returned undecodable response: %w", wh.URL, err)
+	}
+	if whResp.Approve == nil {
+		return false, "", xerrors.Errorf("webhook %s response lacks an 'approve' field", wh.URL)
+	}
+
+	return *whResp.Approve, whResp.Reason, nil
+}